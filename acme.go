@@ -0,0 +1,47 @@
+package giu
+
+import (
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type AcmeParams struct {
+	// Domains are the host names allowed to request certificates.
+	Domains []string
+	// Email is the contact email of the ACME account, optional.
+	Email string
+	// CacheDir is the directory to store certificates, default is "certs".
+	// Set a custom cache with GinServer.Autocert().Cache to store them elsewhere.
+	CacheDir string
+	// HTTPAddr is the address serving HTTP-01 challenges and redirecting http to https, default is ":80", "-" disables it.
+	HTTPAddr string
+	// DirectoryURL is the ACME directory, default is Let's Encrypt production.
+	DirectoryURL string
+}
+
+var _defaultAcmeParams = AcmeParams{
+	CacheDir: "certs",
+	HTTPAddr: ":80",
+}
+
+// NewAutocertManager creates an autocert manager, if cache is not set, a DirCache of params.CacheDir is used.
+func NewAutocertManager(params AcmeParams, cache ...autocert.Cache) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(params.Domains...),
+		Email:      params.Email,
+	}
+	if len(cache) > 0 && cache[0] != nil {
+		m.Cache = cache[0]
+	} else {
+		dir := params.CacheDir
+		if dir == "" {
+			dir = _defaultAcmeParams.CacheDir
+		}
+		m.Cache = autocert.DirCache(dir)
+	}
+	if params.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: params.DirectoryURL}
+	}
+	return m
+}
@@ -0,0 +1,118 @@
+package giu
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// AdminParams guard the internal endpoints mounted by giu, every configured check must pass.
+// If nothing is configured, all requests are rejected.
+type AdminParams struct {
+	// Prefix is the path prefix of the admin group, default is "/_giu".
+	Prefix string
+	// Token is the static token sent as "Authorization: Bearer <token>" or in GIN_ADMIN_TOKEN_HEADER.
+	Token string
+	// ClientCNs is the allowlist of mTLS client certificate common names,
+	// the server must verify client certificates, e.g. tls.Config.ClientAuth = tls.RequireAndVerifyClientCert.
+	ClientCNs []string
+	// CIDRs is the allowlist of client ips or CIDRs, e.g. the internal networks.
+	CIDRs []string
+	// TrustedProxies are the proxies whose X-Forwarded-For header is trusted when checking CIDRs.
+	TrustedProxies []string
+}
+
+var _defaultAdminParams = AdminParams{
+	Prefix: "/_giu",
+}
+
+var GIN_ADMIN_TOKEN_HEADER = "X-Giu-Admin-Token"
+
+var (
+	ERR_ADMIN_UNAUTHORIZED   = errors.New("admin token is missing or invalid")
+	ERR_ADMIN_CN_FORBIDDEN   = errors.New("client certificate is missing or not allowed")
+	ERR_ADMIN_IP_FORBIDDEN   = errors.New("client ip is not allowed")
+	ERR_ADMIN_NOT_CONFIGURED = errors.New("admin guard is not configured")
+)
+
+// NewGinMiddlewareAdminGuard returns a gin middleware which rejects requests failing any configured check of params.
+func NewGinMiddlewareAdminGuard(params AdminParams, zl *zap.Logger) (gin.HandlerFunc, error) {
+	cidrs, err := newIPMatcher(params.CIDRs)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := NewClientIPResolver(params.TrustedProxies, 0)
+	if err != nil {
+		return nil, err
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "admin"))
+	check := func(c *gin.Context) (int, error) {
+		if params.Token == "" && len(params.ClientCNs) == 0 && len(cidrs) == 0 {
+			return http.StatusForbidden, ERR_ADMIN_NOT_CONFIGURED
+		}
+		if len(cidrs) > 0 {
+			if ip := resolver.Resolve(c.Request); ip == nil || !cidrs.contains(ip) {
+				return http.StatusForbidden, ERR_ADMIN_IP_FORBIDDEN
+			}
+		}
+		if len(params.ClientCNs) > 0 {
+			tls := c.Request.TLS
+			if tls == nil || len(tls.VerifiedChains) == 0 || !slices.Contains(params.ClientCNs, tls.VerifiedChains[0][0].Subject.CommonName) {
+				return http.StatusForbidden, ERR_ADMIN_CN_FORBIDDEN
+			}
+		}
+		if params.Token != "" {
+			token := c.GetHeader(GIN_ADMIN_TOKEN_HEADER)
+			if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+				token = bearer
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(params.Token)) != 1 {
+				return http.StatusUnauthorized, ERR_ADMIN_UNAUTHORIZED
+			}
+		}
+		return 0, nil
+	}
+	return func(c *gin.Context) {
+		if status, err := check(c); err != nil {
+			zl.Warn("[gin admin] request rejected",
+				zap.Error(err),
+				zap.String("remote_addr", c.Request.RemoteAddr),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)))
+			c.AbortWithStatus(status)
+			return
+		}
+		c.Next()
+	}, nil
+}
+
+// NewAdminGroup returns the guarded route group for internal endpoints, all admin endpoints of giu are mounted on it.
+func NewAdminGroup(r gin.IRouter, params AdminParams, zl *zap.Logger) (*gin.RouterGroup, error) {
+	if params.Prefix == "" {
+		params.Prefix = _defaultAdminParams.Prefix
+	}
+	guard, err := NewGinMiddlewareAdminGuard(params, zl)
+	if err != nil {
+		return nil, err
+	}
+	return r.Group(params.Prefix, guard), nil
+}
+
+// NewAdminGroupFromConfig creates the admin group from viper config with key "admin".
+func NewAdminGroupFromConfig(config *viper.Viper, r gin.IRouter, zl *zap.Logger) (*gin.RouterGroup, error) {
+	var params AdminParams
+	if err := config.UnmarshalKey("admin", &params); err != nil {
+		return nil, err
+	}
+	return NewAdminGroup(r, params, zl)
+}
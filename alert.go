@@ -0,0 +1,275 @@
+package giu
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+)
+
+// Alert is an operational notification sent by an Alerter.
+type Alert struct {
+	Level   string            `json:"level"` // log level: info, warn, error...
+	Title   string            `json:"title"`
+	Content string            `json:"content"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Time    time.Time         `json:"time"`
+}
+
+// Text formats the alert as plain text, used by chat and email backends.
+func (a Alert) Text() string {
+	var sb strings.Builder
+	if a.Level != "" {
+		sb.WriteString("[" + strings.ToUpper(a.Level) + "] ")
+	}
+	sb.WriteString(a.Title)
+	if a.Content != "" {
+		sb.WriteString("\n" + a.Content)
+	}
+	keys := make([]string, 0, len(a.Fields))
+	for k := range a.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sb.WriteString("\n" + k + ": " + a.Fields[k])
+	}
+	if !a.Time.IsZero() {
+		sb.WriteString("\n" + a.Time.Format(time.RFC3339))
+	}
+	return sb.String()
+}
+
+type Alerter interface {
+	Alert(ctx context.Context, alert Alert) error
+}
+
+// AlerterFunc is an adapter to allow the use of ordinary functions as Alerter.
+type AlerterFunc func(ctx context.Context, alert Alert) error
+
+func (f AlerterFunc) Alert(ctx context.Context, alert Alert) error {
+	return f(ctx, alert)
+}
+
+// MultiAlerter sends the alert to every alerter and joins the errors.
+type MultiAlerter []Alerter
+
+func (m MultiAlerter) Alert(ctx context.Context, alert Alert) error {
+	var errs []error
+	for _, a := range m {
+		if err := a.Alert(ctx, alert); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type AlerterParams struct {
+	// Type is the alerter backend: webhook, email, dingtalk, feishu, slack.
+	Type string
+	// URL is the webhook url, used by webhook, dingtalk, feishu and slack.
+	URL string
+	// Secret is used to sign the request, used by webhook, dingtalk and feishu.
+	Secret string
+	// Timeout is the http request timeout, default is 5s.
+	Timeout time.Duration
+	// SMTPAddr is the smtp server address with port, used by email.
+	SMTPAddr string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+const (
+	ALERTER_TYPE_WEBHOOK  = "webhook"
+	ALERTER_TYPE_EMAIL    = "email"
+	ALERTER_TYPE_DINGTALK = "dingtalk"
+	ALERTER_TYPE_FEISHU   = "feishu"
+	ALERTER_TYPE_SLACK    = "slack"
+)
+
+// NewAlerter creates an alerter by params type.
+func NewAlerter(params *AlerterParams) (Alerter, error) {
+	switch params.Type {
+	case ALERTER_TYPE_WEBHOOK:
+		return NewWebhookAlerter(params), nil
+	case ALERTER_TYPE_EMAIL:
+		return NewEmailAlerter(params), nil
+	case ALERTER_TYPE_DINGTALK:
+		return NewDingTalkAlerter(params), nil
+	case ALERTER_TYPE_FEISHU:
+		return NewFeishuAlerter(params), nil
+	case ALERTER_TYPE_SLACK:
+		return NewSlackAlerter(params), nil
+	default:
+		return nil, fmt.Errorf("unsupported alerter type: %s", params.Type)
+	}
+}
+
+func newAlerterClient(params *AlerterParams) *resty.Client {
+	timeout := params.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	return NewResty(&RestyParams{Timeout: timeout})
+}
+
+func postAlert(ctx context.Context, req *resty.Request, url string, body interface{}) error {
+	resp, err := req.SetContext(ctx).SetBody(body).Post(url)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("alert request failed, status: %d, body: %s", resp.StatusCode(), resp.String())
+	}
+	return nil
+}
+
+// WebhookAlerter posts the alert as json to the url.
+// If secret is set, the request is signed, see SignRequest.
+type WebhookAlerter struct {
+	client *resty.Client
+	url    string
+	secret string
+}
+
+func NewWebhookAlerter(params *AlerterParams) *WebhookAlerter {
+	return &WebhookAlerter{client: newAlerterClient(params), url: params.URL, secret: params.Secret}
+}
+
+func (w *WebhookAlerter) Alert(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req := w.client.R().SetHeader("Content-Type", "application/json")
+	if w.secret != "" {
+		target, err := url.Parse(w.url)
+		if err != nil {
+			return err
+		}
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := uuid.New().String()
+		req.SetHeader(SIGNATURE_HEADER_TIMESTAMP, ts)
+		req.SetHeader(SIGNATURE_HEADER_NONCE, nonce)
+		req.SetHeader(SIGNATURE_HEADER_SIGNATURE, SignRequest(w.secret, http.MethodPost, target.RequestURI(), ts, nonce, body))
+	}
+	return postAlert(ctx, req, w.url, body)
+}
+
+// SignPayload returns hex encoded HMAC-SHA256 of "timestamp.body" with secret. It doesn't cover the request and
+// nonce, SignatureVerifier only accepts SignRequest.
+func SignPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type EmailAlerter struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+func NewEmailAlerter(params *AlerterParams) *EmailAlerter {
+	var auth smtp.Auth
+	if params.Username != "" {
+		host := params.SMTPAddr
+		if i := strings.LastIndex(host, ":"); i > 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", params.Username, params.Password, host)
+	}
+	return &EmailAlerter{addr: params.SMTPAddr, auth: auth, from: params.From, to: params.To}
+}
+
+func (e *EmailAlerter) Alert(ctx context.Context, alert Alert) error {
+	var sb strings.Builder
+	sb.WriteString("From: " + e.from + "\r\n")
+	sb.WriteString("To: " + strings.Join(e.to, ",") + "\r\n")
+	sb.WriteString("Subject: " + alert.Title + "\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	sb.WriteString(strings.ReplaceAll(alert.Text(), "\n", "\r\n"))
+	return smtp.SendMail(e.addr, e.auth, e.from, e.to, []byte(sb.String()))
+}
+
+type DingTalkAlerter struct {
+	client *resty.Client
+	url    string
+	secret string
+}
+
+func NewDingTalkAlerter(params *AlerterParams) *DingTalkAlerter {
+	return &DingTalkAlerter{client: newAlerterClient(params), url: params.URL, secret: params.Secret}
+}
+
+func (d *DingTalkAlerter) Alert(ctx context.Context, alert Alert) error {
+	u := d.url
+	if d.secret != "" {
+		ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write([]byte(ts + "\n" + d.secret))
+		sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		u += "&timestamp=" + ts + "&sign=" + url.QueryEscape(sign)
+	}
+	body := map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": alert.Text()},
+	}
+	return postAlert(ctx, d.client.R(), u, body)
+}
+
+type FeishuAlerter struct {
+	client *resty.Client
+	url    string
+	secret string
+}
+
+func NewFeishuAlerter(params *AlerterParams) *FeishuAlerter {
+	return &FeishuAlerter{client: newAlerterClient(params), url: params.URL, secret: params.Secret}
+}
+
+func (f *FeishuAlerter) Alert(ctx context.Context, alert Alert) error {
+	body := map[string]interface{}{
+		"msg_type": "text",
+		"content":  map[string]string{"text": alert.Text()},
+	}
+	if f.secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(ts+"\n"+f.secret))
+		body["timestamp"] = ts
+		body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return postAlert(ctx, f.client.R(), f.url, body)
+}
+
+type SlackAlerter struct {
+	client *resty.Client
+	url    string
+}
+
+func NewSlackAlerter(params *AlerterParams) *SlackAlerter {
+	return &SlackAlerter{client: newAlerterClient(params), url: params.URL}
+}
+
+func (s *SlackAlerter) Alert(ctx context.Context, alert Alert) error {
+	return postAlert(ctx, s.client.R(), s.url, map[string]string{"text": alert.Text()})
+}
@@ -0,0 +1,365 @@
+package giu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AUDIT_ACTION_CREATE = "create"
+	AUDIT_ACTION_UPDATE = "update"
+	AUDIT_ACTION_DELETE = "delete"
+)
+
+// AuditRecord is a row of the audit table, images are json of the row columns.
+type AuditRecord struct {
+	ID         uint64 `gorm:"primaryKey"`
+	TableName  string `gorm:"size:128;index"`
+	Action     string `gorm:"size:16"`
+	PrimaryKey string `gorm:"size:191;index"`
+	Before     string `gorm:"type:text"`
+	After      string `gorm:"type:text"`
+	Actor      string `gorm:"size:128"`
+	TraceID    string `gorm:"size:64"`
+	CreatedAt  time.Time
+}
+
+// AuditPlugin records before/after images of changed rows into the audit table.
+// A model is audited if its table is in Tables or any of its fields has tag `giu:"audit"`.
+// The actor and trace id are read from the RequestScope of the statement context.
+// The values of fields with a serializer, e.g. `serializer:encrypt`, are masked as AUDIT_MASK in the images.
+// Records are queued to the audit channel and written by a background writer, except the ones of transactions
+// started by callers, which are written in the transaction so they are rolled back with it.
+type AuditPlugin struct {
+	// Table is the audit table, default is "giu_audits".
+	Table string
+	// Tables are the audited tables besides the tagged models.
+	Tables []string
+	// MaxRows is the max rows of the before images of an update or delete, default is 1000, the other rows are
+	// not audited.
+	MaxRows int
+	// Buffer is the max queued records, the records are written on the request path when it's full,
+	// default is 1000.
+	Buffer int
+
+	tables  map[string]bool
+	tagged  sync.Map // *schema.Schema -> bool
+	db      *gorm.DB
+	records chan AuditRecord
+	lock    sync.RWMutex
+	closed  bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+const (
+	auditBeforeKey = "giu:audit_before"
+	// auditBatchSize is the max records of each write of the background writer.
+	auditBatchSize = 100
+)
+
+// AUDIT_MASK replaces the values of masked fields in audit images.
+const AUDIT_MASK = "***"
+
+func (p *AuditPlugin) Name() string {
+	return "giu:audit"
+}
+
+func (p *AuditPlugin) Initialize(db *gorm.DB) error {
+	if p.Table == "" {
+		p.Table = "giu_audits"
+	}
+	if p.MaxRows <= 0 {
+		p.MaxRows = 1000
+	}
+	if p.Buffer <= 0 {
+		p.Buffer = 1000
+	}
+	p.tables = make(map[string]bool, len(p.Tables))
+	for _, t := range p.Tables {
+		p.tables[t] = true
+	}
+	if err := db.Table(p.Table).AutoMigrate(&AuditRecord{}); err != nil {
+		return err
+	}
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register(p.Name()+"_create", p.afterCreate); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register(p.Name()+"_before_update", p.before); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register(p.Name()+"_update", p.afterUpdate); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register(p.Name()+"_before_delete", p.before); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register(p.Name()+"_delete", p.afterDelete); err != nil {
+		return err
+	}
+	p.db = db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	p.records = make(chan AuditRecord, p.Buffer)
+	p.done = make(chan struct{})
+	go p.run()
+	return nil
+}
+
+// Close stops the background writer after the queued records are written, the records of later changes are
+// written on the request path. The connections of the gorm provider close it before the db is closed.
+func (p *AuditPlugin) Close() {
+	if p.records == nil {
+		return
+	}
+	p.once.Do(func() {
+		p.lock.Lock()
+		p.closed = true
+		close(p.records)
+		p.lock.Unlock()
+		<-p.done
+	})
+}
+
+func (p *AuditPlugin) run() {
+	defer close(p.done)
+	batch := make([]AuditRecord, 0, auditBatchSize)
+	for r := range p.records {
+		batch = append(batch, r)
+	drain:
+		for len(batch) < auditBatchSize {
+			select {
+			case r, ok := <-p.records:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, r)
+			default:
+				break drain
+			}
+		}
+		if err := p.db.Table(p.Table).Create(&batch).Error; err != nil {
+			p.db.Logger.Error(context.Background(), "audit: write %d records: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+}
+
+func (p *AuditPlugin) enabled(stmt *gorm.Statement) bool {
+	if stmt.Schema == nil || stmt.Table == p.Table || stmt.DB.DryRun {
+		return false
+	}
+	if p.tables[stmt.Table] {
+		return true
+	}
+	if v, ok := p.tagged.Load(stmt.Schema); ok {
+		return v.(bool)
+	}
+	tagged := false
+	for _, f := range stmt.Schema.Fields {
+		if strings.Contains(f.Tag.Get("giu"), "audit") {
+			tagged = true
+			break
+		}
+	}
+	p.tagged.Store(stmt.Schema, tagged)
+	return tagged
+}
+
+// before queries the rows which will be changed.
+func (p *AuditPlugin) before(tx *gorm.DB) {
+	if tx.Error != nil || !p.enabled(tx.Statement) {
+		return
+	}
+	stmt := tx.Statement
+	q := tx.Session(&gorm.Session{NewDB: true}).Table(stmt.Table)
+	conditions := 0
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+			q = q.Clauses(where)
+			conditions++
+		}
+	}
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		for _, f := range stmt.Schema.PrimaryFields {
+			if v, zero := f.ValueOf(stmt.Context, stmt.ReflectValue); !zero {
+				q = q.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: v})
+				conditions++
+			}
+		}
+	}
+	if conditions == 0 {
+		return
+	}
+	var rows []map[string]interface{}
+	if err := q.Limit(p.MaxRows).Find(&rows).Error; err != nil {
+		_ = tx.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	tx.InstanceSet(auditBeforeKey, rows)
+}
+
+func (p *AuditPlugin) afterCreate(tx *gorm.DB) {
+	if tx.Error != nil || !p.enabled(tx.Statement) {
+		return
+	}
+	stmt := tx.Statement
+	var records []AuditRecord
+	for _, row := range modelRows(stmt) {
+		records = append(records, p.record(stmt, AUDIT_ACTION_CREATE, nil, row))
+	}
+	p.save(tx, records)
+}
+
+func (p *AuditPlugin) afterUpdate(tx *gorm.DB) {
+	if tx.Error != nil || !p.enabled(tx.Statement) {
+		return
+	}
+	before := beforeRows(tx)
+	if len(before) == 0 {
+		return
+	}
+	stmt := tx.Statement
+	var records []AuditRecord
+	for _, b := range before {
+		q := tx.Session(&gorm.Session{NewDB: true}).Table(stmt.Table)
+		for _, f := range stmt.Schema.PrimaryFields {
+			q = q.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: b[f.DBName]})
+		}
+		var after map[string]interface{}
+		if err := q.Take(&after).Error; err != nil {
+			after = nil
+		}
+		records = append(records, p.record(stmt, AUDIT_ACTION_UPDATE, b, after))
+	}
+	p.save(tx, records)
+}
+
+func (p *AuditPlugin) afterDelete(tx *gorm.DB) {
+	if tx.Error != nil || !p.enabled(tx.Statement) {
+		return
+	}
+	var records []AuditRecord
+	for _, b := range beforeRows(tx) {
+		records = append(records, p.record(tx.Statement, AUDIT_ACTION_DELETE, b, nil))
+	}
+	p.save(tx, records)
+}
+
+func (p *AuditPlugin) record(stmt *gorm.Statement, action string, before, after map[string]interface{}) AuditRecord {
+	r := AuditRecord{TableName: stmt.Table, Action: action, CreatedAt: time.Now()}
+	image := after
+	if image == nil {
+		image = before
+	}
+	var pks []string
+	for _, f := range stmt.Schema.PrimaryFields {
+		pks = append(pks, fmt.Sprint(image[f.DBName]))
+	}
+	r.PrimaryKey = strings.Join(pks, ",")
+	if before != nil {
+		data, _ := json.Marshal(maskAuditRow(stmt, before))
+		r.Before = string(data)
+	}
+	if after != nil {
+		data, _ := json.Marshal(maskAuditRow(stmt, after))
+		r.After = string(data)
+	}
+	if scope, ok := RequestScopeFromContext(stmt.Context); ok {
+		r.Actor = scope.User
+		r.TraceID = scope.TraceID
+	}
+	return r
+}
+
+func (p *AuditPlugin) save(tx *gorm.DB, records []AuditRecord) {
+	if len(records) == 0 {
+		return
+	}
+	_, inTx := tx.Statement.ConnPool.(gorm.TxCommitter)
+	if _, started := tx.InstanceGet("gorm:started_transaction"); inTx && !started {
+		// the transaction of the caller may be rolled back, so the records are written in it
+		p.write(tx, records)
+		return
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for i, r := range records {
+		if p.closed {
+			p.write(tx, records[i:])
+			return
+		}
+		select {
+		case p.records <- r:
+		default:
+			// the channel is full, write the rest on the request path instead of dropping them
+			p.write(tx, records[i:])
+			return
+		}
+	}
+}
+
+// write creates the records with the connection pool of tx.
+func (p *AuditPlugin) write(tx *gorm.DB, records []AuditRecord) {
+	if err := tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Table(p.Table).Create(&records).Error; err != nil {
+		_ = tx.AddError(fmt.Errorf("audit: %w", err))
+	}
+}
+
+func beforeRows(tx *gorm.DB) []map[string]interface{} {
+	v, ok := tx.InstanceGet(auditBeforeKey)
+	if !ok {
+		return nil
+	}
+	rows, _ := v.([]map[string]interface{})
+	return rows
+}
+
+// maskAuditRow masks the values of the fields with a serializer. The images of creates are model values and the
+// others are raw columns, e.g. plaintext and ciphertext of encrypted fields, neither should be recorded.
+func maskAuditRow(stmt *gorm.Statement, row map[string]interface{}) map[string]interface{} {
+	for _, f := range stmt.Schema.Fields {
+		if f.Serializer == nil || f.DBName == "" {
+			continue
+		}
+		if _, ok := row[f.DBName]; ok {
+			row[f.DBName] = AUDIT_MASK
+		}
+	}
+	return row
+}
+
+// modelRows converts the created models to column maps.
+func modelRows(stmt *gorm.Statement) []map[string]interface{} {
+	toRow := func(rv reflect.Value) map[string]interface{} {
+		row := make(map[string]interface{})
+		for _, f := range stmt.Schema.Fields {
+			if f.DBName == "" || f.DataType == "" {
+				continue
+			}
+			v, _ := f.ValueOf(stmt.Context, rv)
+			row[f.DBName] = v
+		}
+		return row
+	}
+	var rows []map[string]interface{}
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			rv := reflect.Indirect(stmt.ReflectValue.Index(i))
+			if rv.Kind() == reflect.Struct {
+				rows = append(rows, toRow(rv))
+			}
+		}
+	case reflect.Struct:
+		rows = append(rows, toRow(stmt.ReflectValue))
+	}
+	return rows
+}
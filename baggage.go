@@ -0,0 +1,85 @@
+package giu
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/baggage"
+)
+
+var (
+	BAGGAGE_KEY_USER   = "user.id"
+	BAGGAGE_KEY_TENANT = "tenant.id"
+)
+
+// BAGGAGE_HEADER is the W3C baggage header.
+const BAGGAGE_HEADER = "baggage"
+
+// WithBaggageIdentity returns a copy of ctx with user and tenant in its otel baggage, empty values are skipped.
+// The request scope in ctx is updated too, so they appear in logs and are propagated by giu resty clients with Propagate.
+func WithBaggageIdentity(ctx context.Context, user, tenant string) (context.Context, error) {
+	b := baggage.FromContext(ctx)
+	for key, value := range map[string]string{BAGGAGE_KEY_USER: user, BAGGAGE_KEY_TENANT: tenant} {
+		if value == "" {
+			continue
+		}
+		m, err := baggage.NewMemberRaw(key, value)
+		if err != nil {
+			return ctx, err
+		}
+		if b, err = b.SetMember(m); err != nil {
+			return ctx, err
+		}
+	}
+	if scope, ok := RequestScopeFromContext(ctx); ok {
+		if user != "" {
+			scope.User = user
+		}
+		if tenant != "" {
+			scope.Tenant = tenant
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, b), nil
+}
+
+// BaggageIdentity returns the user and tenant in the otel baggage of ctx.
+func BaggageIdentity(ctx context.Context) (user, tenant string) {
+	b := baggage.FromContext(ctx)
+	return b.Member(BAGGAGE_KEY_USER).Value(), b.Member(BAGGAGE_KEY_TENANT).Value()
+}
+
+// contextWithHeaderBaggage adds the baggage header to ctx if ctx has no baggage yet, an invalid header is ignored.
+// The identity members are dropped unless the header is from a trusted proxy, so clients can't spoof them.
+func contextWithHeaderBaggage(ctx context.Context, header http.Header, trusted bool) context.Context {
+	if baggage.FromContext(ctx).Len() > 0 {
+		return ctx
+	}
+	b, err := baggage.Parse(header.Get(BAGGAGE_HEADER))
+	if err != nil {
+		return ctx
+	}
+	if !trusted {
+		b = b.DeleteMember(BAGGAGE_KEY_USER).DeleteMember(BAGGAGE_KEY_TENANT)
+	}
+	if b.Len() == 0 {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, b)
+}
+
+// headerBaggage returns the baggage header value of ctx with the identity of scope, empty if there's nothing.
+// The identity of scope overwrites the members in the baggage, it's the one checked by this service.
+func headerBaggage(ctx context.Context, scope *RequestScope) string {
+	b := baggage.FromContext(ctx)
+	if scope != nil {
+		for key, value := range map[string]string{BAGGAGE_KEY_USER: scope.User, BAGGAGE_KEY_TENANT: scope.Tenant} {
+			if value == "" {
+				continue
+			}
+			if m, err := baggage.NewMemberRaw(key, value); err == nil {
+				b, _ = b.SetMember(m)
+			}
+		}
+	}
+	return b.String()
+}
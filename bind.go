@@ -0,0 +1,78 @@
+package giu
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Bind binds uri params, query string and body (json or form, by content type) into T without validation.
+// Later sources override the earlier ones: uri, query, body.
+func Bind[T any](c *gin.Context) (T, error) {
+	var v T
+	if len(c.Params) > 0 {
+		params := make(map[string][]string, len(c.Params))
+		for _, p := range c.Params {
+			params[p.Key] = []string{p.Value}
+		}
+		if err := binding.MapFormWithTag(&v, params, "uri"); err != nil {
+			return v, err
+		}
+	}
+	if err := binding.MapFormWithTag(&v, c.Request.URL.Query(), "form"); err != nil {
+		return v, err
+	}
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return v, nil
+	}
+	switch filterFlags(c.ContentType()) {
+	case binding.MIMEJSON:
+		if err := json.NewDecoder(c.Request.Body).Decode(&v); err != nil && !errors.Is(err, io.EOF) {
+			return v, err
+		}
+	case binding.MIMEPOSTForm:
+		if err := c.Request.ParseForm(); err != nil {
+			return v, err
+		}
+		if err := binding.MapFormWithTag(&v, c.Request.PostForm, "form"); err != nil {
+			return v, err
+		}
+	case binding.MIMEMultipartPOSTForm:
+		if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+			return v, err
+		}
+		if err := binding.MapFormWithTag(&v, c.Request.MultipartForm.Value, "form"); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// BindAndValidate binds T like Bind and validates it with the "binding" tags.
+func BindAndValidate[T any](c *gin.Context) (T, error) {
+	v, err := Bind[T](c)
+	if err != nil {
+		return v, err
+	}
+	if binding.Validator != nil {
+		if err := binding.Validator.ValidateStruct(&v); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// MustBind binds and validates T, if it fails, the request is aborted with 400 and the error envelope.
+// The handler should return directly when ok is false.
+func MustBind[T any](c *gin.Context) (v T, ok bool) {
+	v, err := BindAndValidate[T](c)
+	if err != nil {
+		AbortWithErrorResponse(c, http.StatusBadRequest, err)
+		return v, false
+	}
+	return v, true
+}
@@ -0,0 +1,257 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisModuleUnknown int32 = iota
+	redisModuleLoaded
+	redisModuleAbsent
+)
+
+// isUnknownCommand reports whether err means the command is not supported, e.g. the module is not loaded.
+func isUnknownCommand(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "ERR unknown command")
+}
+
+type BloomFilterParams struct {
+	Key string
+	// Capacity is the expected number of items, default is 1000000.
+	Capacity int64
+	// ErrorRate is the false positive rate, default is 0.01.
+	ErrorRate float64
+}
+
+// BloomFilter uses RedisBloom BF.* commands, it falls back to a bitmap with SETBIT/GETBIT if the module is absent.
+type BloomFilter struct {
+	rdb    redis.UniversalClient
+	params BloomFilterParams
+	module atomic.Int32
+	// bitmap fallback
+	bits   uint64
+	hashes int
+}
+
+func NewBloomFilter(rdb redis.UniversalClient, params BloomFilterParams) *BloomFilter {
+	if params.Capacity <= 0 {
+		params.Capacity = 1000000
+	}
+	if params.ErrorRate <= 0 || params.ErrorRate >= 1 {
+		params.ErrorRate = 0.01
+	}
+	m := math.Ceil(-float64(params.Capacity) * math.Log(params.ErrorRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(params.Capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &BloomFilter{rdb: rdb, params: params, bits: uint64(m), hashes: k}
+}
+
+func (bf *BloomFilter) fallback(ctx context.Context) (bool, error) {
+	switch bf.module.Load() {
+	case redisModuleLoaded:
+		return false, nil
+	case redisModuleAbsent:
+		return true, nil
+	}
+	err := bf.rdb.Do(ctx, "BF.RESERVE", bf.params.Key, bf.params.ErrorRate, bf.params.Capacity).Err()
+	if isUnknownCommand(err) {
+		bf.module.Store(redisModuleAbsent)
+		return true, nil
+	}
+	// "item exists" means the filter is reserved already
+	if err != nil && !strings.Contains(err.Error(), "exists") {
+		return false, err
+	}
+	bf.module.Store(redisModuleLoaded)
+	return false, nil
+}
+
+// offsets returns the bit offsets of item with double hashing.
+func (bf *BloomFilter) offsets(item string) []int64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	offsets := make([]int64, bf.hashes)
+	for i := range offsets {
+		offsets[i] = int64((h1 + uint64(i)*h2) % bf.bits)
+	}
+	return offsets
+}
+
+// Add adds item and reports whether it's added newly.
+func (bf *BloomFilter) Add(ctx context.Context, item string) (bool, error) {
+	fb, err := bf.fallback(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !fb {
+		return bf.rdb.Do(ctx, "BF.ADD", bf.params.Key, item).Bool()
+	}
+	pipe := bf.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, bf.hashes)
+	for i, off := range bf.offsets(item) {
+		cmds[i] = pipe.SetBit(ctx, bf.params.Key, off, 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Exists reports whether item may exist.
+func (bf *BloomFilter) Exists(ctx context.Context, item string) (bool, error) {
+	fb, err := bf.fallback(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !fb {
+		return bf.rdb.Do(ctx, "BF.EXISTS", bf.params.Key, item).Bool()
+	}
+	pipe := bf.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, bf.hashes)
+	for i, off := range bf.offsets(item) {
+		cmds[i] = pipe.GetBit(ctx, bf.params.Key, off)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+type CuckooFilterParams struct {
+	Key string
+	// Capacity is the expected number of items, default is 1000000.
+	Capacity int64
+}
+
+// CuckooFilter uses RedisBloom CF.* commands, it falls back to an exact redis set if the module is absent.
+type CuckooFilter struct {
+	rdb    redis.UniversalClient
+	params CuckooFilterParams
+	module atomic.Int32
+}
+
+func NewCuckooFilter(rdb redis.UniversalClient, params CuckooFilterParams) *CuckooFilter {
+	if params.Capacity <= 0 {
+		params.Capacity = 1000000
+	}
+	return &CuckooFilter{rdb: rdb, params: params}
+}
+
+func (cf *CuckooFilter) fallback(ctx context.Context) (bool, error) {
+	switch cf.module.Load() {
+	case redisModuleLoaded:
+		return false, nil
+	case redisModuleAbsent:
+		return true, nil
+	}
+	err := cf.rdb.Do(ctx, "CF.RESERVE", cf.params.Key, cf.params.Capacity).Err()
+	if isUnknownCommand(err) {
+		cf.module.Store(redisModuleAbsent)
+		return true, nil
+	}
+	if err != nil && !strings.Contains(err.Error(), "exists") {
+		return false, err
+	}
+	cf.module.Store(redisModuleLoaded)
+	return false, nil
+}
+
+// Add adds item if it doesn't exist and reports whether it's added.
+func (cf *CuckooFilter) Add(ctx context.Context, item string) (bool, error) {
+	fb, err := cf.fallback(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !fb {
+		return cf.rdb.Do(ctx, "CF.ADDNX", cf.params.Key, item).Bool()
+	}
+	n, err := cf.rdb.SAdd(ctx, cf.params.Key, item).Result()
+	return n == 1, err
+}
+
+// Exists reports whether item may exist.
+func (cf *CuckooFilter) Exists(ctx context.Context, item string) (bool, error) {
+	fb, err := cf.fallback(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !fb {
+		return cf.rdb.Do(ctx, "CF.EXISTS", cf.params.Key, item).Bool()
+	}
+	return cf.rdb.SIsMember(ctx, cf.params.Key, item).Result()
+}
+
+// Delete deletes item and reports whether it existed.
+func (cf *CuckooFilter) Delete(ctx context.Context, item string) (bool, error) {
+	fb, err := cf.fallback(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !fb {
+		ok, err := cf.rdb.Do(ctx, "CF.DEL", cf.params.Key, item).Bool()
+		if err != nil && strings.Contains(err.Error(), "not found") {
+			return false, nil
+		}
+		return ok, err
+	}
+	n, err := cf.rdb.SRem(ctx, cf.params.Key, item).Result()
+	return n == 1, err
+}
+
+// HyperLogLog counts the distinct items approximately with PFADD/PFCOUNT.
+type HyperLogLog struct {
+	rdb redis.UniversalClient
+	key string
+}
+
+func NewHyperLogLog(rdb redis.UniversalClient, key string) *HyperLogLog {
+	return &HyperLogLog{rdb: rdb, key: key}
+}
+
+// Add adds items and reports whether the estimated count is changed.
+func (h *HyperLogLog) Add(ctx context.Context, items ...string) (bool, error) {
+	if len(items) == 0 {
+		return false, errors.New("no items")
+	}
+	args := make([]interface{}, len(items))
+	for i, item := range items {
+		args[i] = item
+	}
+	n, err := h.rdb.PFAdd(ctx, h.key, args...).Result()
+	return n == 1, err
+}
+
+// Count returns the estimated count of distinct items.
+func (h *HyperLogLog) Count(ctx context.Context) (int64, error) {
+	return h.rdb.PFCount(ctx, h.key).Result()
+}
+
+// Merge merges the other counters into this one, the keys must be in the same slot in cluster mode.
+func (h *HyperLogLog) Merge(ctx context.Context, others ...*HyperLogLog) error {
+	keys := make([]string, len(others))
+	for i, o := range others {
+		keys[i] = o.key
+	}
+	return h.rdb.PFMerge(ctx, h.key, keys...).Err()
+}
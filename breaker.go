@@ -0,0 +1,265 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type BreakerParams struct {
+	// Window is the period of counting error rate, default is 10s.
+	Window time.Duration
+	// MinRequests is the min requests in window before the error rate is evaluated, default is 20.
+	MinRequests int
+	// ErrorRate is the ratio of 5xx responses to trip the breaker, default is 0.5.
+	ErrorRate float64
+	// OpenDuration is how long the breaker sheds load before probing, default is 30s.
+	OpenDuration time.Duration
+	// HalfOpenRequests is the number of probe requests allowed in half open state, default is 1.
+	HalfOpenRequests int
+}
+
+var _defaultBreakerParams = BreakerParams{
+	Window:           10 * time.Second,
+	MinRequests:      20,
+	ErrorRate:        0.5,
+	OpenDuration:     30 * time.Second,
+	HalfOpenRequests: 1,
+}
+
+var ERR_BREAKER_OPEN = errors.New("breaker is not closed")
+
+const (
+	BREAKER_STATE_CLOSED    = "closed"
+	BREAKER_STATE_OPEN      = "open"
+	BREAKER_STATE_HALF_OPEN = "half_open"
+)
+
+// Breaker is an inbound circuit breaker, it sheds load when the error rate spikes or the downstream is unhealthy.
+type Breaker struct {
+	name   string
+	params BreakerParams
+
+	clock       Clock
+	lock        sync.Mutex
+	state       string
+	healthy     bool
+	windowStart time.Time
+	total       int
+	failures    int
+	openedAt    time.Time
+	probes      int
+}
+
+func NewBreaker(name string, params BreakerParams) *Breaker {
+	if params.Window <= 0 {
+		params.Window = _defaultBreakerParams.Window
+	}
+	if params.MinRequests <= 0 {
+		params.MinRequests = _defaultBreakerParams.MinRequests
+	}
+	if params.ErrorRate <= 0 {
+		params.ErrorRate = _defaultBreakerParams.ErrorRate
+	}
+	if params.OpenDuration <= 0 {
+		params.OpenDuration = _defaultBreakerParams.OpenDuration
+	}
+	if params.HalfOpenRequests <= 0 {
+		params.HalfOpenRequests = _defaultBreakerParams.HalfOpenRequests
+	}
+	return &Breaker{
+		name:        name,
+		params:      params,
+		state:       BREAKER_STATE_CLOSED,
+		healthy:     true,
+		clock:       SystemClock,
+		windowStart: time.Now(),
+	}
+}
+
+// SetClock sets the time source of the breaker, nil means SystemClock.
+func (b *Breaker) SetClock(c Clock) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.clock = clockOrSystem(c)
+	b.windowStart = b.clock.Now()
+}
+
+// SetHealthy sets the downstream health state, the breaker rejects all requests while it's unhealthy.
+func (b *Breaker) SetHealthy(healthy bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.healthy = healthy
+}
+
+// WatchHealth runs checker every interval until ctx is done, the breaker is unhealthy while any item of checker
+// is unhealthy, e.g. the database of the route group is down. The default interval is PROVIDER_HEALTH_INTERVAL.
+func (b *Breaker) WatchHealth(ctx context.Context, checker HealthChecker, interval time.Duration) {
+	if interval <= 0 {
+		interval = PROVIDER_HEALTH_INTERVAL
+	}
+	check := func() {
+		healthy := true
+		for _, err := range checker.Health(ctx) {
+			if err != nil {
+				healthy = false
+				break
+			}
+		}
+		b.SetHealthy(healthy)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		check()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+}
+
+// Health reports the breaker by name, it's unhealthy with ERR_BREAKER_OPEN unless the state is closed, so the
+// breakers can be combined into the readiness checks, see CombineHealth.
+func (b *Breaker) Health(_ context.Context) map[string]error {
+	var err error
+	if state := b.State(); state != BREAKER_STATE_CLOSED {
+		err = fmt.Errorf("%w: %s", ERR_BREAKER_OPEN, state)
+	}
+	return map[string]error{b.name: err}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.healthy {
+		return BREAKER_STATE_OPEN
+	}
+	b.refresh(b.clock.Now())
+	return b.state
+}
+
+func (b *Breaker) refresh(now time.Time) {
+	if b.state == BREAKER_STATE_OPEN && now.Sub(b.openedAt) >= b.params.OpenDuration {
+		b.state = BREAKER_STATE_HALF_OPEN
+		b.probes = 0
+	}
+	if now.Sub(b.windowStart) >= b.params.Window {
+		b.windowStart = now
+		b.total = 0
+		b.failures = 0
+	}
+}
+
+// Allow reports whether a request can pass, if it can, done must be called with the result of the request.
+func (b *Breaker) Allow() (done func(success bool), ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.healthy {
+		return nil, false
+	}
+	b.refresh(b.clock.Now())
+	switch b.state {
+	case BREAKER_STATE_OPEN:
+		return nil, false
+	case BREAKER_STATE_HALF_OPEN:
+		if b.probes >= b.params.HalfOpenRequests {
+			return nil, false
+		}
+		b.probes++
+		return b.probeDone, true
+	default:
+		return b.done, true
+	}
+}
+
+func (b *Breaker) done(success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.total++
+	if !success {
+		b.failures++
+	}
+	if b.state == BREAKER_STATE_CLOSED && b.total >= b.params.MinRequests &&
+		float64(b.failures)/float64(b.total) >= b.params.ErrorRate {
+		b.trip()
+	}
+}
+
+func (b *Breaker) probeDone(success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state != BREAKER_STATE_HALF_OPEN {
+		return
+	}
+	if !success {
+		b.trip()
+		return
+	}
+	b.state = BREAKER_STATE_CLOSED
+	b.windowStart = b.clock.Now()
+	b.total = 0
+	b.failures = 0
+}
+
+func (b *Breaker) trip() {
+	b.state = BREAKER_STATE_OPEN
+	b.openedAt = b.clock.Now()
+}
+
+// Middleware returns a gin middleware which responds 503 when the breaker is open, 5xx responses count as failures,
+// except the requests canceled by their clients.
+func (b *Breaker) Middleware(zl *zap.Logger) gin.HandlerFunc {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "breaker"), zap.String("breaker", b.name))
+	return func(c *gin.Context) {
+		done, ok := b.Allow()
+		if !ok {
+			zl.Warn("[gin breaker] request rejected",
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)))
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		// a panic counts as a failure, so a half open probe is always released
+		success := false
+		defer func() {
+			done(success)
+		}()
+		c.Next()
+		success = ginResponseStatus(c) < http.StatusInternalServerError
+	}
+}
+
+// NewGinMiddlewareBreaker returns a gin middleware with a new breaker for the routes it's used on.
+func NewGinMiddlewareBreaker(name string, params BreakerParams, zl *zap.Logger) gin.HandlerFunc {
+	return NewBreaker(name, params).Middleware(zl)
+}
+
+// NewBreakersFromConfig creates breakers from viper config with key "breaker", the map key is the route group name.
+func NewBreakersFromConfig(config *viper.Viper) (map[string]*Breaker, error) {
+	var params map[string]BreakerParams
+	if err := config.UnmarshalKey("breaker", &params); err != nil {
+		return nil, err
+	}
+	breakers := make(map[string]*Breaker, len(params))
+	for k, v := range params {
+		breakers[k] = NewBreaker(k, v)
+	}
+	return breakers, nil
+}
@@ -0,0 +1,148 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type BulkheadParams struct {
+	// MaxInFlight is the max number of concurrent requests.
+	MaxInFlight int
+	// MaxQueue is the max number of requests waiting for a slot, 0 means no waiting.
+	MaxQueue int
+	// QueueTimeout is the max time a request waits in queue, default is 1s.
+	QueueTimeout time.Duration
+}
+
+var (
+	ERR_BULKHEAD_FULL    = errors.New("bulkhead queue is full")
+	ERR_BULKHEAD_TIMEOUT = errors.New("bulkhead queue timeout")
+)
+
+// BulkheadStats is a snapshot of bulkhead counters.
+type BulkheadStats struct {
+	InFlight int64
+	Queued   int64
+	Accepted uint64
+	Rejected uint64
+	Timeout  uint64
+}
+
+// Bulkhead limits the concurrency of a group of routes, so expensive routes can't starve the others.
+type Bulkhead struct {
+	name     string
+	params   BulkheadParams
+	slots    chan struct{}
+	inFlight atomic.Int64
+	queued   atomic.Int64
+	accepted atomic.Uint64
+	rejected atomic.Uint64
+	timeout  atomic.Uint64
+}
+
+func NewBulkhead(name string, params BulkheadParams) *Bulkhead {
+	if params.MaxInFlight <= 0 {
+		params.MaxInFlight = 1
+	}
+	if params.QueueTimeout <= 0 {
+		params.QueueTimeout = time.Second
+	}
+	return &Bulkhead{
+		name:   name,
+		params: params,
+		slots:  make(chan struct{}, params.MaxInFlight),
+	}
+}
+
+// Acquire gets a slot, waiting in queue if possible. The returned function must be called to release the slot.
+func (b *Bulkhead) Acquire(ctx context.Context) (func(), error) {
+	release := func() {
+		b.inFlight.Add(-1)
+		<-b.slots
+	}
+	select {
+	case b.slots <- struct{}{}:
+		b.inFlight.Add(1)
+		b.accepted.Add(1)
+		return release, nil
+	default:
+	}
+	if b.queued.Add(1) > int64(b.params.MaxQueue) {
+		b.queued.Add(-1)
+		b.rejected.Add(1)
+		return nil, ERR_BULKHEAD_FULL
+	}
+	defer b.queued.Add(-1)
+	timer := time.NewTimer(b.params.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		b.inFlight.Add(1)
+		b.accepted.Add(1)
+		return release, nil
+	case <-timer.C:
+		b.timeout.Add(1)
+		return nil, ERR_BULKHEAD_TIMEOUT
+	case <-ctx.Done():
+		b.timeout.Add(1)
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns the current counters.
+func (b *Bulkhead) Stats() BulkheadStats {
+	return BulkheadStats{
+		InFlight: b.inFlight.Load(),
+		Queued:   b.queued.Load(),
+		Accepted: b.accepted.Load(),
+		Rejected: b.rejected.Load(),
+		Timeout:  b.timeout.Load(),
+	}
+}
+
+// Middleware returns a gin middleware which responds 503 when no slot is available.
+func (b *Bulkhead) Middleware(zl *zap.Logger) gin.HandlerFunc {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "bulkhead"), zap.String("bulkhead", b.name))
+	return func(c *gin.Context) {
+		release, err := b.Acquire(c.Request.Context())
+		if err != nil {
+			zl.Warn("[gin bulkhead] request rejected",
+				zap.Error(err),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)))
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// NewGinMiddlewareBulkhead returns a gin middleware limiting the concurrency of the routes it's used on.
+func NewGinMiddlewareBulkhead(name string, params BulkheadParams, zl *zap.Logger) gin.HandlerFunc {
+	return NewBulkhead(name, params).Middleware(zl)
+}
+
+// NewBulkheadsFromConfig creates bulkheads from viper config with key "bulkhead", the map key is the route group name.
+func NewBulkheadsFromConfig(config *viper.Viper) (map[string]*Bulkhead, error) {
+	var params map[string]BulkheadParams
+	if err := config.UnmarshalKey("bulkhead", &params); err != nil {
+		return nil, err
+	}
+	bulkheads := make(map[string]*Bulkhead, len(params))
+	for k, v := range params {
+		bulkheads[k] = NewBulkhead(k, v)
+	}
+	return bulkheads, nil
+}
@@ -0,0 +1,430 @@
+package giu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+type CacheParams struct {
+	// Service and Version prefix all keys as "<Service>:<Version>:", bump Version when the cached shapes change
+	// in a deploy, so the new release doesn't read the old values.
+	Service string
+	Version string
+	// TTL is the default ttl of values, default is 10m.
+	TTL time.Duration
+	// GenerationTTL is how long the namespace generations are cached locally, a bumped namespace is seen by
+	// other instances after it, default is 1s.
+	GenerationTTL time.Duration
+	// NegativeTTL caches the not found results of GetOrLoad, 0 disables it.
+	NegativeTTL time.Duration
+	// StaleTTL keeps values after their ttl, GetOrLoad returns a stale value and refreshes it in background.
+	// 0 disables stale-while-revalidate.
+	StaleTTL time.Duration
+	// EarlyRefreshBeta refreshes values in background before they expire, with a probability growing as the
+	// expiry gets closer and the load gets slower, so hot keys don't expire at once. 1 is a good start, 0 disables it.
+	EarlyRefreshBeta float64
+}
+
+var _defaultCacheParams = CacheParams{
+	Version:       "v1",
+	TTL:           10 * time.Minute,
+	GenerationTTL: time.Second,
+}
+
+// ERR_CACHE_NOT_FOUND is returned by loaders of GetOrLoad for missing values, it's cached for NegativeTTL.
+// gorm.ErrRecordNotFound is treated the same way.
+var ERR_CACHE_NOT_FOUND = errors.New("cache value not found")
+
+// cacheEntry is the stored value, Exp is the logical expiry and Delta is the load duration in milliseconds.
+type cacheEntry struct {
+	Value    json.RawMessage `json:"v,omitempty"`
+	NotFound bool            `json:"n,omitempty"`
+	Exp      int64           `json:"e"`
+	Delta    int64           `json:"d,omitempty"`
+}
+
+// Cache is a json cache in redis, keys are grouped into namespaces which can be invalidated at once.
+type Cache struct {
+	rdb    redis.UniversalClient
+	params CacheParams
+	prefix string
+	logger *zap.Logger
+	clock  Clock
+
+	lock        sync.Mutex
+	generations map[string]cacheGeneration
+	group       singleflight.Group
+	stats       sync.Map // namespace -> *cacheCounters
+	requests    metric.Int64Counter
+	loads       metric.Float64Histogram
+}
+
+const (
+	CACHE_RESULT_HIT      = "hit"
+	CACHE_RESULT_MISS     = "miss"
+	CACHE_RESULT_STALE    = "stale"
+	CACHE_RESULT_NEGATIVE = "negative"
+)
+
+// CacheStats is the counters of a namespace since the cache is created.
+type CacheStats struct {
+	Hits       int64
+	Misses     int64
+	Stale      int64
+	Negative   int64
+	Loads      int64
+	LoadErrors int64
+}
+
+// HitRatio is the ratio of requests served from cache, stale and negative results included.
+func (s CacheStats) HitRatio() float64 {
+	served := s.Hits + s.Stale + s.Negative
+	if total := served + s.Misses; total > 0 {
+		return float64(served) / float64(total)
+	}
+	return 0
+}
+
+type cacheCounters struct {
+	hits, misses, stale, negative, loads, loadErrors atomic.Int64
+}
+
+type cacheGeneration struct {
+	value string
+	at    time.Time
+}
+
+func NewCache(rdb redis.UniversalClient, params CacheParams, zl *zap.Logger) *Cache {
+	if params.Version == "" {
+		params.Version = _defaultCacheParams.Version
+	}
+	if params.TTL <= 0 {
+		params.TTL = _defaultCacheParams.TTL
+	}
+	if params.GenerationTTL <= 0 {
+		params.GenerationTTL = _defaultCacheParams.GenerationTTL
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	prefix := params.Version + ":"
+	if params.Service != "" {
+		prefix = params.Service + ":" + prefix
+	}
+	meter := otel.Meter(OTEL_INSTRUMENTATION)
+	requests, _ := meter.Int64Counter("cache.requests",
+		metric.WithDescription("Number of cache requests by namespace and result."))
+	loads, _ := meter.Float64Histogram("cache.load.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of cache loads by namespace."))
+	return &Cache{
+		rdb:         rdb,
+		params:      params,
+		prefix:      prefix,
+		logger:      zl.With(zap.String("module", "cache")),
+		clock:       SystemClock,
+		generations: make(map[string]cacheGeneration),
+		requests:    requests,
+		loads:       loads,
+	}
+}
+
+// SetClock sets the time source of expiries and generations, nil means SystemClock. It must be called before the
+// cache is used.
+func (c *Cache) SetClock(clock Clock) {
+	c.clock = clockOrSystem(clock)
+}
+
+func (c *Cache) counters(namespace string) *cacheCounters {
+	if v, ok := c.stats.Load(namespace); ok {
+		return v.(*cacheCounters)
+	}
+	v, _ := c.stats.LoadOrStore(namespace, &cacheCounters{})
+	return v.(*cacheCounters)
+}
+
+func (c *Cache) record(ctx context.Context, namespace, result string) {
+	counters := c.counters(namespace)
+	switch result {
+	case CACHE_RESULT_HIT:
+		counters.hits.Add(1)
+	case CACHE_RESULT_MISS:
+		counters.misses.Add(1)
+	case CACHE_RESULT_STALE:
+		counters.stale.Add(1)
+	case CACHE_RESULT_NEGATIVE:
+		counters.negative.Add(1)
+	}
+	c.requests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("cache.namespace", namespace),
+		attribute.String("cache.result", result)))
+}
+
+func (c *Cache) recordLoad(ctx context.Context, namespace string, d time.Duration, err error) {
+	counters := c.counters(namespace)
+	counters.loads.Add(1)
+	outcome := "ok"
+	if err != nil && !errors.Is(err, ERR_CACHE_NOT_FOUND) && !errors.Is(err, gorm.ErrRecordNotFound) {
+		counters.loadErrors.Add(1)
+		outcome = "error"
+	}
+	c.loads.Record(ctx, d.Seconds(), metric.WithAttributes(
+		attribute.String("cache.namespace", namespace),
+		attribute.String("cache.load.outcome", outcome)))
+}
+
+// Stats returns the counters of every namespace used, e.g. for an admin endpoint or periodic logs.
+func (c *Cache) Stats() map[string]CacheStats {
+	stats := make(map[string]CacheStats)
+	c.stats.Range(func(k, v interface{}) bool {
+		counters := v.(*cacheCounters)
+		stats[k.(string)] = CacheStats{
+			Hits:       counters.hits.Load(),
+			Misses:     counters.misses.Load(),
+			Stale:      counters.stale.Load(),
+			Negative:   counters.negative.Load(),
+			Loads:      counters.loads.Load(),
+			LoadErrors: counters.loadErrors.Load(),
+		}
+		return true
+	})
+	return stats
+}
+
+// NewCacheFromConfig creates a cache from viper config with key "cache".
+func NewCacheFromConfig(config *viper.Viper, rdb redis.UniversalClient, zl *zap.Logger) (*Cache, error) {
+	var params CacheParams
+	if err := config.UnmarshalKey("cache", &params); err != nil {
+		return nil, err
+	}
+	return NewCache(rdb, params, zl), nil
+}
+
+// CacheNamespace is a group of keys, see Cache.Namespace.
+type CacheNamespace struct {
+	cache *Cache
+	name  string
+}
+
+// Namespace returns the namespace of name, the keys are "<prefix><name>:<generation>:<key>".
+func (c *Cache) Namespace(name string) *CacheNamespace {
+	return &CacheNamespace{cache: c, name: name}
+}
+
+func (n *CacheNamespace) generationKey() string {
+	return n.cache.prefix + n.name + ":gen"
+}
+
+func (n *CacheNamespace) generation(ctx context.Context) (string, error) {
+	c := n.cache
+	c.lock.Lock()
+	g, ok := c.generations[n.name]
+	c.lock.Unlock()
+	if ok && c.clock.Since(g.at) < c.params.GenerationTTL {
+		return g.value, nil
+	}
+	value, err := c.rdb.Get(ctx, n.generationKey()).Result()
+	if errors.Is(err, redis.Nil) {
+		value, err = "0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	c.lock.Lock()
+	c.generations[n.name] = cacheGeneration{value: value, at: c.clock.Now()}
+	c.lock.Unlock()
+	return value, nil
+}
+
+// Key returns the redis key of key in the current generation.
+func (n *CacheNamespace) Key(ctx context.Context, key string) (string, error) {
+	gen, err := n.generation(ctx)
+	if err != nil {
+		return "", err
+	}
+	return n.cache.prefix + n.name + ":" + gen + ":" + key, nil
+}
+
+// BumpNamespace invalidates all keys of the namespace by moving it to a new generation, the old keys are left
+// to expire with their ttl, so there's no need to scan or flush them.
+func (n *CacheNamespace) BumpNamespace(ctx context.Context) error {
+	gen, err := n.cache.rdb.Incr(ctx, n.generationKey()).Result()
+	if err != nil {
+		return err
+	}
+	c := n.cache
+	c.lock.Lock()
+	c.generations[n.name] = cacheGeneration{value: strconv.FormatInt(gen, 10), at: c.clock.Now()}
+	c.lock.Unlock()
+	c.logger.Info("[cache] namespace bumped", zap.String("namespace", n.name), zap.Int64("generation", gen))
+	return nil
+}
+
+// Set sets the json value of key, zero ttl means the default ttl.
+func (n *CacheNamespace) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		ttl = n.cache.params.TTL
+	}
+	return n.setEntry(ctx, key, cacheEntry{Value: data}, ttl)
+}
+
+// setEntry stores entry with logical ttl, the redis key lives StaleTTL longer for stale-while-revalidate.
+func (n *CacheNamespace) setEntry(ctx context.Context, key string, entry cacheEntry, ttl time.Duration) error {
+	k, err := n.Key(ctx, key)
+	if err != nil {
+		return err
+	}
+	entry.Exp = n.cache.clock.Now().Add(ttl).UnixMilli()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return n.cache.rdb.Set(ctx, k, data, ttl+n.cache.params.StaleTTL).Err()
+}
+
+func (n *CacheNamespace) getEntry(ctx context.Context, key string) (*cacheEntry, error) {
+	k, err := n.Key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := n.cache.rdb.Get(ctx, k).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (n *CacheNamespace) Delete(ctx context.Context, keys ...string) error {
+	ks := make([]string, len(keys))
+	for i, key := range keys {
+		k, err := n.Key(ctx, key)
+		if err != nil {
+			return err
+		}
+		ks[i] = k
+	}
+	return n.cache.rdb.Del(ctx, ks...).Err()
+}
+
+// CacheGet gets the json value of key, it returns false if the key is missing, expired or cached as not found.
+func CacheGet[T any](ctx context.Context, n *CacheNamespace, key string) (T, bool, error) {
+	var v T
+	entry, err := n.getEntry(ctx, key)
+	if err != nil {
+		return v, false, err
+	}
+	if entry == nil || entry.NotFound || n.cache.clock.Now().UnixMilli() >= entry.Exp {
+		n.cache.record(ctx, n.name, CACHE_RESULT_MISS)
+		return v, false, nil
+	}
+	if err := json.Unmarshal(entry.Value, &v); err != nil {
+		return v, false, err
+	}
+	n.cache.record(ctx, n.name, CACHE_RESULT_HIT)
+	return v, true, nil
+}
+
+// GetOrLoad gets the value of key, or loads and sets it with ttl when it's missing, zero ttl means the default ttl.
+// Concurrent loads of the same key in the instance are merged. The loaded value is returned even if it can't be
+// cached. Loaders return ERR_CACHE_NOT_FOUND or gorm.ErrRecordNotFound for missing values, which are cached for
+// NegativeTTL. Stale values within StaleTTL are returned while they're refreshed in background, and fresh values
+// may be refreshed early, see EarlyRefreshBeta.
+func GetOrLoad[T any](ctx context.Context, n *CacheNamespace, key string, ttl time.Duration, load func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	if ttl <= 0 {
+		ttl = n.cache.params.TTL
+	}
+	entry, err := n.getEntry(ctx, key)
+	if err != nil {
+		n.cache.logger.Warn("[cache] get failed", zap.String("namespace", n.name), zap.String("key", key), zap.Error(err))
+	}
+	if entry != nil {
+		now := n.cache.clock.Now().UnixMilli()
+		stale := now >= entry.Exp
+		// XFetch: refresh early with probability growing as the expiry gets closer, scaled by the load duration
+		early := !stale && n.cache.params.EarlyRefreshBeta > 0 &&
+			float64(now)-float64(entry.Delta)*n.cache.params.EarlyRefreshBeta*math.Log(rand.Float64()) >= float64(entry.Exp)
+		if !stale || n.cache.params.StaleTTL > 0 {
+			if stale || early {
+				go func() {
+					_, _ = loadCache(context.WithoutCancel(ctx), n, key, ttl, load)
+				}()
+			}
+			if entry.NotFound {
+				n.cache.record(ctx, n.name, CACHE_RESULT_NEGATIVE)
+				return zero, ERR_CACHE_NOT_FOUND
+			}
+			var v T
+			if err := json.Unmarshal(entry.Value, &v); err == nil {
+				result := CACHE_RESULT_HIT
+				if stale {
+					result = CACHE_RESULT_STALE
+				}
+				n.cache.record(ctx, n.name, result)
+				return v, nil
+			}
+		}
+	}
+	n.cache.record(ctx, n.name, CACHE_RESULT_MISS)
+	return loadCache(ctx, n, key, ttl, load)
+}
+
+func loadCache[T any](ctx context.Context, n *CacheNamespace, key string, ttl time.Duration, load func(ctx context.Context) (T, error)) (T, error) {
+	v, err, _ := n.cache.group.Do(n.name+":"+key, func() (interface{}, error) {
+		start := n.cache.clock.Now()
+		v, err := load(ctx)
+		elapsed := n.cache.clock.Since(start)
+		n.cache.recordLoad(ctx, n.name, elapsed, err)
+		delta := elapsed.Milliseconds()
+		var entry cacheEntry
+		entryTTL := ttl
+		switch {
+		case err == nil:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return v, nil
+			}
+			entry = cacheEntry{Value: data, Delta: delta}
+		case errors.Is(err, ERR_CACHE_NOT_FOUND) || errors.Is(err, gorm.ErrRecordNotFound):
+			if n.cache.params.NegativeTTL <= 0 {
+				return v, err
+			}
+			entry, entryTTL = cacheEntry{NotFound: true, Delta: delta}, n.cache.params.NegativeTTL
+		default:
+			return v, err
+		}
+		if setErr := n.setEntry(ctx, key, entry, entryTTL); setErr != nil {
+			n.cache.logger.Warn("[cache] set failed", zap.String("namespace", n.name), zap.String("key", key), zap.Error(setErr))
+		}
+		return v, err
+	})
+	t, _ := v.(T)
+	return t, err
+}
@@ -0,0 +1,204 @@
+package giu
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type CertParams struct {
+	// CertFile and KeyFile are the paths of the PEM encoded cert/key pair.
+	CertFile string
+	KeyFile  string
+	// CAFile is the optional CA bundle used to verify peers for mTLS.
+	CAFile string
+	// CertPEM, KeyPEM and CAPEM are inline PEM contents (e.g. read from secrets), used when the file is empty.
+	CertPEM string
+	KeyPEM  string
+	CAPEM   string
+	// VerifyClient requires and verifies client certificates with the CA when serving.
+	VerifyClient bool
+	// ReloadInterval is the interval of checking files for renewal, default is 1 minute, negative disables it.
+	ReloadInterval time.Duration
+}
+
+var ERR_CERT_NOT_LOADED = errors.New("certificate is not loaded")
+
+type certState struct {
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+// CertProvider loads a cert/key pair and reloads it when the files change, without restarting servers or clients.
+type CertProvider struct {
+	params CertParams
+	logger *zap.Logger
+	state  atomic.Pointer[certState]
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NewCertProvider creates a cert provider and loads the certificate, call Start to watch for renewal.
+func NewCertProvider(params CertParams, zl *zap.Logger) (*CertProvider, error) {
+	if params.ReloadInterval == 0 {
+		params.ReloadInterval = time.Minute
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	cp := &CertProvider{
+		params: params,
+		logger: zl.With(zap.String("module", "cert")),
+		stop:   make(chan struct{}),
+	}
+	if err := cp.Reload(); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// NewCertProviderFromConfig creates a cert provider from viper config with key "cert".
+func NewCertProviderFromConfig(config *viper.Viper, zl *zap.Logger) (*CertProvider, error) {
+	var params CertParams
+	if err := config.UnmarshalKey("cert", &params); err != nil {
+		return nil, err
+	}
+	return NewCertProvider(params, zl)
+}
+
+// Reload loads the certificate and CA again.
+func (cp *CertProvider) Reload() error {
+	certPEM, keyPEM, caPEM := []byte(cp.params.CertPEM), []byte(cp.params.KeyPEM), []byte(cp.params.CAPEM)
+	var err error
+	if cp.params.CertFile != "" {
+		if certPEM, err = os.ReadFile(cp.params.CertFile); err != nil {
+			return err
+		}
+	}
+	if cp.params.KeyFile != "" {
+		if keyPEM, err = os.ReadFile(cp.params.KeyFile); err != nil {
+			return err
+		}
+	}
+	if cp.params.CAFile != "" {
+		if caPEM, err = os.ReadFile(cp.params.CAFile); err != nil {
+			return err
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	state := &certState{cert: &cert, modTime: cp.latestModTime()}
+	if len(caPEM) > 0 {
+		state.pool = x509.NewCertPool()
+		if !state.pool.AppendCertsFromPEM(caPEM) {
+			return errors.New("failed to parse CA certificates")
+		}
+	}
+	cp.state.Store(state)
+	return nil
+}
+
+// latestModTime returns the latest modification time of the files, symlinks are followed.
+func (cp *CertProvider) latestModTime() time.Time {
+	var latest time.Time
+	for _, f := range []string{cp.params.CertFile, cp.params.KeyFile, cp.params.CAFile} {
+		if f == "" {
+			continue
+		}
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+// Start checks the files in a new goroutine and reloads them when they are renewed.
+func (cp *CertProvider) Start() {
+	if cp.params.ReloadInterval < 0 || cp.params.CertFile == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cp.params.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !cp.latestModTime().After(cp.state.Load().modTime) {
+					continue
+				}
+				if err := cp.Reload(); err != nil {
+					cp.logger.Error("[cert] reload failed", zap.Error(err))
+					continue
+				}
+				cp.logger.Info("[cert] certificate reloaded", zap.String("cert", cp.params.CertFile))
+			case <-cp.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops watching the files.
+func (cp *CertProvider) Shutdown() error {
+	cp.once.Do(func() {
+		close(cp.stop)
+	})
+	return nil
+}
+
+// Certificate returns the current certificate.
+func (cp *CertProvider) Certificate() (*tls.Certificate, error) {
+	state := cp.state.Load()
+	if state == nil {
+		return nil, ERR_CERT_NOT_LOADED
+	}
+	return state.cert, nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+func (cp *CertProvider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cp.Certificate()
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate.
+func (cp *CertProvider) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return cp.Certificate()
+}
+
+// ServerTLSConfig returns a tls config for servers, the certificate and client CA are always the latest.
+func (cp *CertProvider) ServerTLSConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: cp.GetCertificate}
+	if !cp.params.VerifyClient {
+		return base
+	}
+	base.ClientAuth = tls.RequireAndVerifyClientCert
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		if state := cp.state.Load(); state != nil {
+			c.ClientCAs = state.pool
+		}
+		return c, nil
+	}
+	return base
+}
+
+// ClientTLSConfig returns a tls config for mTLS clients, e.g. resty.Client.SetTLSClientConfig.
+// The client certificate is always the latest, the root CAs are taken when it's called.
+func (cp *CertProvider) ClientTLSConfig() *tls.Config {
+	c := &tls.Config{MinVersion: tls.VersionTLS12, GetClientCertificate: cp.GetClientCertificate}
+	if state := cp.state.Load(); state != nil {
+		c.RootCAs = state.pool
+	}
+	return c
+}
@@ -0,0 +1,133 @@
+package giu
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/spf13/viper"
+)
+
+type ClickHouseParams struct {
+	Addrs    []string
+	Database string
+	Username string
+	Password string
+	// Compression is one of "lz4", "zstd" and "none", default is "lz4".
+	Compression     string
+	DialTimeout     time.Duration
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// AsyncInsert enables server side async inserts, WaitAsyncInsert waits for the data to be flushed before returning.
+	AsyncInsert     bool
+	WaitAsyncInsert bool
+	// Settings are the extra clickhouse settings of the connection.
+	Settings map[string]interface{}
+	// ConnectRetries is the max attempts of connecting with DefaultRetryPolicy backoff, 0 means no retry.
+	ConnectRetries int
+}
+
+var _defaultClickHouseParams = ClickHouseParams{
+	Addrs:    []string{"localhost:9000"},
+	Database: "default",
+	Username: "default",
+}
+
+// NewClickHouse opens a native clickhouse connection and pings it.
+func NewClickHouse(params ClickHouseParams) (driver.Conn, error) {
+	opts := &clickhouse.Options{
+		Addr: params.Addrs,
+		Auth: clickhouse.Auth{
+			Database: params.Database,
+			Username: params.Username,
+			Password: params.Password,
+		},
+		DialTimeout:     params.DialTimeout,
+		MaxOpenConns:    params.MaxOpenConns,
+		MaxIdleConns:    params.MaxIdleConns,
+		ConnMaxLifetime: params.ConnMaxLifetime,
+		Settings:        clickhouse.Settings{},
+	}
+	switch params.Compression {
+	case "", "lz4":
+		opts.Compression = &clickhouse.Compression{Method: clickhouse.CompressionLZ4}
+	case "zstd":
+		opts.Compression = &clickhouse.Compression{Method: clickhouse.CompressionZSTD}
+	case "none":
+	default:
+		return nil, fmt.Errorf("unsupported clickhouse compression: %s", params.Compression)
+	}
+	for k, v := range params.Settings {
+		opts.Settings[k] = v
+	}
+	if params.AsyncInsert {
+		opts.Settings["async_insert"] = 1
+		if params.WaitAsyncInsert {
+			opts.Settings["wait_for_async_insert"] = 1
+		} else {
+			opts.Settings["wait_for_async_insert"] = 0
+		}
+	}
+	policy := DefaultRetryPolicy()
+	policy.MaxAttempts = params.ConnectRetries
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	return RetryValue(context.Background(), policy, func(ctx context.Context) (driver.Conn, error) {
+		conn, err := clickhouse.Open(opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.Ping(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	})
+}
+
+func DefaultClickHouse() (driver.Conn, error) {
+	return NewClickHouse(_defaultClickHouseParams)
+}
+
+type clickHouseProvider struct {
+	*GiuProvider[driver.Conn]
+}
+
+func (cp *clickHouseProvider) Shutdown() error {
+	return cp.ShutdownContext(context.Background())
+}
+
+func (cp *clickHouseProvider) ShutdownContext(ctx context.Context) error {
+	return cp.shutdownItems(ctx, driver.Conn.Close)
+}
+
+// NewClickHouseProvider creates a clickhouse provider from existing connection, if items is not empty, the first item will be set as default
+func NewClickHouseProvider(conns ...map[string]driver.Conn) Provider[driver.Conn] {
+	return &clickHouseProvider{
+		GiuProvider: NewGiuProvider[driver.Conn](conns...),
+	}
+}
+
+// NewClickHouseProviderFromParams creates a clickhouse provider from params, if items is not empty, the first item will be set as default
+func NewClickHouseProviderFromParams(params map[string]*ClickHouseParams) (Provider[driver.Conn], error) {
+	giu, err := NewGiuProviderFromParamsError[driver.Conn, *ClickHouseParams](func(p *ClickHouseParams) (driver.Conn, error) {
+		return NewClickHouse(*p)
+	}, params)
+	if err != nil {
+		return nil, err
+	}
+	return &clickHouseProvider{GiuProvider: giu}, nil
+}
+
+// NewClickHouseProviderFromConfig creates a clickhouse provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
+func NewClickHouseProviderFromConfig(config *viper.Viper) (Provider[driver.Conn], error) {
+	var params map[string]*ClickHouseParams
+	if err := config.UnmarshalKey("clickhouse", &params); err != nil {
+		return nil, err
+	}
+	return NewClickHouseProviderFromParams(params)
+}
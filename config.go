@@ -1,9 +1,46 @@
 package giu
 
 type GiuConfig[ExtendParams any] struct {
-	Logger         map[string]*LoggerParams         `mapstructure:"logger"`
-	GormConfig     *GormConfigParams                `mapstructure:"gorm_config"`
-	GormConnection map[string]*GormConnectionParams `mapstructure:"gorm_connection"`
-	Redis          map[string]*RedisParams          `mapstructure:"redis"`
-	Extend         ExtendParams                     `mapstructure:"extend"`
+	// Mode is one of "debug", "release" and "test", Debug turns on debug toggles in release mode, see SetMode.
+	Mode                string                           `mapstructure:"mode"`
+	Debug               bool                             `mapstructure:"debug"`
+	Logger              map[string]*LoggerParams         `mapstructure:"logger"`
+	GormConfig          *GormConfigParams                `mapstructure:"gorm_config"`
+	GormConnection      map[string]*GormConnectionParams `mapstructure:"gorm_connection"`
+	Redis               map[string]*RedisParams          `mapstructure:"redis"`
+	RedisReplicas       map[string]*RedisReplicaParams   `mapstructure:"redis_replicas"`
+	Cache               *CacheParams                     `mapstructure:"cache"`
+	Resty               map[string]*RestyParams          `mapstructure:"resty"`
+	Cron                *CronParams                      `mapstructure:"cron"`
+	Shutdown            *ShutdownParams                  `mapstructure:"shutdown"`
+	Startup             *StartupParams                   `mapstructure:"startup"`
+	Seed                *SeederParams                    `mapstructure:"seed"`
+	Watchdog            *WatchdogParams                  `mapstructure:"watchdog"`
+	Alerter             map[string]*AlerterParams        `mapstructure:"alerter"`
+	Server              *GinServerParams                 `mapstructure:"server"`
+	Cert                *CertParams                      `mapstructure:"cert"`
+	IPFilter            *IPFilterParams                  `mapstructure:"ip_filter"`
+	Bulkhead            map[string]*BulkheadParams       `mapstructure:"bulkhead"`
+	Breaker             map[string]*BreakerParams        `mapstructure:"breaker"`
+	RateLimit           map[string]*RateLimiterParams    `mapstructure:"rate_limit"`
+	Mongo               map[string]*MongoParams          `mapstructure:"mongo"`
+	MetricsWriter       map[string]*MetricsWriterParams  `mapstructure:"metrics_writer"`
+	ClickHouse          map[string]*ClickHouseParams     `mapstructure:"clickhouse"`
+	IDGenerator         *IDGeneratorParams               `mapstructure:"id_generator"`
+	Fault               *FaultParams                     `mapstructure:"fault"`
+	LoadShed            *LoadShedParams                  `mapstructure:"load_shed"`
+	Routes              []RouteParams                    `mapstructure:"routes"`
+	Admin               *AdminParams                     `mapstructure:"admin"`
+	Profile             *ProfileParams                   `mapstructure:"profile"`
+	ContinuousProfiling *ContinuousProfilingParams       `mapstructure:"continuous_profiling"`
+	Otel                *OtelParams                      `mapstructure:"otel"`
+	Grpc                *GrpcParams                      `mapstructure:"grpc"`
+	Sftp                map[string]*SftpParams           `mapstructure:"sftp"`
+	Ldap                map[string]*LdapParams           `mapstructure:"ldap"`
+	Oidc                *OidcParams                      `mapstructure:"oidc"`
+	Saml                *SamlParams                      `mapstructure:"saml"`
+	Push                map[string]*PushParams           `mapstructure:"push"`
+	Sms                 map[string]*SmsParams            `mapstructure:"sms"`
+	Report              *ReportParams                    `mapstructure:"report"`
+	Extend              ExtendParams                     `mapstructure:"extend"`
 }
@@ -0,0 +1,139 @@
+package giu
+
+import (
+	"errors"
+	"net/http/pprof"
+	"path"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grafana/pyroscope-go"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type ContinuousProfilingParams struct {
+	// Type is "pyroscope" or "parca". Pyroscope profiles are pushed to ServerAddress, parca scrapes
+	// the pprof endpoints mounted on the admin group.
+	Type string
+	// ServerAddress is the pyroscope server, e.g. "http://pyroscope:4040".
+	ServerAddress string
+	// ApplicationName is the service name, default is the base of main module path.
+	ApplicationName string
+	// Tags are added to the labels from build info.
+	Tags              map[string]string
+	BasicAuthUser     string
+	BasicAuthPassword string
+	TenantID          string
+	// UploadRate is the push interval of pyroscope, default is 15s.
+	UploadRate time.Duration
+}
+
+const (
+	CONTINUOUS_PROFILING_PYROSCOPE = "pyroscope"
+	CONTINUOUS_PROFILING_PARCA     = "parca"
+)
+
+var (
+	ERR_CONTINUOUS_PROFILING_TYPE = errors.New("unknown continuous profiling type")
+	ERR_ADMIN_GROUP_REQUIRED      = errors.New("admin group is required")
+)
+
+// ContinuousProfiler runs the continuous profiling integration until Shutdown.
+type ContinuousProfiler struct {
+	pyroscope *pyroscope.Profiler
+}
+
+// NewContinuousProfiler starts continuous profiling, admin is the group the pprof endpoints are mounted on,
+// it's only required by parca.
+func NewContinuousProfiler(params ContinuousProfilingParams, admin *gin.RouterGroup, zl *zap.Logger) (*ContinuousProfiler, error) {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "profile"), zap.String("type", params.Type))
+	switch params.Type {
+	case CONTINUOUS_PROFILING_PYROSCOPE:
+		tags := BuildInfoLabels()
+		for k, v := range params.Tags {
+			tags[k] = v
+		}
+		name := params.ApplicationName
+		if name == "" {
+			name = tags["service"]
+		}
+		p, err := pyroscope.Start(pyroscope.Config{
+			ApplicationName:   name,
+			ServerAddress:     params.ServerAddress,
+			Logger:            zl.Sugar(),
+			Tags:              tags,
+			BasicAuthUser:     params.BasicAuthUser,
+			BasicAuthPassword: params.BasicAuthPassword,
+			TenantID:          params.TenantID,
+			UploadRate:        params.UploadRate,
+		})
+		if err != nil {
+			return nil, err
+		}
+		zl.Info("[profile] pyroscope started", zap.String("server", params.ServerAddress), zap.String("application", name))
+		return &ContinuousProfiler{pyroscope: p}, nil
+	case CONTINUOUS_PROFILING_PARCA:
+		if admin == nil {
+			return nil, ERR_ADMIN_GROUP_REQUIRED
+		}
+		MountPprof(admin)
+		zl.Info("[profile] pprof endpoints mounted for parca", zap.String("path", path.Join(admin.BasePath(), "debug/pprof")))
+		return &ContinuousProfiler{}, nil
+	default:
+		return nil, ERR_CONTINUOUS_PROFILING_TYPE
+	}
+}
+
+// NewContinuousProfilerFromConfig starts continuous profiling from viper config with key "continuous_profiling".
+func NewContinuousProfilerFromConfig(config *viper.Viper, admin *gin.RouterGroup, zl *zap.Logger) (*ContinuousProfiler, error) {
+	var params ContinuousProfilingParams
+	if err := config.UnmarshalKey("continuous_profiling", &params); err != nil {
+		return nil, err
+	}
+	return NewContinuousProfiler(params, admin, zl)
+}
+
+// Shutdown stops pushing profiles, mounted endpoints stay until the server shuts down.
+func (p *ContinuousProfiler) Shutdown() error {
+	if p.pyroscope != nil {
+		return p.pyroscope.Stop()
+	}
+	return nil
+}
+
+// MountPprof adds the net/http/pprof endpoints under "debug/pprof" of the admin group.
+func MountPprof(admin *gin.RouterGroup) {
+	g := admin.Group("/debug/pprof")
+	g.GET("/", gin.WrapF(pprof.Index))
+	g.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	g.GET("/profile", gin.WrapF(pprof.Profile))
+	g.POST("/symbol", gin.WrapF(pprof.Symbol))
+	g.GET("/symbol", gin.WrapF(pprof.Symbol))
+	g.GET("/trace", gin.WrapF(pprof.Trace))
+	g.GET("/:name", func(c *gin.Context) {
+		pprof.Handler(c.Param("name")).ServeHTTP(c.Writer, c.Request)
+	})
+}
+
+// BuildInfoLabels returns the service, version, revision and go_version labels from build info.
+func BuildInfoLabels() map[string]string {
+	labels := map[string]string{}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return labels
+	}
+	labels["service"] = path.Base(info.Main.Path)
+	labels["version"] = info.Main.Version
+	labels["go_version"] = info.GoVersion
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			labels["revision"] = s.Value
+		}
+	}
+	return labels
+}
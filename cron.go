@@ -1,6 +1,9 @@
 package giu
 
 import (
+	"context"
+	"fmt"
+	"runtime/debug"
 	"time"
 
 	"github.com/robfig/cron/v3"
@@ -18,6 +21,15 @@ const (
 )
 
 func NewCron(params CronParams) *cron.Cron {
+	return newCron(params)
+}
+
+// NewCronWithAlerter creates a cron which recovers panics of jobs and reports them with alerter.
+func NewCronWithAlerter(params CronParams, alerter Alerter) *cron.Cron {
+	return newCron(params, NewCronAlertOnPanic(alerter))
+}
+
+func newCron(params CronParams, wrappers ...cron.JobWrapper) *cron.Cron {
 	options := []cron.Option{}
 	if params.Location != "" {
 		tl, err := time.LoadLocation(params.Location)
@@ -27,15 +39,42 @@ func NewCron(params CronParams) *cron.Cron {
 	}
 	switch params.ConcurrentMode {
 	case CRON_CONCURRENT_MODE_SKIP:
-		options = append(options, cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+		wrappers = append(wrappers, cron.SkipIfStillRunning(cron.DefaultLogger))
 	case CRON_CONCURRENT_MODE_DELAY:
-		options = append(options, cron.WithChain(cron.DelayIfStillRunning(cron.DefaultLogger)))
+		wrappers = append(wrappers, cron.DelayIfStillRunning(cron.DefaultLogger))
 	default:
 
+	}
+	if len(wrappers) > 0 {
+		options = append(options, cron.WithChain(wrappers...))
 	}
 	return cron.New(options...)
 }
 
+// NewCronAlertOnPanic returns a cron job wrapper which recovers panics and sends an alert.
+// The optional clock is the time source of alerts, default is SystemClock.
+func NewCronAlertOnPanic(alerter Alerter, clock ...Clock) cron.JobWrapper {
+	c := SystemClock
+	if len(clock) > 0 {
+		c = clockOrSystem(clock[0])
+	}
+	return func(j cron.Job) cron.Job {
+		return cron.FuncJob(func() {
+			defer func() {
+				if r := recover(); r != nil {
+					_ = alerter.Alert(context.Background(), Alert{
+						Level:   LOG_LEVEL_ERROR,
+						Title:   "[cron] job panic",
+						Content: fmt.Sprintf("%v\n%s", r, debug.Stack()),
+						Time:    c.Now(),
+					})
+				}
+			}()
+			j.Run()
+		})
+	}
+}
+
 type ScheduleParams struct {
 	Tag         string
 	Schedule    string
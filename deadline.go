@@ -0,0 +1,100 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// SCOPE_HEADER_TIMEOUT is the header of the remaining time of the caller, e.g. "1.5s" or "300ms".
+	SCOPE_HEADER_TIMEOUT = "X-Request-Timeout"
+	// SCOPE_HEADER_GRPC_TIMEOUT is the grpc style timeout header, e.g. "300m" is 300 milliseconds.
+	SCOPE_HEADER_GRPC_TIMEOUT = "Grpc-Timeout"
+)
+
+var ERR_REQUEST_TIMEOUT_INVALID = errors.New("invalid request timeout")
+
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// ParseRequestTimeout parses a SCOPE_HEADER_TIMEOUT value, it's a positive go duration like "1.5s".
+func ParseRequestTimeout(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, ERR_REQUEST_TIMEOUT_INVALID
+	}
+	return d, nil
+}
+
+// ParseGrpcTimeout parses a SCOPE_HEADER_GRPC_TIMEOUT value, it's at most 8 digits and a unit of H, M, S, m, u
+// and n, e.g. "300m" is 300 milliseconds.
+func ParseGrpcTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, ERR_REQUEST_TIMEOUT_INVALID
+	}
+	unit, ok := grpcTimeoutUnits[s[len(s)-1]]
+	if !ok {
+		return 0, ERR_REQUEST_TIMEOUT_INVALID
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	// 8 digits of hours overflow time.Duration
+	if err != nil || n <= 0 || n > math.MaxInt64/int64(unit) {
+		return 0, ERR_REQUEST_TIMEOUT_INVALID
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// NewGinMiddlewareRequestTimeout returns a gin middleware which sets the deadline of the request context from the
+// SCOPE_HEADER_TIMEOUT or SCOPE_HEADER_GRPC_TIMEOUT header, so the gorm, redis and resty calls with the request
+// context are bounded by the budget of the caller. The timeout is capped by max if it's positive, invalid values
+// are ignored. Use it before the request scope middleware, so the scope has the deadline.
+func NewGinMiddlewareRequestTimeout(max time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var timeout time.Duration
+		var err error
+		if value := c.GetHeader(SCOPE_HEADER_TIMEOUT); value != "" {
+			timeout, err = ParseRequestTimeout(value)
+		} else {
+			timeout, err = ParseGrpcTimeout(c.GetHeader(SCOPE_HEADER_GRPC_TIMEOUT))
+		}
+		if err != nil {
+			c.Next()
+			return
+		}
+		if max > 0 && timeout > max {
+			timeout = max
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		if scope, ok := RequestScopeFromContext(ctx); ok {
+			scope.Deadline, _ = ctx.Deadline()
+		}
+		c.Next()
+	}
+}
+
+// requestTimeoutHeader returns the remaining time of ctx as the SCOPE_HEADER_TIMEOUT value, or empty if ctx has
+// no deadline.
+func requestTimeoutHeader(ctx context.Context) string {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ""
+	}
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 1 {
+		remaining = 1
+	}
+	return strconv.FormatInt(remaining, 10) + "ms"
+}
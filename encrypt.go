@@ -0,0 +1,247 @@
+package giu
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+// GORM_ENCRYPT_SERIALIZER is the default serializer name of encrypted columns, e.g. `gorm:"serializer:encrypt"`.
+const GORM_ENCRYPT_SERIALIZER = "encrypt"
+
+var ERR_ENCRYPT_KEY_NOT_FOUND = errors.New("encryption key not found")
+
+// encryptADSuffix marks the key id of the ciphertexts bound to additional data, see EncryptWithAD.
+const encryptADSuffix = ".ad"
+
+// KeyRing is a set of AES keys, the first key encrypts and all keys decrypt, so keys can be rotated
+// by prepending a new key and keeping the old ones until all rows are re-encrypted.
+type KeyRing struct {
+	// lock guards the keys, the key ring of a registered serializer is rotated in place, see RegisterEncryptSerializer.
+	lock    sync.RWMutex
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyRing creates a key ring from base64 encoded 16, 24 or 32 bytes keys.
+func NewKeyRing(keys []string) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key")
+	}
+	kr := &KeyRing{aeads: make(map[string]cipher.AEAD)}
+	for i, k := range keys {
+		raw, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %d: %w", i, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %d: %w", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(raw)
+		id := hex.EncodeToString(sum[:4])
+		if i == 0 {
+			kr.primary = id
+		}
+		kr.aeads[id] = aead
+	}
+	return kr, nil
+}
+
+// Encrypt encrypts with the primary key, the result is "keyid:base64(nonce|ciphertext)".
+func (kr *KeyRing) Encrypt(plaintext []byte) (string, error) {
+	return kr.seal(plaintext, nil, "")
+}
+
+// EncryptWithAD is like Encrypt, but the ciphertext is bound to ad, e.g. the table and column, it can only be
+// decrypted by DecryptWithAD with the same ad. The result is "keyid.ad:base64(nonce|ciphertext)".
+func (kr *KeyRing) EncryptWithAD(plaintext, ad []byte) (string, error) {
+	return kr.seal(plaintext, ad, encryptADSuffix)
+}
+
+func (kr *KeyRing) primaryID() string {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+	return kr.primary
+}
+
+// seal encrypts with the primary key, the key id with suffix prefixes the result.
+func (kr *KeyRing) seal(plaintext, ad []byte, suffix string) (string, error) {
+	kr.lock.RLock()
+	prefix, aead := kr.primary+suffix, kr.aeads[kr.primary]
+	kr.lock.RUnlock()
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, ad)
+	return prefix + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts the result of Encrypt with the key it was encrypted by.
+func (kr *KeyRing) Decrypt(ciphertext string) ([]byte, error) {
+	return kr.DecryptWithAD(ciphertext, nil)
+}
+
+// DecryptWithAD decrypts the result of EncryptWithAD with the same ad, the results of Encrypt are decrypted
+// without ad, so the values encrypted before binding can still be read.
+func (kr *KeyRing) DecryptWithAD(ciphertext string, ad []byte) ([]byte, error) {
+	id, data, ok := strings.Cut(ciphertext, ":")
+	if !ok {
+		return nil, errors.New("invalid ciphertext")
+	}
+	if trimmed := strings.TrimSuffix(id, encryptADSuffix); trimmed != id {
+		id = trimmed
+	} else {
+		ad = nil
+	}
+	kr.lock.RLock()
+	aead, ok := kr.aeads[id]
+	kr.lock.RUnlock()
+	if !ok {
+		return nil, ERR_ENCRYPT_KEY_NOT_FOUND
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("invalid ciphertext")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], ad)
+}
+
+// NeedsRotation reports whether the ciphertext is not encrypted by the primary key.
+func (kr *KeyRing) NeedsRotation(ciphertext string) bool {
+	primary := kr.primaryID()
+	return !strings.HasPrefix(ciphertext, primary+":") && !strings.HasPrefix(ciphertext, primary+encryptADSuffix+":")
+}
+
+// rotate makes the primary key of other the primary key, and adds the keys of other, the current keys are kept
+// to decrypt the values encrypted before.
+func (kr *KeyRing) rotate(other *KeyRing) {
+	other.lock.RLock()
+	primary := other.primary
+	aeads := make(map[string]cipher.AEAD, len(other.aeads))
+	for id, aead := range other.aeads {
+		aeads[id] = aead
+	}
+	other.lock.RUnlock()
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+	for id, aead := range kr.aeads {
+		if _, ok := aeads[id]; !ok {
+			aeads[id] = aead
+		}
+	}
+	kr.primary, kr.aeads = primary, aeads
+}
+
+// EncryptSerializer is a gorm serializer which encrypts the field transparently with AES-GCM.
+// String and []byte fields are encrypted as is, other types are encoded as json first.
+// The ciphertext is bound to the table and column, so it can't be copied to other columns.
+type EncryptSerializer struct {
+	KeyRing *KeyRing
+}
+
+var (
+	encryptSerializersLock sync.Mutex
+	encryptSerializers     = make(map[string]*KeyRing)
+)
+
+// RegisterEncryptSerializer registers the serializer with name. If name is registered, the keys of kr are rotated
+// in: the primary key of kr encrypts, and the keys registered before still decrypt, e.g. after a key rotation or
+// a config reload. Gorm serializers are global, use different names for connections with different keys.
+func RegisterEncryptSerializer(name string, kr *KeyRing) error {
+	encryptSerializersLock.Lock()
+	defer encryptSerializersLock.Unlock()
+	// the parsed schemas keep the registered serializer, so its key ring is rotated in place
+	if registered, ok := encryptSerializers[name]; ok {
+		registered.rotate(kr)
+		return nil
+	}
+	registered := &KeyRing{aeads: make(map[string]cipher.AEAD)}
+	registered.rotate(kr)
+	encryptSerializers[name] = registered
+	schema.RegisterSerializer(name, EncryptSerializer{KeyRing: registered})
+	return nil
+}
+
+// encryptAD returns the additional data of the field, the table and column.
+func encryptAD(field *schema.Field) []byte {
+	return []byte(field.Schema.Table + "." + field.DBName)
+}
+
+func (es EncryptSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var ciphertext string
+	switch v := dbValue.(type) {
+	case nil:
+		return nil
+	case string:
+		ciphertext = v
+	case []byte:
+		ciphertext = string(v)
+	default:
+		return fmt.Errorf("failed to decrypt value: %#v", dbValue)
+	}
+	if ciphertext == "" {
+		return nil
+	}
+	plaintext, err := es.KeyRing.DecryptWithAD(ciphertext, encryptAD(field))
+	if err != nil {
+		return err
+	}
+	fieldValue := reflect.New(field.FieldType)
+	switch field.FieldType.Kind() {
+	case reflect.String:
+		fieldValue.Elem().SetString(string(plaintext))
+	case reflect.Slice:
+		if field.FieldType.Elem().Kind() == reflect.Uint8 {
+			fieldValue.Elem().SetBytes(plaintext)
+			break
+		}
+		fallthrough
+	default:
+		if err := json.Unmarshal(plaintext, fieldValue.Interface()); err != nil {
+			return err
+		}
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+func (es EncryptSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var plaintext []byte
+	switch v := fieldValue.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		plaintext = []byte(v)
+	case []byte:
+		plaintext = v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		plaintext = data
+	}
+	return es.KeyRing.EncryptWithAD(plaintext, encryptAD(field))
+}
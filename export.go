@@ -0,0 +1,221 @@
+package giu
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ExportParams struct {
+	// Fields is the allowlist of exportable columns, selected with query "fields=a,b".
+	Fields []string
+	// DefaultFields are exported when fields is not in query, default is all of Fields.
+	DefaultFields []string
+	// FlushEvery is the number of rows between response flushes, default is 500.
+	FlushEvery int
+	// ProgressEvery is the number of rows between progress logs, default is 100000.
+	ProgressEvery int
+}
+
+var _defaultExportParams = ExportParams{
+	FlushEvery:    500,
+	ProgressEvery: 100000,
+}
+
+const (
+	EXPORT_FORMAT_CSV    = "csv"
+	EXPORT_FORMAT_NDJSON = "ndjson"
+)
+
+// Exporter streams query results as csv or ndjson. Rows are read from the database cursor and written to the
+// response one by one, so a slow client slows down the reading instead of buffering the result in memory.
+type Exporter struct {
+	params ExportParams
+	fields map[string]bool
+	logger *zap.Logger
+}
+
+func NewExporter(params ExportParams, zl *zap.Logger) *Exporter {
+	if params.FlushEvery <= 0 {
+		params.FlushEvery = _defaultExportParams.FlushEvery
+	}
+	if params.ProgressEvery <= 0 {
+		params.ProgressEvery = _defaultExportParams.ProgressEvery
+	}
+	if len(params.DefaultFields) == 0 {
+		params.DefaultFields = params.Fields
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	fields := make(map[string]bool, len(params.Fields))
+	for _, f := range params.Fields {
+		fields[f] = true
+	}
+	return &Exporter{params: params, fields: fields, logger: zl.With(zap.String("module", "export"))}
+}
+
+// Fields parses the selected fields of the request, fields out of allowlist are rejected.
+func (e *Exporter) Fields(c *gin.Context) ([]string, error) {
+	v := c.Query("fields")
+	if v == "" {
+		return e.params.DefaultFields, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !e.fields[f] {
+			return nil, fmt.Errorf("unsupported export field: %s", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Handler returns a gin handler which exports the query of queryFunc in format as an attachment named filename,
+// e.g. queryFunc applies the filters of a Paginator page.
+func (e *Exporter) Handler(format, filename string, queryFunc func(c *gin.Context) (*gorm.DB, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db, err := queryFunc(c)
+		if err != nil {
+			AbortWithErrorResponse(c, http.StatusBadRequest, err)
+			return
+		}
+		e.Export(c, format, filename, db)
+	}
+}
+
+// Export streams the result of db in format as an attachment named filename. The response is already
+// committed when the query fails after the first row, so the error is logged and the response is truncated.
+func (e *Exporter) Export(c *gin.Context, format, filename string, db *gorm.DB) {
+	fields, err := e.Fields(c)
+	if err != nil {
+		AbortWithErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+	columns := make([]clause.Column, len(fields))
+	for i, f := range fields {
+		columns[i] = clause.Column{Name: f}
+	}
+	ctx := c.Request.Context()
+	rows, err := db.WithContext(ctx).Clauses(clause.Select{Columns: columns}).Rows()
+	if err != nil {
+		AbortWithErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	contentType := "text/csv; charset=utf-8"
+	if format == EXPORT_FORMAT_NDJSON {
+		contentType = "application/x-ndjson"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(filename))
+	c.Status(http.StatusOK)
+
+	var write func(values []interface{}) error
+	var flush func() error
+	switch format {
+	case EXPORT_FORMAT_NDJSON:
+		enc := json.NewEncoder(c.Writer)
+		write = func(values []interface{}) error {
+			obj := make(map[string]interface{}, len(fields))
+			for i, f := range fields {
+				obj[f] = values[i]
+			}
+			return enc.Encode(obj)
+		}
+		flush = func() error { return nil }
+	default:
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write(fields); err != nil {
+			return
+		}
+		record := make([]string, len(fields))
+		write = func(values []interface{}) error {
+			for i, v := range values {
+				record[i] = exportText(v)
+			}
+			return w.Write(record)
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	}
+
+	logger := LoggerWithScope(ctx, e.logger).With(zap.String("format", format), zap.String("file", filename))
+	start := time.Now()
+	values := make([]interface{}, len(fields))
+	ptrs := make([]interface{}, len(fields))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	var n int
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			logger.Warn("[export] scan failed", zap.Error(err), zap.Int("rows", n))
+			return
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if err := write(values); err != nil {
+			logger.Warn("[export] write failed", zap.Error(err), zap.Int("rows", n))
+			return
+		}
+		n++
+		if n%e.params.FlushEvery == 0 {
+			if err := flush(); err != nil {
+				logger.Warn("[export] write failed", zap.Error(err), zap.Int("rows", n))
+				return
+			}
+			c.Writer.Flush()
+			if ctx.Err() != nil {
+				logger.Warn("[export] client gone", zap.Error(context.Cause(ctx)), zap.Int("rows", n))
+				return
+			}
+		}
+		if n%e.params.ProgressEvery == 0 {
+			logger.Info("[export] progress", zap.Int("rows", n), zap.Duration("elapsed", time.Since(start)))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Warn("[export] query failed", zap.Error(err), zap.Int("rows", n))
+		return
+	}
+	if err := flush(); err != nil {
+		logger.Warn("[export] write failed", zap.Error(err), zap.Int("rows", n))
+		return
+	}
+	c.Writer.Flush()
+	logger.Info("[export] done", zap.Int("rows", n), zap.Duration("elapsed", time.Since(start)))
+}
+
+func exportText(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case time.Time:
+		return x.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(x)
+	}
+}
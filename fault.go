@@ -0,0 +1,150 @@
+package giu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+)
+
+// FaultParams are the faults injected for resilience testing, nothing is injected unless Enabled is true.
+type FaultParams struct {
+	Enabled bool
+	// Paths are the path prefixes of injected requests, empty means all.
+	Paths []string
+	// Latency is added to LatencyRate of requests.
+	Latency     time.Duration
+	LatencyRate float64
+	// ErrorStatus is responded to ErrorRate of requests, default is 503.
+	ErrorStatus int
+	ErrorRate   float64
+	// ResetRate of connections are reset.
+	ResetRate float64
+}
+
+var ERR_FAULT_CONNECTION_RESET = errors.New("fault injected: connection reset")
+
+// GIN_FAULT_HEADER is set on the responses and the injected errors, so they can be told from real ones.
+var GIN_FAULT_HEADER = "X-Giu-Fault"
+
+func (p *FaultParams) match(path string) bool {
+	if !p.Enabled {
+		return false
+	}
+	if len(p.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range p.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// NewGinMiddlewareFault returns a gin middleware which injects latency, error responses and connection resets.
+func NewGinMiddlewareFault(params FaultParams, zl *zap.Logger) gin.HandlerFunc {
+	if params.ErrorStatus == 0 {
+		params.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "fault"))
+	return func(c *gin.Context) {
+		if !params.match(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if hit(params.LatencyRate) {
+			select {
+			case <-time.After(params.Latency):
+			case <-c.Request.Context().Done():
+			}
+		}
+		if hit(params.ResetRate) {
+			zl.Info("[gin fault] connection reset", zap.String("path", c.Request.URL.Path))
+			if conn, _, err := c.Writer.Hijack(); err == nil {
+				if tcp, ok := conn.(*net.TCPConn); ok {
+					// close with RST instead of FIN
+					_ = tcp.SetLinger(0)
+				}
+				_ = conn.Close()
+				c.Abort()
+				return
+			}
+		}
+		if hit(params.ErrorRate) {
+			zl.Info("[gin fault] error injected", zap.String("path", c.Request.URL.Path), zap.Int("status", params.ErrorStatus))
+			c.Header(GIN_FAULT_HEADER, "error")
+			c.AbortWithStatus(params.ErrorStatus)
+			return
+		}
+		c.Next()
+	}
+}
+
+// faultTransport injects faults into outbound requests.
+type faultTransport struct {
+	base   http.RoundTripper
+	params FaultParams
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.params.match(req.URL.Path) {
+		return t.base.RoundTrip(req)
+	}
+	if hit(t.params.LatencyRate) {
+		select {
+		case <-time.After(t.params.Latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if hit(t.params.ResetRate) {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: errors.Join(ERR_FAULT_CONNECTION_RESET, syscall.ECONNRESET)}
+	}
+	if hit(t.params.ErrorRate) {
+		header := http.Header{}
+		header.Set(GIN_FAULT_HEADER, "error")
+		return &http.Response{
+			Status:     http.StatusText(t.params.ErrorStatus),
+			StatusCode: t.params.ErrorStatus,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     header,
+			Body:       io.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}, nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+// SetRestyFault injects latency, error responses and connection resets into the requests of client.
+func SetRestyFault(client *resty.Client, params FaultParams) {
+	if !params.Enabled {
+		return
+	}
+	if params.ErrorStatus == 0 {
+		params.ErrorStatus = http.StatusServiceUnavailable
+	}
+	base := client.GetClient().Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.SetTransport(&faultTransport{base: base, params: params})
+}
@@ -0,0 +1,257 @@
+package giu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Stateful is an entity managed by a Fsm, the state is persisted in a column of its table.
+type Stateful interface {
+	GetState() string
+	SetState(state string)
+}
+
+// FsmTransition moves an entity from any state of From to To when Event is fired.
+type FsmTransition struct {
+	Event string
+	From  []string
+	To    string
+}
+
+// FsmEvent is passed to guards, hooks and notifiers.
+type FsmEvent struct {
+	Machine  string      `json:"machine"`
+	Event    string      `json:"event"`
+	From     string      `json:"from"`
+	To       string      `json:"to"`
+	Entity   string      `json:"entity"`
+	EntityID string      `json:"entity_id"`
+	Payload  interface{} `json:"payload,omitempty"`
+	Time     time.Time   `json:"time"`
+	// Target is the entity of the transition, it's not serialized.
+	Target Stateful `json:"-"`
+}
+
+// FsmGuard rejects a transition by returning an error.
+type FsmGuard func(ctx context.Context, e *FsmEvent) error
+
+// FsmHook runs on transitions. Before hooks run in the transaction of the transition, the transition is
+// rolled back if one fails. After hooks run after the commit, their errors are only logged.
+type FsmHook func(ctx context.Context, e *FsmEvent) error
+
+// FsmNotifier publishes committed transitions, e.g. to an event bus.
+type FsmNotifier func(ctx context.Context, e *FsmEvent) error
+
+var (
+	ERR_FSM_INVALID_TRANSITION = errors.New("fsm transition is not allowed")
+	ERR_FSM_STALE_STATE        = errors.New("fsm state is changed by another transition")
+)
+
+// FsmHistory is a transition record, migrate it with db.AutoMigrate(&FsmHistory{}) when history is enabled.
+type FsmHistory struct {
+	ID        uint   `gorm:"primaryKey"`
+	Machine   string `gorm:"size:64;index:idx_giu_fsm_entity,priority:1"`
+	Entity    string `gorm:"size:64;index:idx_giu_fsm_entity,priority:2"`
+	EntityID  string `gorm:"size:64;index:idx_giu_fsm_entity,priority:3"`
+	Event     string `gorm:"size:64"`
+	From      string `gorm:"size:64"`
+	To        string `gorm:"size:64"`
+	Payload   string
+	CreatedAt time.Time
+}
+
+func (FsmHistory) TableName() string {
+	return "giu_fsm_history"
+}
+
+type FsmParams struct {
+	// Name identifies the machine in events and history.
+	Name string
+	// Column is the state column of the entity table, default is "state".
+	Column string
+	// History records every transition to FsmHistory.
+	History bool
+}
+
+var _defaultFsmParams = FsmParams{
+	Column: "state",
+}
+
+// Fsm is a finite state machine of entities persisted with gorm. Transitions are conditional updates of the
+// state column, so concurrent transitions of the same entity can't both succeed.
+type Fsm struct {
+	params      FsmParams
+	transitions map[string]map[string]string
+	guards      map[string][]FsmGuard
+	before      []FsmHook
+	after       []FsmHook
+	enter       map[string][]FsmHook
+	notifiers   []FsmNotifier
+	logger      *zap.Logger
+}
+
+func NewFsm(params FsmParams, transitions []FsmTransition, zl *zap.Logger) *Fsm {
+	if params.Column == "" {
+		params.Column = _defaultFsmParams.Column
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	f := &Fsm{
+		params:      params,
+		transitions: make(map[string]map[string]string),
+		guards:      make(map[string][]FsmGuard),
+		enter:       make(map[string][]FsmHook),
+		logger:      zl.With(zap.String("module", "fsm"), zap.String("machine", params.Name)),
+	}
+	for _, t := range transitions {
+		if f.transitions[t.Event] == nil {
+			f.transitions[t.Event] = make(map[string]string)
+		}
+		for _, from := range t.From {
+			f.transitions[t.Event][from] = t.To
+		}
+	}
+	return f
+}
+
+// Guard adds a guard of event.
+func (f *Fsm) Guard(event string, guard FsmGuard) *Fsm {
+	f.guards[event] = append(f.guards[event], guard)
+	return f
+}
+
+// Before adds a hook running in the transaction of every transition.
+func (f *Fsm) Before(hook FsmHook) *Fsm {
+	f.before = append(f.before, hook)
+	return f
+}
+
+// After adds a hook running after every committed transition.
+func (f *Fsm) After(hook FsmHook) *Fsm {
+	f.after = append(f.after, hook)
+	return f
+}
+
+// OnEnter adds a hook running after a committed transition to state.
+func (f *Fsm) OnEnter(state string, hook FsmHook) *Fsm {
+	f.enter[state] = append(f.enter[state], hook)
+	return f
+}
+
+// Notify adds a notifier of committed transitions.
+func (f *Fsm) Notify(notifier FsmNotifier) *Fsm {
+	f.notifiers = append(f.notifiers, notifier)
+	return f
+}
+
+// Can reports whether event is allowed in state, guards are not checked.
+func (f *Fsm) Can(state, event string) bool {
+	_, ok := f.transitions[event][state]
+	return ok
+}
+
+// Events returns the events allowed in state.
+func (f *Fsm) Events(state string) []string {
+	var events []string
+	for event, from := range f.transitions {
+		if _, ok := from[state]; ok {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Fire applies event to entity, which must be a pointer to a gorm model with primary key. The state column is
+// updated only if it's still the state of entity, otherwise ERR_FSM_STALE_STATE is returned. If ctx has a
+// transaction, see WithTx, the transition joins it.
+func (f *Fsm) Fire(ctx context.Context, db *gorm.DB, entity Stateful, event string, payload interface{}) error {
+	from := entity.GetState()
+	to, ok := f.transitions[event][from]
+	if !ok {
+		return fmt.Errorf("%w: %s on %s", ERR_FSM_INVALID_TRANSITION, event, from)
+	}
+	e := &FsmEvent{Machine: f.params.Name, Event: event, From: from, To: to, Payload: payload, Time: time.Now(), Target: entity}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(entity); err != nil {
+		return err
+	}
+	e.Entity = stmt.Schema.Table
+	if pk := stmt.Schema.PrioritizedPrimaryField; pk != nil {
+		if v, zero := pk.ValueOf(ctx, reflect.Indirect(reflect.ValueOf(entity))); !zero {
+			e.EntityID = fmt.Sprint(v)
+		}
+	}
+	for _, guard := range f.guards[event] {
+		if err := guard(ctx, e); err != nil {
+			return err
+		}
+	}
+	err := Transaction(ctx, db, func(ctx context.Context) error {
+		tx, _ := TxFromContext(ctx)
+		res := tx.Model(entity).Where(f.params.Column+" = ?", from).Update(f.params.Column, to)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ERR_FSM_STALE_STATE
+		}
+		if f.params.History {
+			h := FsmHistory{Machine: e.Machine, Entity: e.Entity, EntityID: e.EntityID, Event: event, From: from, To: to}
+			if payload != nil {
+				data, _ := json.Marshal(payload)
+				h.Payload = string(data)
+			}
+			if err := tx.Create(&h).Error; err != nil {
+				return err
+			}
+		}
+		for _, hook := range f.before {
+			if err := hook(ctx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	entity.SetState(to)
+	logger := LoggerWithScope(ctx, f.logger).With(
+		zap.String("event", event), zap.String("from", from), zap.String("to", to), zap.String("entity_id", e.EntityID))
+	logger.Debug("[fsm] transition")
+	// the transition is committed, hooks and notifiers can't undo it
+	ctx = context.WithoutCancel(ctx)
+	hooks := append(append([]FsmHook{}, f.after...), f.enter[to]...)
+	for _, hook := range hooks {
+		if err := hook(ctx, e); err != nil {
+			logger.Warn("[fsm] after hook failed", zap.Error(err))
+		}
+	}
+	for _, notify := range f.notifiers {
+		if err := notify(ctx, e); err != nil {
+			logger.Warn("[fsm] notify failed", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// NewStreamFsmNotifier returns a notifier which adds transitions as json to a redis stream, so they can be
+// consumed by a StreamConsumer.
+func NewStreamFsmNotifier(rdb redis.UniversalClient, stream string) FsmNotifier {
+	return func(ctx context.Context, e *FsmEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]interface{}{"event": data}}).Err()
+	}
+}
@@ -1,9 +1,20 @@
 package giu
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -27,42 +38,242 @@ func filterFlags(content string) string {
 	return content
 }
 
-// bodyLogWriter is a wrapper around ResponseWriter that allows us to read the response body
+// bodyLogWriter is a wrapper around ResponseWriter that allows us to read the response body,
+// at most max bytes are captured, negative max means unlimited.
 type bodyLogWriter struct {
 	gin.ResponseWriter
 	body *bytes.Buffer
+	max  int
 }
 
 func (w bodyLogWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
+	w.capture(b)
 	return w.ResponseWriter.Write(b)
 }
 
+func (w bodyLogWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w bodyLogWriter) capture(b []byte) {
+	if w.max >= 0 {
+		room := w.max - w.body.Len()
+		if room <= 0 {
+			return
+		}
+		if len(b) > room {
+			b = b[:room]
+		}
+	}
+	w.body.Write(b)
+}
+
+// ginLogBufferPool reuses the body buffers of the logging middleware.
+var ginLogBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// GIN_LOG_MAX_BUFFER is the max capacity of body buffers put back to the pool, larger ones are dropped.
+var GIN_LOG_MAX_BUFFER = 1 << 20
+
+func getLogBuffer() *bytes.Buffer {
+	buf := ginLogBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putLogBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= GIN_LOG_MAX_BUFFER {
+		ginLogBufferPool.Put(buf)
+	}
+}
+
+// GinLoggerParams are the options of the gin logging middleware, json bodies are always logged.
+type GinLoggerParams struct {
+	// Query logs the query params.
+	Query bool
+	// PathParams logs the path params of the route.
+	PathParams bool
+	// Form logs the urlencoded and multipart form fields, files are logged with names and sizes. Only the first
+	// MaxBody bytes of the form are parsed, the fields and files after them are not logged and "form_truncated" is set.
+	Form bool
+	// Redact are the keys of query params and form fields whose values are redacted, default is GIN_LOG_REDACT.
+	Redact []string
+	// BodyTypes are the media types of logged bodies, default is GIN_LOG_BODY_TYPES.
+	// A type may be a suffix pattern like "application/*+json".
+	BodyTypes []string
+	// MaxBody is the max logged bytes of each request and response body, default is GIN_LOG_MAX_BODY,
+	// negative means unlimited. At most MaxBody bytes of the request body are buffered, the handlers read the rest
+	// from the connection. The size of a truncated body is logged as "body_size", it's -1 if it's unknown.
+	// A truncated compressed response is logged with the decoded part before the cut and "body_truncated".
+	MaxBody int
+	// ErrorOnly buffers the bodies, but logs the request and response only when the status is 400 or above, or the
+	// latency exceeds SlowThreshold if it's set. The status and latency are logged with the response.
+	ErrorOnly     bool
+	SlowThreshold time.Duration
+}
+
+// GIN_LOG_MAX_BODY is the default max logged bytes of a body.
+var GIN_LOG_MAX_BODY = 64 << 10
+
+// GIN_LOG_BODY_TYPES are the default media types of logged bodies.
+var GIN_LOG_BODY_TYPES = []string{gin.MIMEJSON, "application/*+json"}
+
+// GIN_LOG_REDACT are the default redacted keys, they are matched case-insensitively.
+var GIN_LOG_REDACT = []string{"password", "passwd", "secret", "token", "access_token", "refresh_token", "authorization", "api_key"}
+
 // NewGinMiddlewareJsonLogger returns a gin middleware for logging json request and response.
 func NewGinMiddlewareJsonLogger(l *zap.Logger) gin.HandlerFunc {
+	return NewGinMiddlewareLogger(l, GinLoggerParams{})
+}
+
+// NewGinMiddlewareLogger returns a gin middleware for logging request and response,
+// json bodies are logged, and query params, path params and form fields are logged as params enabled.
+func NewGinMiddlewareLogger(l *zap.Logger, params GinLoggerParams) gin.HandlerFunc {
+	redact := params.Redact
+	if redact == nil {
+		redact = GIN_LOG_REDACT
+	}
+	bodyTypes := params.BodyTypes
+	if bodyTypes == nil {
+		bodyTypes = GIN_LOG_BODY_TYPES
+	}
+	maxBody := params.MaxBody
+	if maxBody == 0 {
+		maxBody = GIN_LOG_MAX_BODY
+	}
 	return func(c *gin.Context) {
 		// before request
-		if filterFlags(c.ContentType()) == gin.MIMEJSON {
-			data, _ := c.GetRawData()
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(data))
-			l.Info("[gin request]",
-				zap.String("method", c.Request.Method),
-				zap.String("path", c.Request.URL.Path),
-				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)),
-				zap.Any("body", json.RawMessage(data)))
+		start := time.Now()
+		var fields []zap.Field
+		if params.Query && c.Request.URL.RawQuery != "" {
+			fields = append(fields, zap.Any("query", redactValues(c.Request.URL.Query(), redact)))
+		}
+		if params.PathParams && len(c.Params) > 0 {
+			pathParams := make(map[string]string, len(c.Params))
+			for _, p := range c.Params {
+				pathParams[p.Key] = p.Value
+			}
+			fields = append(fields, zap.Any("params", pathParams))
+		}
+		// at most maxBody bytes of the request body are read into a pooled buffer, which is put back after the
+		// handlers, the handlers read the buffered bytes and then the rest of the body
+		var reqBuf *bytes.Buffer
+		readBody := func() (body []byte, truncated bool) {
+			reqBuf = getLogBuffer()
+			src := c.Request.Body
+			if maxBody >= 0 {
+				src = io.NopCloser(io.LimitReader(src, int64(maxBody)+1))
+			}
+			_, _ = reqBuf.ReadFrom(src)
+			body = reqBuf.Bytes()
+			if maxBody >= 0 && len(body) > maxBody {
+				body, truncated = body[:maxBody], true
+			}
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(reqBuf.Bytes()), c.Request.Body), c.Request.Body}
+			return body, truncated
+		}
+		switch ct := filterFlags(c.ContentType()); {
+		case isLoggableType(c.GetHeader("Content-Type"), bodyTypes):
+			body, truncated := readBody()
+			if !truncated {
+				fields = append(fields, zapBody(body))
+			} else {
+				fields = append(fields, zap.ByteString("body", body), zap.Int64("body_size", c.Request.ContentLength))
+			}
+		case params.Form && (ct == gin.MIMEPOSTForm || ct == gin.MIMEMultipartPOSTForm):
+			body, truncated := readBody()
+			if form := parseLogForm(c.GetHeader("Content-Type"), body, truncated); len(form) > 0 {
+				fields = append(fields, zap.Any("form", redactValues(form, redact)))
+			}
+			if truncated {
+				fields = append(fields, zap.Bool("form_truncated", true))
+			}
+		}
+		logRequest := func() {
+			if len(fields) > 0 {
+				LoggerWithScope(c.Request.Context(), l).Info("[gin request]", append([]zap.Field{
+					zap.String("method", c.Request.Method),
+					zap.String("path", c.Request.URL.Path),
+					zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)),
+				}, fields...)...)
+			}
+		}
+		if !params.ErrorOnly {
+			logRequest()
 		}
 
-		bw := bodyLogWriter{body: bytes.NewBuffer([]byte{}), ResponseWriter: c.Writer}
+		bw := bodyLogWriter{body: getLogBuffer(), ResponseWriter: c.Writer, max: maxBody}
 		c.Writer = bw
+		defer func() {
+			// the outer middlewares must not write or read the buffers after they're put back
+			c.Writer = bw.ResponseWriter
+			putLogBuffer(bw.body)
+			if reqBuf != nil {
+				c.Request.Body = http.NoBody
+				putLogBuffer(reqBuf)
+			}
+		}()
 		c.Next()
 
 		// after request
-		if filterFlags(c.Writer.Header().Get("Content-Type")) == gin.MIMEJSON {
-			l.Info("[gin response]",
+		var extra []zap.Field
+		if params.ErrorOnly {
+			latency := time.Since(start)
+			if c.Writer.Status() < http.StatusBadRequest && (params.SlowThreshold <= 0 || latency < params.SlowThreshold) {
+				return
+			}
+			logRequest()
+			extra = []zap.Field{zap.Int("status", c.Writer.Status()), zap.Duration("latency", latency)}
+		}
+		negotiated := c.GetString(GIN_NEGOTIATED_TYPE)
+		if isLoggableType(c.Writer.Header().Get("Content-Type"), bodyTypes) {
+			respFields := append([]zap.Field{
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)),
+			}, extra...)
+			if negotiated != "" {
+				respFields = append(respFields, zap.String("negotiated", negotiated))
+			}
+			// the body is compressed if a compression middleware runs after the logger
+			encoding := c.Writer.Header().Get("Content-Encoding")
+			captured := bw.body.Bytes()
+			if encoding != "" {
+				respFields = append(respFields, zap.String("content_encoding", encoding))
+			}
+			if size := c.Writer.Size(); size > len(captured) {
+				// the body is truncated, the decoded part before the cut is logged
+				body, err := decodeLogBodyPrefix(encoding, captured)
+				if err != nil {
+					body = captured
+				}
+				if maxBody >= 0 && len(body) > maxBody {
+					body = body[:maxBody]
+				}
+				respFields = append(respFields, zap.ByteString("body", body), zap.Int("body_size", size), zap.Bool("body_truncated", true))
+			} else if body, err := decodeLogBody(encoding, captured); err == nil {
+				respFields = append(respFields, zapCappedBody(body, maxBody)...)
+			} else {
+				// e.g. a compression middleware outside the logger sets the header, but the captured body is plain
+				respFields = append(respFields, zapCappedBody(captured, maxBody)...)
+			}
+			LoggerWithScope(c.Request.Context(), l).Info("[gin response]", respFields...)
+		} else if negotiated != "" {
+			// binary bodies like protobuf are not logged, only their negotiated type and size
+			LoggerWithScope(c.Request.Context(), l).Info("[gin response]", append([]zap.Field{
 				zap.String("method", c.Request.Method),
 				zap.String("path", c.Request.URL.Path),
 				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)),
-				zap.Any("body", json.RawMessage(bw.body.Bytes())))
+				zap.String("negotiated", negotiated),
+				zap.Int("size", c.Writer.Size()),
+			}, extra...)...)
 		}
 	}
 }
@@ -70,30 +281,192 @@ func NewGinMiddlewareJsonLogger(l *zap.Logger) gin.HandlerFunc {
 // NewGinMiddlewareTrace returns a gin middleware for adding trace id to request header.
 func NewGinMiddlewareTrace() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		traceID := c.GetHeader(GIN_TRACE_ID)
-		if traceID == "" {
-			traceID = uuid.New().String()
-			c.Header(GIN_TRACE_ID, traceID)
+		if c.GetHeader(GIN_TRACE_ID) == "" {
+			// the id string is the only allocation besides the header value
+			c.Writer.Header()[http.CanonicalHeaderKey(GIN_TRACE_ID)] = []string{newTraceID()}
 		}
 		c.Next()
 	}
 }
 
-type zapWriter struct {
-	zl *zap.Logger
+// traceRand buffers crypto/rand, so the trace ids don't take a syscall each.
+var traceRand = struct {
+	sync.Mutex
+	r *bufio.Reader
+}{r: bufio.NewReaderSize(rand.Reader, 4096)}
+
+// newTraceID returns a random uuid string.
+func newTraceID() string {
+	traceRand.Lock()
+	id, err := uuid.NewRandomFromReader(traceRand.r)
+	traceRand.Unlock()
+	if err != nil {
+		return uuid.New().String()
+	}
+	return id.String()
 }
 
-func (zw *zapWriter) Write(p []byte) (n int, err error) {
-	zw.zl.Panic(string(p))
-	return len(p), nil
+// GIN_STATUS_CLIENT_CLOSED is the status of requests canceled by their clients, like the 499 of nginx.
+const GIN_STATUS_CLIENT_CLOSED = 499
+
+// IsGinClientCanceled reports whether the client of the request went away, i.e. the request context is canceled
+// but not timed out, or a handler failed with a broken connection error.
+func IsGinClientCanceled(c *gin.Context) bool {
+	if errors.Is(c.Request.Context().Err(), context.Canceled) {
+		return true
+	}
+	for _, err := range c.Errors {
+		if isClientGone(err.Err) {
+			return true
+		}
+	}
+	return false
 }
 
-func writerFromZapLogger(l *zap.Logger) *zapWriter {
-	return &zapWriter{l}
+func isClientGone(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
 }
 
+// ginResponseStatus returns the status of the response, or GIN_STATUS_CLIENT_CLOSED if the client went away, so
+// the canceled requests are not counted as server errors.
+func ginResponseStatus(c *gin.Context) int {
+	if IsGinClientCanceled(c) {
+		return GIN_STATUS_CLIENT_CLOSED
+	}
+	return c.Writer.Status()
+}
+
+// NewGinMiddlewareClientCanceled returns a gin middleware which logs the requests canceled by their clients at
+// debug level, and sets their status to GIN_STATUS_CLIENT_CLOSED if nothing is written. Use it after the logger,
+// otel and breaker middlewares, so they see the status.
+func NewGinMiddlewareClientCanceled(zl *zap.Logger) gin.HandlerFunc {
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "canceled"))
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		if !IsGinClientCanceled(c) {
+			return
+		}
+		if !c.Writer.Written() {
+			c.Status(GIN_STATUS_CLIENT_CLOSED)
+		}
+		LoggerWithScope(c.Request.Context(), zl).Debug("[gin] request canceled by client",
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)),
+			zap.Int("status", c.Writer.Status()),
+			zap.Duration("latency", time.Since(start)))
+	}
+}
+
+type GinRecoveryParams struct {
+	// RedactHeaders are the request headers whose values are redacted, default is GIN_RECOVERY_REDACT_HEADERS.
+	RedactHeaders []string
+	// Goroutines logs the stacks of all goroutines, it's expensive, enable it only to debug deadlocks.
+	Goroutines bool
+	// Alerter sends the panic if it's not nil.
+	Alerter Alerter
+}
+
+// GIN_RECOVERY_ALERT_TIMEOUT is the timeout of sending a panic alert.
+var GIN_RECOVERY_ALERT_TIMEOUT = 10 * time.Second
+
+// GIN_RECOVERY_REDACT_HEADERS are the default redacted headers of panic reports, they are matched case-insensitively.
+var GIN_RECOVERY_REDACT_HEADERS = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
 // NewGinMiddlewareRecovery returns a gin middleware for recovery with zap logger.
 func NewGinMiddlewareRecovery(zl *zap.Logger) gin.HandlerFunc {
+	return NewGinMiddlewareRecoveryWithParams(zl, GinRecoveryParams{})
+}
+
+// NewGinMiddlewareRecoveryWithAlerter returns a gin middleware for recovery with zap logger, the panic is also sent with alerter.
+func NewGinMiddlewareRecoveryWithAlerter(zl *zap.Logger, alerter Alerter) gin.HandlerFunc {
+	return NewGinMiddlewareRecoveryWithParams(zl, GinRecoveryParams{Alerter: alerter})
+}
+
+// NewGinMiddlewareRecoveryWithParams returns a gin middleware for recovery, which logs a report of the panic with
+// the stack, the request method, path, redacted headers, trace id and the user and tenant of the request scope.
+// The report is logged at Panic level, which panics again after logging like the baseline recovery, unless the
+// logger has a panic hook which doesn't, then it responds 500. Panics caused by a broken connection are logged at
+// Warn level and aborted. The alert is sent in background.
+func NewGinMiddlewareRecoveryWithParams(zl *zap.Logger, params GinRecoveryParams) gin.HandlerFunc {
 	zl = zl.With(zap.String("module", "gin"), zap.String("type", "recovery"))
-	return gin.RecoveryWithWriter(writerFromZapLogger(zl))
+	redact := params.RedactHeaders
+	if redact == nil {
+		redact = GIN_RECOVERY_REDACT_HEADERS
+	}
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			err, _ := rec.(error)
+			brokenPipe := isClientGone(err)
+			fields := []zap.Field{
+				zap.String("panic", fmt.Sprint(rec)),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)),
+				zap.Any("headers", redactValues(url.Values(c.Request.Header), redact)),
+				zap.Bool("broken_pipe", brokenPipe),
+				zap.ByteString("stack", debug.Stack()),
+			}
+			if params.Goroutines {
+				fields = append(fields, zap.ByteString("goroutines", allGoroutineStacks()))
+			}
+			if params.Alerter != nil {
+				alertFields := map[string]string{
+					"method":     c.Request.Method,
+					"path":       c.Request.URL.Path,
+					GIN_TRACE_ID: c.GetHeader(GIN_TRACE_ID),
+				}
+				if scope, ok := RequestScopeFromContext(c.Request.Context()); ok {
+					alertFields["user"], alertFields["tenant"] = scope.User, scope.Tenant
+				}
+				alert := Alert{
+					Level:   LOG_LEVEL_ERROR,
+					Title:   "[gin] panic recovered",
+					Content: fmt.Sprint(rec),
+					Fields:  alertFields,
+					Time:    time.Now(),
+				}
+				// a slow alerter must not hold the response
+				ctx := context.WithoutCancel(c.Request.Context())
+				go func() {
+					ctx, cancel := context.WithTimeout(ctx, GIN_RECOVERY_ALERT_TIMEOUT)
+					defer cancel()
+					_ = params.Alerter.Alert(ctx, alert)
+				}()
+			}
+			if brokenPipe {
+				// the client went away, it's not a server error
+				LoggerWithScope(c.Request.Context(), zl).Warn("[gin recovery] panic recovered", fields...)
+			} else {
+				// Panic level panics again after logging like the former recovery writer, unless the logger has
+				// a panic hook which doesn't
+				LoggerWithScope(c.Request.Context(), zl).Panic("[gin recovery] panic recovered", fields...)
+			}
+			if brokenPipe {
+				// the connection is broken, the status can't be written
+				_ = c.Error(err)
+				c.Abort()
+				return
+			}
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}()
+		c.Next()
+	}
+}
+
+// allGoroutineStacks returns the stacks of all goroutines, the buffer grows until they fit.
+func allGoroutineStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
 }
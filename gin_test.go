@@ -0,0 +1,35 @@
+package giu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func benchmarkGin(b *testing.B, middlewares ...gin.HandlerFunc) {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(middlewares...)
+	r.POST("/bench", func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/bench", strings.NewReader(`{"ping":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkGinLogger(b *testing.B) {
+	benchmarkGin(b, NewGinMiddlewareLogger(zap.NewNop(), GinLoggerParams{}))
+}
+
+func BenchmarkGinTrace(b *testing.B) {
+	benchmarkGin(b, NewGinMiddlewareTrace())
+}
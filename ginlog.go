@@ -0,0 +1,173 @@
+package giu
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// isLoggableType reports whether the media type of content type matches any of types,
+// a type like "application/*+json" matches the media types of the subtype suffix.
+func isLoggableType(contentType string, types []string) bool {
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if prefix, suffix, ok := strings.Cut(t, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) && strings.HasSuffix(mediaType, suffix) && len(mediaType) > len(prefix)+len(suffix) {
+				return true
+			}
+			continue
+		}
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// zapBody returns the body field, json bodies are logged as json, others as string.
+func zapBody(body []byte) zap.Field {
+	if json.Valid(body) {
+		return zap.Any("body", json.RawMessage(body))
+	}
+	return zap.ByteString("body", body)
+}
+
+// zapCappedBody returns the body field of at most max bytes, and the size if the body is truncated.
+func zapCappedBody(body []byte, max int) []zap.Field {
+	if max < 0 || len(body) <= max {
+		return []zap.Field{zapBody(body)}
+	}
+	return []zap.Field{zap.ByteString("body", body[:max]), zap.Int("body_size", len(body))}
+}
+
+// decodeLogBody decompresses the body of content encoding for logging.
+func decodeLogBody(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("[%s body of %d bytes, %s]", encoding, len(body), err)
+		}
+		defer gr.Close()
+		r = gr
+	case "deflate":
+		// deflate of http is zlib format, but some servers send raw deflate
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			defer zr.Close()
+			r = zr
+		} else {
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			r = fr
+		}
+	default:
+		return nil, fmt.Errorf("[%s body of %d bytes]", encoding, len(body))
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("[%s body of %d bytes, %s]", encoding, len(body), err)
+	}
+	return data, nil
+}
+
+// decodeLogBodyPrefix decompresses the prefix of a truncated body, it returns the bytes decoded before the cut.
+func decodeLogBodyPrefix(encoding string, prefix []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return prefix, nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(prefix))
+	case "deflate":
+		if r, err = zlib.NewReader(bytes.NewReader(prefix)); err != nil {
+			r, err = flate.NewReader(bytes.NewReader(prefix)), nil
+		}
+	default:
+		return nil, fmt.Errorf("[%s body prefix of %d bytes]", encoding, len(prefix))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// redactValues returns a copy of values whose redacted keys are replaced with "***".
+func redactValues(values url.Values, redact []string) url.Values {
+	out := make(url.Values, len(values))
+	for k, v := range values {
+		if isRedactedKey(k, redact) {
+			out[k] = []string{"***"}
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func isRedactedKey(key string, redact []string) bool {
+	for _, r := range redact {
+		if strings.EqualFold(key, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLogForm parses the urlencoded or multipart body for logging, files are described by names and sizes.
+// If the body is truncated, the part cut off is not reported as an error, and the size of its file is the size read.
+func parseLogForm(contentType string, body []byte, truncated bool) url.Values {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	if mediaType != "multipart/form-data" {
+		values, _ := url.ParseQuery(string(body))
+		return values
+	}
+	values := url.Values{}
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !truncated {
+				values.Add("_error", err.Error())
+			}
+			return values
+		}
+		if part.FileName() != "" {
+			n, _ := io.Copy(io.Discard, part)
+			values.Add(part.FormName(), fmt.Sprintf("file(%s, %d bytes)", part.FileName(), n))
+			continue
+		}
+		var value strings.Builder
+		_, _ = io.Copy(&value, part)
+		values.Add(part.FormName(), value.String())
+	}
+}
@@ -0,0 +1,144 @@
+package giutest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	giu "github.com/pkoukk/go-init-utils"
+)
+
+// FakeClock is a giu.Clock which only moves when Advance or Set is called.
+// Timers and sleeps whose deadline is reached are fired by Advance.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+var _ giu.Clock = (*FakeClock)(nil)
+
+// NewFakeClock creates a fake clock at now, if now is zero, it starts at 2020-01-01 UTC.
+func NewFakeClock(now time.Time) *FakeClock {
+	if now.IsZero() {
+		now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return &FakeClock{now: now}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *FakeClock) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// Sleep blocks until the clock is advanced by d.
+func (f *FakeClock) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+func (f *FakeClock) NewTimer(d time.Duration) giu.Timer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	f.schedule(t, d)
+	return t
+}
+
+func (f *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.deadline = f.now.Add(d)
+	if d <= 0 {
+		t.c <- f.now
+		return
+	}
+	t.active = true
+	f.waiters = append(f.waiters, t)
+}
+
+// Advance moves the clock forward by d and fires the due timers in deadline order.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to now and fires the due timers in deadline order.
+func (f *FakeClock) Set(now time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.now = now
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+	})
+	pending := f.waiters[:0]
+	for _, t := range f.waiters {
+		if !t.active {
+			continue
+		}
+		if t.deadline.After(now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.active = false
+		select {
+		case t.c <- t.deadline:
+		default:
+		}
+	}
+	f.waiters = pending
+}
+
+// Waiters returns the number of active timers, it helps to wait until a goroutine is blocked on the clock.
+func (f *FakeClock) Waiters() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n := 0
+	for _, t := range f.waiters {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	active := t.active
+	t.active = false
+	// drop the old entry, or the timer is queued twice
+	waiters := t.clock.waiters[:0]
+	for _, w := range t.clock.waiters {
+		if w != t {
+			waiters = append(waiters, w)
+		}
+	}
+	t.clock.waiters = waiters
+	t.clock.schedule(t, d)
+	return active
+}
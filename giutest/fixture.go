@@ -0,0 +1,184 @@
+// Package giutest provides helpers for testing services built on giu.
+package giutest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Fixtures are rows keyed by table name.
+type Fixtures map[string][]map[string]interface{}
+
+// FixtureLoader truncates tables and loads fixtures, tables are ordered by the foreign keys of the models:
+// parents are loaded before children, and children are truncated before parents.
+type FixtureLoader struct {
+	db     *gorm.DB
+	deps   map[string][]string
+	tables []string
+}
+
+// NewFixtureLoader creates a loader, models are used to resolve the foreign key order of tables.
+func NewFixtureLoader(db *gorm.DB, models ...interface{}) (*FixtureLoader, error) {
+	l := &FixtureLoader{db: db, deps: make(map[string][]string)}
+	for _, m := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return nil, err
+		}
+		table := stmt.Schema.Table
+		if _, ok := l.deps[table]; !ok {
+			l.deps[table] = nil
+		}
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if rel.Type == schema.BelongsTo && rel.FieldSchema.Table != table {
+				l.deps[table] = append(l.deps[table], rel.FieldSchema.Table)
+			}
+		}
+	}
+	order, err := l.sort()
+	if err != nil {
+		return nil, err
+	}
+	l.tables = order
+	return l, nil
+}
+
+// sort returns the tables in topological order, parents first.
+func (l *FixtureLoader) sort() ([]string, error) {
+	names := make([]string, 0, len(l.deps))
+	for t := range l.deps {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var order []string
+	var visit func(t string) error
+	visit = func(t string) error {
+		switch state[t] {
+		case visiting:
+			return fmt.Errorf("foreign key cycle on table %s", t)
+		case visited:
+			return nil
+		}
+		state[t] = visiting
+		for _, dep := range l.deps[t] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[t] = visited
+		order = append(order, t)
+		return nil
+	}
+	for _, t := range names {
+		if err := visit(t); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// ordered returns the tables of fixtures in load order, unknown tables are loaded last by name.
+func (l *FixtureLoader) ordered(fixtures Fixtures) []string {
+	var tables, unknown []string
+	known := make(map[string]bool, len(l.tables))
+	for _, t := range l.tables {
+		known[t] = true
+		if _, ok := fixtures[t]; ok {
+			tables = append(tables, t)
+		}
+	}
+	for t := range fixtures {
+		if !known[t] {
+			unknown = append(unknown, t)
+		}
+	}
+	sort.Strings(unknown)
+	return append(tables, unknown...)
+}
+
+// Truncate deletes all rows of the tables, children first. If no table is given, all model tables are truncated.
+func (l *FixtureLoader) Truncate(tables ...string) error {
+	if len(tables) == 0 {
+		tables = l.tables
+	} else {
+		f := make(Fixtures, len(tables))
+		for _, t := range tables {
+			f[t] = nil
+		}
+		tables = l.ordered(f)
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		if err := l.db.Exec("DELETE FROM ?", clause.Table{Name: tables[i]}).Error; err != nil {
+			return fmt.Errorf("truncate %s: %w", tables[i], err)
+		}
+	}
+	return nil
+}
+
+// Load truncates the tables of fixtures and inserts the rows, parents first.
+func (l *FixtureLoader) Load(fixtures Fixtures) error {
+	tables := l.ordered(fixtures)
+	if err := l.Truncate(tables...); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		rows := fixtures[t]
+		if len(rows) == 0 {
+			continue
+		}
+		if err := l.db.Table(t).Create(&rows).Error; err != nil {
+			return fmt.Errorf("load %s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// LoadFiles reads yaml or json fixture files and loads them, rows of the same table are merged.
+func (l *FixtureLoader) LoadFiles(paths ...string) error {
+	fixtures := make(Fixtures)
+	for _, path := range paths {
+		f, err := ReadFixtures(path)
+		if err != nil {
+			return err
+		}
+		for t, rows := range f {
+			fixtures[t] = append(fixtures[t], rows...)
+		}
+	}
+	return l.Load(fixtures)
+}
+
+// ReadFixtures reads a yaml or json fixture file, the top level keys are table names.
+func ReadFixtures(path string) (Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures Fixtures
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &fixtures)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &fixtures)
+	default:
+		return nil, fmt.Errorf("unsupported fixture file: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return fixtures, nil
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 	"time"
 
 	"go.uber.org/zap"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
 	"gorm.io/gorm/utils"
 )
 
@@ -21,11 +25,67 @@ type GormConnectionParams struct {
 	User     string
 	Password string
 	Database string
+	// EncryptKeys are base64 encoded AES keys of encrypted columns, the first one is used to encrypt, see KeyRing.
+	EncryptKeys []string
+	// EncryptSerializer is the serializer name of encrypted columns, default is "encrypt".
+	EncryptSerializer string
+	// QueryTimeout overrides the default statement timeout of gorm config, see QueryTimeoutPlugin.
+	QueryTimeout time.Duration
+	// ConnectRetries is the max attempts of opening the connection with DefaultRetryPolicy backoff, 0 means no retry.
+	ConnectRetries int
+	// TablePrefix, SingularTable and NoLowerCase override the naming strategy of gorm config for this connection,
+	// e.g. a legacy schema with different conventions. NoLowerCase keeps the field names as column names.
+	TablePrefix   string
+	SingularTable *bool
+	NoLowerCase   *bool
+	// SQLite is the options of the sqlite driver.
+	SQLite SQLiteParams
+}
+
+type SQLiteParams struct {
+	// Path is the database file, default is Database with ".db" suffix.
+	Path string
+	// Memory opens an in-memory database named by Database, it's shared by the connections of the pool.
+	Memory bool
+	// WAL turns on the write-ahead log journal mode, it's ignored by in-memory databases.
+	WAL bool
+	// ForeignKeys turns on the foreign key constraints.
+	ForeignKeys bool
+	// BusyTimeout is the time to wait for a locked database, 0 means failing immediately.
+	BusyTimeout time.Duration
 }
 
 type GormConfigParams struct {
 	*gorm.Config
 	LogLevel string
+	// PrepareStmt, SkipDefaultTransaction and DryRun override the fields of gorm.Config, which can't be set from
+	// config files because it's embedded as a pointer.
+	PrepareStmt            bool
+	SkipDefaultTransaction bool
+	DryRun                 bool
+	// TablePrefix and SingularTable set the naming strategy of gorm.Config.
+	TablePrefix   string
+	SingularTable bool
+	// SoftDelete rejects hard deletes of models without soft delete field, see SoftDeletePlugin.
+	SoftDelete bool
+	// OptimisticLock enables version column checking on updates, see OptimisticLockPlugin.
+	OptimisticLock bool
+	// QueryTimeout is the default statement timeout of all connections, 0 means no timeout.
+	QueryTimeout time.Duration
+	// Audit records changed rows of audited models into AuditTable, see AuditPlugin.
+	Audit       bool
+	AuditTable  string
+	AuditTables []string
+	// Otel records statement durations with OTel meters, see OtelPlugin.
+	Otel bool
+	// Failover is the ordered connection names of the default connection, e.g. primary then standby, the provider
+	// returns the first healthy one as default, see GiuProvider.SetFailover.
+	Failover []string
+	// Lazy opens each connection on its first Get instead of at startup, e.g. for many tenant databases.
+	Lazy bool
+	// Aliases are the logical names of connections, e.g. {"billing": "orders"} resolves "billing" to the
+	// connection "orders", see GiuProvider.AddAlias.
+	Aliases map[string]string
 }
 
 var _defaultGormParams = GormConnectionParams{
@@ -47,29 +107,121 @@ const (
 
 func NewGorm(params GormConnectionParams, configParams ...*GormConfigParams) (*gorm.DB, error) {
 	config := &gorm.Config{}
+	var param *GormConfigParams
 	if len(configParams) > 0 && configParams[0] != nil {
-		param := configParams[0]
-		if param.Config != nil {
-			config = configParams[0].Config
-		}
+		param = configParams[0]
+		config = copyGormConfig(param.Config)
 		if param.LogLevel != "" && param.Logger != nil {
 			level := convertGormLogLevel(configParams[0].LogLevel)
 			config.Logger = param.Logger.LogMode(level)
 		}
+		param.apply(config)
+	}
+	if params.TablePrefix != "" || params.SingularTable != nil || params.NoLowerCase != nil {
+		config.NamingStrategy = params.namingStrategy(config.NamingStrategy)
+	}
+
+	if len(params.EncryptKeys) > 0 {
+		kr, err := NewKeyRing(params.EncryptKeys)
+		if err != nil {
+			return nil, err
+		}
+		name := params.EncryptSerializer
+		if name == "" {
+			name = GORM_ENCRYPT_SERIALIZER
+		}
+		if err := RegisterEncryptSerializer(name, kr); err != nil {
+			return nil, err
+		}
 	}
 
+	var dialector gorm.Dialector
 	switch params.Driver {
 	case GORM_DRIVER_MYSQL:
-		return gorm.Open(NewGormMysql(params), config)
+		dialector = NewGormMysql(params)
 	case GORM_DRIVER_PG, GORM_DRIVER_PG_SHORTEN:
-		return gorm.Open(NewGormPostgres(params), config)
+		dialector = NewGormPostgres(params)
 	case GORM_DRIVER_SQLITE:
-		return gorm.Open(NewGormSQLite(params), config)
+		dialector = NewGormSQLite(params)
 	case GORM_DRIVER_SQLSERVER:
-		return gorm.Open(NewGormSQLServer(params), config)
+		dialector = NewGormSQLServer(params)
 	default:
 		return nil, fmt.Errorf("unsupported gorm driver: %s", params.Driver)
 	}
+	policy := DefaultRetryPolicy()
+	policy.MaxAttempts = params.ConnectRetries
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	db, err := RetryValue(context.Background(), policy, func(context.Context) (*gorm.DB, error) {
+		return gorm.Open(dialector, config)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := useGormPlugins(db, params, param); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// copyGormConfig returns a copy of config, so the connections built concurrently from shared config params don't
+// change each other's config, e.g. the logger. A nil config returns an empty one.
+func copyGormConfig(config *gorm.Config) *gorm.Config {
+	if config == nil {
+		return &gorm.Config{}
+	}
+	copied := *config
+	if config.Plugins != nil {
+		copied.Plugins = make(map[string]gorm.Plugin, len(config.Plugins))
+		for name, plugin := range config.Plugins {
+			copied.Plugins[name] = plugin
+		}
+	}
+	return &copied
+}
+
+// apply sets the plain options of params to config, options which are not set keep the values of config.
+func (p *GormConfigParams) apply(config *gorm.Config) {
+	if p.PrepareStmt {
+		config.PrepareStmt = true
+	}
+	if p.SkipDefaultTransaction {
+		config.SkipDefaultTransaction = true
+	}
+	if p.DryRun {
+		config.DryRun = true
+	}
+	if p.TablePrefix != "" || p.SingularTable {
+		config.NamingStrategy = schema.NamingStrategy{TablePrefix: p.TablePrefix, SingularTable: p.SingularTable}
+	}
+}
+
+// namingStrategy returns the naming strategy of base with the overrides of params. The overrides only apply to
+// schema.NamingStrategy, a custom schema.Namer is returned untouched.
+func (p GormConnectionParams) namingStrategy(base schema.Namer) schema.Namer {
+	var ns schema.NamingStrategy
+	switch b := base.(type) {
+	case nil:
+	case schema.NamingStrategy:
+		ns = b
+	case *schema.NamingStrategy:
+		if b != nil {
+			ns = *b
+		}
+	default:
+		return base
+	}
+	if p.TablePrefix != "" {
+		ns.TablePrefix = p.TablePrefix
+	}
+	if p.SingularTable != nil {
+		ns.SingularTable = *p.SingularTable
+	}
+	if p.NoLowerCase != nil {
+		ns.NoLowerCase = *p.NoLowerCase
+	}
+	return ns
 }
 
 func NewGormWithLogger(params GormConnectionParams, zl *zap.Logger, configParams ...*GormConfigParams) (*gorm.DB, error) {
@@ -77,14 +229,14 @@ func NewGormWithLogger(params GormConnectionParams, zl *zap.Logger, configParams
 	var logLevel string
 	if len(configParams) > 0 && configParams[0] != nil {
 		param := configParams[0]
-		if param.Config != nil {
-			config = param.Config
-		}
+		config = copyGormConfig(param.Config)
 		if param.LogLevel != "" {
 			logLevel = param.LogLevel
-		} else {
+		} else if logLevel = defaultGormLogLevel(""); logLevel == "" {
 			logLevel = LOG_LEVEL_ERROR
 		}
+	} else {
+		logLevel = defaultGormLogLevel("")
 	}
 	var gormLogger logger.Interface
 	if zl != nil {
@@ -93,7 +245,15 @@ func NewGormWithLogger(params GormConnectionParams, zl *zap.Logger, configParams
 		return nil, ERR_LOGGER_NOT_INIT
 	}
 	config.Logger = gormLogger
-	return NewGorm(params, &GormConfigParams{Config: config, LogLevel: logLevel})
+	param := &GormConfigParams{}
+	if len(configParams) > 0 && configParams[0] != nil {
+		// keep the other options of config params
+		copied := *configParams[0]
+		param = &copied
+	}
+	param.Config = config
+	param.LogLevel = logLevel
+	return NewGorm(params, param)
 }
 
 func DefaultGorm() (*gorm.DB, error) {
@@ -116,8 +276,36 @@ func NewGormSQLServer(params GormConnectionParams) gorm.Dialector {
 }
 
 func NewGormSQLite(params GormConnectionParams) gorm.Dialector {
-	dsn := fmt.Sprintf("%s.db", params.Database)
-	return mysql.Open(dsn)
+	return sqlite.Open(sqliteDSN(params))
+}
+
+func sqliteDSN(params GormConnectionParams) string {
+	options := params.SQLite
+	query := url.Values{}
+	var dsn string
+	switch {
+	case options.Memory:
+		dsn = "file:" + params.Database
+		query.Set("mode", "memory")
+		query.Set("cache", "shared")
+	case options.Path != "":
+		dsn = "file:" + options.Path
+	default:
+		dsn = fmt.Sprintf("file:%s.db", params.Database)
+	}
+	if options.WAL && !options.Memory {
+		query.Set("_journal_mode", "WAL")
+	}
+	if options.ForeignKeys {
+		query.Set("_foreign_keys", "1")
+	}
+	if options.BusyTimeout > 0 {
+		query.Set("_busy_timeout", strconv.FormatInt(options.BusyTimeout.Milliseconds(), 10))
+	}
+	if len(query) == 0 {
+		return dsn
+	}
+	return dsn + "?" + query.Encode()
 }
 
 type ZapGormLogger struct {
@@ -174,19 +362,19 @@ func (z *ZapGormLogger) LogMode(level logger.LogLevel) logger.Interface {
 
 func (z *ZapGormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
 	if z.logLevel >= logger.Info {
-		z.logger.Sugar().Infof(msg, data...)
+		LoggerWithScope(ctx, z.logger).Sugar().Infof(msg, data...)
 	}
 }
 
 func (z *ZapGormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
 	if z.logLevel >= logger.Warn {
-		z.logger.Sugar().Warnf(msg, data...)
+		LoggerWithScope(ctx, z.logger).Sugar().Warnf(msg, data...)
 	}
 }
 
 func (z *ZapGormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
 	if z.logLevel >= logger.Error {
-		z.logger.Sugar().Errorf(msg, data...)
+		LoggerWithScope(ctx, z.logger).Sugar().Errorf(msg, data...)
 	}
 }
 
@@ -195,28 +383,29 @@ func (l *ZapGormLogger) Trace(ctx context.Context, begin time.Time, fc func() (s
 		return
 	}
 	elapsed := time.Since(begin)
+	zl := LoggerWithScope(ctx, l.logger)
 	switch {
 	case err != nil && l.logLevel >= logger.Error && (!errors.Is(err, logger.ErrRecordNotFound) || !l.IgnoreRecordNotFoundError):
 		sql, rows := fc()
 		if rows == -1 {
-			l.logger.Sugar().Errorf(l.TraceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			zl.Sugar().Errorf(l.TraceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, "-", sql)
 		} else {
-			l.logger.Sugar().Errorf(l.TraceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			zl.Sugar().Errorf(l.TraceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, rows, sql)
 		}
 	case elapsed > l.SlowThreshold && l.SlowThreshold != 0 && l.logLevel >= logger.Warn:
 		sql, rows := fc()
 		slowLog := fmt.Sprintf("SLOW SQL >= %v", l.SlowThreshold)
 		if rows == -1 {
-			l.logger.Sugar().Warn(l.TraceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			zl.Sugar().Warn(l.TraceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, "-", sql)
 		} else {
-			l.logger.Sugar().Warn(l.TraceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			zl.Sugar().Warn(l.TraceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, rows, sql)
 		}
 	case l.logLevel == logger.Info:
 		sql, rows := fc()
 		if rows == -1 {
-			l.logger.Sugar().Infof(l.TraceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			zl.Sugar().Infof(l.TraceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, "-", sql)
 		} else {
-			l.logger.Sugar().Infof(l.TraceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			zl.Sugar().Infof(l.TraceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, rows, sql)
 		}
 	}
 }
@@ -0,0 +1,203 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ERR_GORM_HARD_DELETE     = errors.New("hard delete of model without soft delete field is not allowed, use Unscoped to force it")
+	ERR_GORM_OPTIMISTIC_LOCK = errors.New("optimistic lock failed, the record is modified or deleted by others")
+)
+
+// GORM_VERSION_COLUMN is the column of optimistic locking, the field must be an integer.
+var GORM_VERSION_COLUMN = "version"
+
+// SoftDeletePlugin enforces the soft delete convention: models must have a soft delete field (e.g. gorm.DeletedAt)
+// to be deleted, hard deletes are only allowed with Unscoped.
+type SoftDeletePlugin struct{}
+
+func (SoftDeletePlugin) Name() string {
+	return "giu:soft_delete"
+}
+
+func (p SoftDeletePlugin) Initialize(db *gorm.DB) error {
+	return db.Callback().Delete().Before("gorm:delete").Register(p.Name(), func(tx *gorm.DB) {
+		stmt := tx.Statement
+		if stmt.Schema == nil || stmt.Unscoped {
+			return
+		}
+		if len(stmt.Schema.DeleteClauses) == 0 {
+			_ = tx.AddError(ERR_GORM_HARD_DELETE)
+		}
+	})
+}
+
+// OptimisticLockPlugin checks and increases the version column when updating a model with known version,
+// ERR_GORM_OPTIMISTIC_LOCK is returned if no row matches the version.
+type OptimisticLockPlugin struct{}
+
+const optimisticLockKey = "giu:optimistic_lock"
+
+func (OptimisticLockPlugin) Name() string {
+	return "giu:optimistic_lock"
+}
+
+func (p OptimisticLockPlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Update().Before("gorm:update").Register(p.Name()+"_before", p.before); err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:update").Register(p.Name()+"_after", p.after)
+}
+
+func (OptimisticLockPlugin) before(tx *gorm.DB) {
+	stmt := tx.Statement
+	if stmt.Schema == nil || stmt.ReflectValue.Kind() != reflect.Struct {
+		return
+	}
+	field := stmt.Schema.LookUpField(GORM_VERSION_COLUMN)
+	if field == nil {
+		return
+	}
+	v, zero := field.ValueOf(stmt.Context, stmt.ReflectValue)
+	if zero {
+		// version is unknown, e.g. updating by conditions
+		return
+	}
+	rv := reflect.ValueOf(v)
+	var next interface{}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		next = rv.Int() + 1
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		next = rv.Uint() + 1
+	default:
+		return
+	}
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: v},
+	}})
+	stmt.SetColumn(field.DBName, next)
+	tx.InstanceSet(optimisticLockKey, true)
+}
+
+func (OptimisticLockPlugin) after(tx *gorm.DB) {
+	if _, ok := tx.InstanceGet(optimisticLockKey); !ok {
+		return
+	}
+	if tx.Error == nil && tx.RowsAffected == 0 && !tx.DryRun {
+		_ = tx.AddError(ERR_GORM_OPTIMISTIC_LOCK)
+	}
+}
+
+// useGormPlugins registers the plugins enabled in connection and config params.
+func useGormPlugins(db *gorm.DB, params GormConnectionParams, param *GormConfigParams) error {
+	timeout := params.QueryTimeout
+	if timeout == 0 && param != nil {
+		timeout = param.QueryTimeout
+	}
+	if timeout > 0 {
+		if err := db.Use(QueryTimeoutPlugin{Timeout: timeout}); err != nil {
+			return err
+		}
+	}
+	if param == nil {
+		return nil
+	}
+	if param.SoftDelete {
+		if err := db.Use(SoftDeletePlugin{}); err != nil {
+			return err
+		}
+	}
+	if param.OptimisticLock {
+		if err := db.Use(OptimisticLockPlugin{}); err != nil {
+			return err
+		}
+	}
+	if param.Otel {
+		if err := db.Use(&OtelPlugin{}); err != nil {
+			return err
+		}
+	}
+	if param.Audit {
+		if err := db.Use(&AuditPlugin{Table: param.AuditTable, Tables: param.AuditTables}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryTimeoutPlugin applies a default timeout to statements whose context has no deadline,
+// statements cancelled by the timeout are logged with the gorm logger.
+// Rows() is not covered, because the rows are read after the statement returns.
+// The timeout covers preloads, associations and hooks of the statement.
+type QueryTimeoutPlugin struct {
+	Timeout time.Duration
+}
+
+const queryTimeoutCancelKey = "giu:query_timeout_cancel"
+
+func (QueryTimeoutPlugin) Name() string {
+	return "giu:query_timeout"
+}
+
+func (p QueryTimeoutPlugin) Initialize(db *gorm.DB) error {
+	if p.Timeout <= 0 {
+		return nil
+	}
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register(p.Name()+"_before_query", p.before); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:after_query").Register(p.Name()+"_after_query", p.after); err != nil {
+		return err
+	}
+	if err := cb.Create().Before("gorm:create").Register(p.Name()+"_before_create", p.before); err != nil {
+		return err
+	}
+	if err := cb.Create().After("gorm:commit_or_rollback_transaction").Register(p.Name()+"_after_create", p.after); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register(p.Name()+"_before_update", p.before); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:commit_or_rollback_transaction").Register(p.Name()+"_after_update", p.after); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register(p.Name()+"_before_delete", p.before); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:commit_or_rollback_transaction").Register(p.Name()+"_after_delete", p.after); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register(p.Name()+"_before_raw", p.before); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register(p.Name()+"_after_raw", p.after)
+}
+
+func (p QueryTimeoutPlugin) before(tx *gorm.DB) {
+	ctx := tx.Statement.Context
+	if _, ok := ctx.Deadline(); ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	tx.Statement.Context = ctx
+	tx.InstanceSet(queryTimeoutCancelKey, cancel)
+}
+
+func (p QueryTimeoutPlugin) after(tx *gorm.DB) {
+	v, ok := tx.InstanceGet(queryTimeoutCancelKey)
+	if !ok {
+		return
+	}
+	if errors.Is(tx.Statement.Context.Err(), context.DeadlineExceeded) {
+		tx.Logger.Warn(tx.Statement.Context, "query cancelled by timeout %s: %s", p.Timeout, tx.Statement.SQL.String())
+	}
+	v.(context.CancelFunc)()
+}
@@ -0,0 +1,267 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type GrpcParams struct {
+	// Addr is the listen address of grpc, empty means serving grpc on the port of the gin server.
+	// On the same port, plaintext connections are upgraded by h2c, and tls connections need "h2" in NextProtos.
+	Addr string
+	// ShutdownTimeout is the max time to wait for in-flight calls when shutting down, default is 10s.
+	ShutdownTimeout time.Duration
+}
+
+var _defaultGrpcParams = GrpcParams{
+	ShutdownTimeout: 10 * time.Second,
+}
+
+// GrpcAuthFunc authenticates the call of fullMethod, the returned context is used by the handler,
+// e.g. with the user in request scope. Return a status error to choose the code, default is Unauthenticated.
+type GrpcAuthFunc func(ctx context.Context, fullMethod string) (context.Context, error)
+
+// GrpcGateway serves a grpc server and its grpc-gateway REST mux. The REST mux handles the requests which
+// match no gin route, so the middlewares used by the gin engine apply to it.
+type GrpcGateway struct {
+	// Server is the grpc server, register services on it before Run.
+	Server *grpc.Server
+	// Mux is the grpc-gateway mux, register handlers on it before Run.
+	Mux *runtime.ServeMux
+
+	params GrpcParams
+	http   *GinServer
+	logger *zap.Logger
+}
+
+// NewGrpcGateway creates the grpc server with logging, tracing and auth interceptors, auth can be nil.
+// The REST mux is mounted on e, which must be the engine served by server.
+func NewGrpcGateway(params GrpcParams, e *gin.Engine, server *GinServer, auth GrpcAuthFunc, zl *zap.Logger, opts ...grpc.ServerOption) *GrpcGateway {
+	if params.ShutdownTimeout <= 0 {
+		params.ShutdownTimeout = _defaultGrpcParams.ShutdownTimeout
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "grpc"))
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcUnaryTracing(), grpcUnaryLogging(zl), grpcUnaryAuth(auth)),
+		grpc.ChainStreamInterceptor(grpcStreamTracing(), grpcStreamLogging(zl), grpcStreamAuth(auth)),
+	}, opts...)
+	g := &GrpcGateway{
+		Server: grpc.NewServer(opts...),
+		Mux:    runtime.NewServeMux(),
+		params: params,
+		http:   server,
+		logger: zl,
+	}
+	e.NoRoute(gin.WrapH(g.Mux))
+	if params.Addr == "" {
+		rest := server.Server().Handler
+		server.Server().Handler = h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+				g.Server.ServeHTTP(w, r)
+				return
+			}
+			rest.ServeHTTP(w, r)
+		}), &http2.Server{})
+	}
+	return g
+}
+
+// NewGrpcGatewayFromConfig creates the grpc gateway from viper config with key "grpc".
+func NewGrpcGatewayFromConfig(config *viper.Viper, e *gin.Engine, server *GinServer, auth GrpcAuthFunc, zl *zap.Logger, opts ...grpc.ServerOption) (*GrpcGateway, error) {
+	var params GrpcParams
+	if err := config.UnmarshalKey("grpc", &params); err != nil {
+		return nil, err
+	}
+	return NewGrpcGateway(params, e, server, auth, zl, opts...), nil
+}
+
+// Run serves grpc and REST, it blocks until the gin server is shut down.
+func (g *GrpcGateway) Run() error {
+	if g.params.Addr != "" {
+		l, err := net.Listen("tcp", g.params.Addr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			g.logger.Info("[grpc] listening", zap.String("addr", l.Addr().String()))
+			if err := g.Server.Serve(l); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+				g.logger.Error("[grpc] serve failed", zap.Error(err))
+			}
+		}()
+	}
+	return g.http.Run()
+}
+
+// Shutdown gracefully stops grpc and the gin server, calls still running after ShutdownTimeout are cancelled.
+func (g *GrpcGateway) Shutdown() error {
+	done := make(chan struct{})
+	go func() {
+		g.Server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(g.params.ShutdownTimeout):
+		g.Server.Stop()
+	}
+	return g.http.Shutdown()
+}
+
+// grpcMetadataCarrier adapts grpc metadata to otel propagation.
+type grpcMetadataCarrier metadata.MD
+
+func (c grpcMetadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c grpcMetadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c grpcMetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func grpcStartSpan(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, grpcMetadataCarrier(md))
+	return otel.Tracer(OTEL_INSTRUMENTATION).Start(ctx, strings.TrimPrefix(fullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("rpc.system", "grpc"), attribute.String("rpc.method", fullMethod)))
+}
+
+func grpcEndSpan(span trace.Span, err error) {
+	s, _ := status.FromError(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(s.Code())))
+	if err != nil {
+		span.SetStatus(codes.Error, s.Message())
+	}
+	span.End()
+}
+
+func grpcUnaryTracing() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := grpcStartSpan(ctx, info.FullMethod)
+		resp, err := handler(ctx, req)
+		grpcEndSpan(span, err)
+		return resp, err
+	}
+}
+
+func grpcStreamTracing() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := grpcStartSpan(ss.Context(), info.FullMethod)
+		err := handler(srv, &grpcContextStream{ServerStream: ss, ctx: ctx})
+		grpcEndSpan(span, err)
+		return err
+	}
+}
+
+func grpcLog(ctx context.Context, zl *zap.Logger, fullMethod string, start time.Time, err error) {
+	s, _ := status.FromError(err)
+	fields := []zap.Field{
+		zap.String("method", fullMethod),
+		zap.String("code", s.Code().String()),
+		zap.Duration("latency", time.Since(start)),
+	}
+	zl = LoggerWithScope(ctx, zl)
+	if err != nil {
+		zl.Warn("[grpc] call failed", append(fields, zap.Error(err))...)
+		return
+	}
+	zl.Info("[grpc] call", fields...)
+}
+
+func grpcUnaryLogging(zl *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		grpcLog(ctx, zl, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func grpcStreamLogging(zl *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		grpcLog(ss.Context(), zl, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func grpcAuthenticate(ctx context.Context, auth GrpcAuthFunc, fullMethod string) (context.Context, error) {
+	ctx, err := auth(ctx, fullMethod)
+	if err == nil {
+		return ctx, nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return nil, err
+	}
+	return nil, status.Error(grpcCodes.Unauthenticated, err.Error())
+}
+
+func grpcUnaryAuth(auth GrpcAuthFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if auth == nil {
+			return handler(ctx, req)
+		}
+		ctx, err := grpcAuthenticate(ctx, auth, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func grpcStreamAuth(auth GrpcAuthFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if auth == nil {
+			return handler(srv, ss)
+		}
+		ctx, err := grpcAuthenticate(ss.Context(), auth, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &grpcContextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// grpcContextStream overrides the context of a server stream.
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcContextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,137 @@
+package giu
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// HealthChecker reports the health of named items, a nil error means healthy.
+type HealthChecker interface {
+	Health(ctx context.Context) map[string]error
+}
+
+// HealthCheckFunc is a user-supplied check.
+type HealthCheckFunc func(ctx context.Context) error
+
+// HealthChecks is a HealthChecker of user-supplied checks by name, the checks run concurrently.
+type HealthChecks map[string]HealthCheckFunc
+
+func (h HealthChecks) Health(ctx context.Context) map[string]error {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]error, len(h))
+	for name, check := range h {
+		name, check := name, check
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+			lock.Lock()
+			result[name] = err
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+type providerHealthChecker[T any] struct {
+	provider Provider[T]
+	ping     func(ctx context.Context, v T) error
+}
+
+// NewProviderHealthChecker returns a HealthChecker which pings every item of p within PROVIDER_PING_TIMEOUT, the
+// lazy items not built yet are skipped.
+func NewProviderHealthChecker[T any](p Provider[T], ping func(ctx context.Context, v T) error) HealthChecker {
+	return &providerHealthChecker[T]{provider: p, ping: ping}
+}
+
+func (h *providerHealthChecker[T]) Health(ctx context.Context) map[string]error {
+	checks := make(HealthChecks)
+	h.provider.Range(func(name string, v T) bool {
+		checks[name] = func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, PROVIDER_PING_TIMEOUT)
+			defer cancel()
+			return h.ping(ctx, v)
+		}
+		return true
+	})
+	return checks.Health(ctx)
+}
+
+// Health pings every connection.
+func (gp *gormProvider) Health(ctx context.Context) map[string]error {
+	return NewProviderHealthChecker[*gorm.DB](gp, pingGorm).Health(ctx)
+}
+
+// Health sends PING to every client.
+func (rp *redisProvider) Health(ctx context.Context) map[string]error {
+	return NewProviderHealthChecker[redis.UniversalClient](rp, func(ctx context.Context, rdb redis.UniversalClient) error {
+		return rdb.Ping(ctx).Err()
+	}).Health(ctx)
+}
+
+// CombineHealth returns a HealthChecker of checkers, the item names are prefixed with the checker names, e.g.
+// "gorm.orders".
+func CombineHealth(checkers map[string]HealthChecker) HealthChecker {
+	return combinedHealth(checkers)
+}
+
+type combinedHealth map[string]HealthChecker
+
+func (c combinedHealth) Health(ctx context.Context) map[string]error {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]error)
+	for prefix, checker := range c {
+		prefix, checker := prefix, checker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health := checker.Health(ctx)
+			lock.Lock()
+			defer lock.Unlock()
+			for name, err := range health {
+				result[prefix+"."+name] = err
+			}
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+// Health checks the gorm connections and redis clients of the app.
+func (app *App) Health(ctx context.Context) map[string]error {
+	checkers := make(map[string]HealthChecker)
+	if app.Gorm != nil {
+		checkers["gorm"] = app.Gorm
+	}
+	if rp, ok := app.Redis.(HealthChecker); ok {
+		checkers["redis"] = rp
+	}
+	return CombineHealth(checkers).Health(ctx)
+}
+
+// NewGinHealthHandler returns a readiness probe handler, it responds 200 if all items are healthy, otherwise 503,
+// with the status of each item, e.g. {"status":"ok","checks":{"gorm.orders":"ok"}}.
+func NewGinHealthHandler(checker HealthChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		health := checker.Health(c.Request.Context())
+		code, status := http.StatusOK, "ok"
+		checks := make(map[string]string, len(health))
+		for name, err := range health {
+			if err != nil {
+				code, status = http.StatusServiceUnavailable, "unhealthy"
+				checks[name] = err.Error()
+				continue
+			}
+			checks[name] = "ok"
+		}
+		c.JSON(code, gin.H{"status": status, "checks": checks})
+	}
+}
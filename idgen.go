@@ -0,0 +1,178 @@
+package giu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+// GIU_ENV_POD_IP is the env of pod ip, it's used to derive the snowflake node id.
+const GIU_ENV_POD_IP = "POD_IP"
+
+type IDGeneratorParams struct {
+	// NodeID is the snowflake node id in [1, 1023], 0 means deriving it from pod ip, host ip or hostname.
+	NodeID int64
+	// Epoch is the custom epoch of snowflake ids, default is 2020-01-01 UTC.
+	Epoch time.Time
+}
+
+var _defaultSnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake generates 63 bits ids of 41 bits milliseconds, 10 bits node id and 12 bits sequence.
+type Snowflake struct {
+	lock  sync.Mutex
+	epoch int64
+	node  int64
+	last  int64
+	seq   int64
+}
+
+func NewSnowflake(params IDGeneratorParams) (*Snowflake, error) {
+	if params.NodeID < 0 || params.NodeID > snowflakeMaxNode {
+		return nil, fmt.Errorf("snowflake node id must be in [0, %d]", snowflakeMaxNode)
+	}
+	node := params.NodeID
+	if node == 0 {
+		node = deriveNodeID()
+	}
+	epoch := params.Epoch
+	if epoch.IsZero() {
+		epoch = _defaultSnowflakeEpoch
+	}
+	return &Snowflake{epoch: epoch.UnixMilli(), node: node}, nil
+}
+
+func DefaultSnowflake() *Snowflake {
+	s, _ := NewSnowflake(IDGeneratorParams{})
+	return s
+}
+
+// deriveNodeID uses the low 10 bits of pod ip or the first non-loopback ipv4, otherwise the hash of hostname.
+func deriveNodeID() int64 {
+	ip := net.ParseIP(os.Getenv(GIU_ENV_POD_IP)).To4()
+	if ip == nil {
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+					ip = ipNet.IP.To4()
+					break
+				}
+			}
+		}
+	}
+	if ip != nil {
+		return int64(ip[2])<<8&snowflakeMaxNode | int64(ip[3])
+	}
+	host, _ := os.Hostname()
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return int64(h.Sum32() & snowflakeMaxNode)
+}
+
+func (s *Snowflake) NodeID() int64 {
+	return s.node
+}
+
+// Next returns a new id, it waits for the next millisecond if the sequence is exhausted or the clock moves backwards.
+func (s *Snowflake) Next() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now().UnixMilli()
+	if now < s.last {
+		time.Sleep(time.Duration(s.last-now) * time.Millisecond)
+		now = time.Now().UnixMilli()
+	}
+	if now == s.last {
+		s.seq = (s.seq + 1) & snowflakeMaxSeq
+		if s.seq == 0 {
+			for now <= s.last {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Now().UnixMilli()
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	s.last = now
+	return (now-s.epoch)<<(snowflakeNodeBits+snowflakeSeqBits) | s.node<<snowflakeSeqBits | s.seq
+}
+
+// Parse returns the time, node id and sequence of id generated by s.
+func (s *Snowflake) Parse(id int64) (time.Time, int64, int64) {
+	ms := id>>(snowflakeNodeBits+snowflakeSeqBits) + s.epoch
+	return time.UnixMilli(ms), id >> snowflakeSeqBits & snowflakeMaxNode, id & snowflakeMaxSeq
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID of current time, it's 26 characters and sortable by time.
+func NewULID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	_, _ = rand.Read(b[6:])
+	// 128 bits are encoded as 26 characters of 5 bits, with 2 leading zero bits
+	out := make([]byte, 26)
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// NewUUIDv7 returns a time ordered UUID version 7.
+func NewUUIDv7() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+var ERR_ID_GENERATOR_NOT_FOUND = errors.New("id generator not found in context")
+
+type idGeneratorKey struct{}
+
+// WithIDGenerator returns a copy of ctx with the snowflake generator.
+func WithIDGenerator(ctx context.Context, s *Snowflake) context.Context {
+	return context.WithValue(ctx, idGeneratorKey{}, s)
+}
+
+// NextID generates a snowflake id with the generator in ctx.
+func NextID(ctx context.Context) (int64, error) {
+	s, ok := ctx.Value(idGeneratorKey{}).(*Snowflake)
+	if !ok || s == nil {
+		return 0, ERR_ID_GENERATOR_NOT_FOUND
+	}
+	return s.Next(), nil
+}
+
+// NewGinMiddlewareIDGenerator returns a gin middleware which puts the generator into request context,
+// so handlers, repositories and clients of the request generate ids with NextID consistently.
+func NewGinMiddlewareIDGenerator(s *Snowflake) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(WithIDGenerator(c.Request.Context(), s))
+		c.Next()
+	}
+}
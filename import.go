@@ -0,0 +1,265 @@
+package giu
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type ImportParams struct {
+	// BatchSize is the number of rows of each insert, default is 500.
+	BatchSize int
+	// MaxRows is the max data rows of a file, 0 means unlimited.
+	MaxRows int
+	// MaxErrors is the max row errors kept in the summary, default is 100. The import goes on after it.
+	MaxErrors int
+	// Sheet is the excel sheet to import, default is the first sheet.
+	Sheet string
+}
+
+var _defaultImportParams = ImportParams{
+	BatchSize: 500,
+	MaxErrors: 100,
+}
+
+const (
+	IMPORT_FORMAT_CSV  = "csv"
+	IMPORT_FORMAT_XLSX = "xlsx"
+)
+
+var ERR_IMPORT_TOO_MANY_ROWS = errors.New("import file has too many rows")
+
+// ImportRowError is the error of a data row, Row is the line number in the file, the header is row 1.
+type ImportRowError struct {
+	Row     int          `json:"row"`
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// ImportSummary is the result of an import.
+type ImportSummary struct {
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors,omitempty"`
+	Duration time.Duration    `json:"duration"`
+}
+
+type importer[T any] struct {
+	db      *gorm.DB
+	params  ImportParams
+	summary ImportSummary
+	batch   []T
+	lines   []int
+}
+
+func (im *importer[T]) fail(line int, err error) {
+	im.summary.Failed++
+	if len(im.summary.Errors) >= im.params.MaxErrors {
+		return
+	}
+	resp := NewErrorResponse(nil, 0, err)
+	im.summary.Errors = append(im.summary.Errors, ImportRowError{Row: line, Message: resp.Message, Errors: resp.Errors})
+}
+
+// row maps the record to T with "form" tags like Bind, so request structs can be reused, and validates it.
+func (im *importer[T]) row(ctx context.Context, line int, header, record []string) error {
+	im.summary.Total++
+	if im.params.MaxRows > 0 && im.summary.Total > im.params.MaxRows {
+		return ERR_IMPORT_TOO_MANY_ROWS
+	}
+	values := make(map[string][]string, len(header))
+	for i, h := range header {
+		if i < len(record) && record[i] != "" {
+			values[h] = []string{record[i]}
+		}
+	}
+	var v T
+	if err := binding.MapFormWithTag(&v, values, "form"); err != nil {
+		im.fail(line, err)
+		return nil
+	}
+	if binding.Validator != nil {
+		if err := binding.Validator.ValidateStruct(&v); err != nil {
+			im.fail(line, err)
+			return nil
+		}
+	}
+	im.batch = append(im.batch, v)
+	im.lines = append(im.lines, line)
+	if len(im.batch) >= im.params.BatchSize {
+		return im.flush(ctx)
+	}
+	return nil
+}
+
+// flush inserts the batch, if it fails, the rows are inserted one by one to report the failed rows.
+func (im *importer[T]) flush(ctx context.Context) error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	defer func() {
+		im.batch, im.lines = im.batch[:0], im.lines[:0]
+	}()
+	db := im.db.WithContext(ctx)
+	if err := db.Create(&im.batch).Error; err == nil {
+		im.summary.Imported += len(im.batch)
+		return nil
+	}
+	for i := range im.batch {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := db.Create(&im.batch[i]).Error; err != nil {
+			im.fail(im.lines[i], err)
+			continue
+		}
+		im.summary.Imported++
+	}
+	return nil
+}
+
+// Import reads the csv or excel file in r, the first row is the header of field names. Rows are mapped to T
+// with "form" tags and validated with "binding" tags, then the valid rows are inserted in batches.
+// Invalid rows are reported in the summary, the returned error is only for unreadable files or canceled imports.
+func Import[T any](ctx context.Context, db *gorm.DB, r io.Reader, format string, params ImportParams) (ImportSummary, error) {
+	if params.BatchSize <= 0 {
+		params.BatchSize = _defaultImportParams.BatchSize
+	}
+	if params.MaxErrors <= 0 {
+		params.MaxErrors = _defaultImportParams.MaxErrors
+	}
+	start := time.Now()
+	im := &importer[T]{db: db, params: params}
+	var err error
+	switch format {
+	case IMPORT_FORMAT_CSV:
+		err = im.readCsv(ctx, r)
+	case IMPORT_FORMAT_XLSX:
+		err = im.readExcel(ctx, r)
+	default:
+		err = fmt.Errorf("unsupported import format: %s", format)
+	}
+	if err == nil {
+		err = im.flush(ctx)
+	}
+	im.summary.Duration = time.Since(start)
+	return im.summary, err
+}
+
+func (im *importer[T]) readCsv(ctx context.Context, r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	header = trimHeader(header)
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := im.row(ctx, line, header, record); err != nil {
+			return err
+		}
+	}
+}
+
+func (im *importer[T]) readExcel(ctx context.Context, r io.Reader) error {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sheet := im.params.Sheet
+	if sheet == "" {
+		sheet = f.GetSheetName(0)
+	}
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var header []string
+	for line := 1; rows.Next(); line++ {
+		record, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if line == 1 {
+			header = trimHeader(record)
+			continue
+		}
+		if err := im.row(ctx, line, header, record); err != nil {
+			return err
+		}
+	}
+	return rows.Error()
+}
+
+func trimHeader(header []string) []string {
+	trimmed := make([]string, len(header))
+	for i, h := range header {
+		trimmed[i] = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+	}
+	return trimmed
+}
+
+// NewImportHandler returns a gin handler which imports the multipart file of field "file" into the table of T,
+// the format is detected by the file extension. The summary is written as json and logged.
+func NewImportHandler[T any](db *gorm.DB, params ImportParams, zl *zap.Logger) gin.HandlerFunc {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "import"))
+	return func(c *gin.Context) {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			AbortWithErrorResponse(c, http.StatusBadRequest, err)
+			return
+		}
+		format := strings.TrimPrefix(strings.ToLower(filepath.Ext(fh.Filename)), ".")
+		if format != IMPORT_FORMAT_CSV && format != IMPORT_FORMAT_XLSX {
+			AbortWithErrorResponse(c, http.StatusBadRequest, fmt.Errorf("unsupported import format: %s", format))
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			AbortWithErrorResponse(c, http.StatusBadRequest, err)
+			return
+		}
+		defer f.Close()
+		ctx := c.Request.Context()
+		summary, err := Import[T](ctx, db, f, format, params)
+		logger := LoggerWithScope(ctx, zl).With(
+			zap.String("file", fh.Filename),
+			zap.Int("total", summary.Total),
+			zap.Int("imported", summary.Imported),
+			zap.Int("failed", summary.Failed),
+			zap.Duration("duration", summary.Duration))
+		if err != nil {
+			logger.Warn("[import] aborted", zap.Error(err))
+			AbortWithErrorResponse(c, http.StatusBadRequest, err)
+			return
+		}
+		logger.Info("[import] done")
+		c.JSON(http.StatusOK, summary)
+	}
+}
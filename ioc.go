@@ -1,21 +1,154 @@
 package giu
 
-func Manually() {
-	configParam := ConfigParams{
-		ConfigName: "config",
-		ConfigType: "yaml",
-		ConfigPath: []string{"."},
-		AutoEnv:    true,
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// App is the container of the providers built from a GiuConfig.
+type App struct {
+	// Viper is the source config, read the extend params with Viper.UnmarshalKey("extend", &params).
+	Viper   *viper.Viper
+	Config  *GiuConfig[any]
+	Logger  ZapProvider
+	Gorm    GormProvider
+	Redis   Provider[redis.UniversalClient]
+	Resty   RestyProvider
+	Cron    *cron.Cron
+	Startup *StartupTimer
+}
+
+// BootstrapHook runs after the providers are built, e.g. to seed data or warm caches, it's timed as a startup phase.
+type BootstrapHook struct {
+	Name string
+	Run  func(app *App) error
+}
+
+// Bootstrap reads the GiuConfig of config, sets the global mode and builds the providers in dependency order:
+// logger, gorm, redis, resty and cron, then runs the hooks in order. The default logger is used by the others,
+// if no logger is configured, DefaultZapLogger is added as "default". The cron is not started.
+// If a provider or a hook fails, or the startup budget of config is exceeded, the built providers are shut down
+// and the error is returned.
+func Bootstrap(config *viper.Viper, hooks ...BootstrapHook) (*App, error) {
+	app := &App{Viper: config, Startup: NewStartupTimer(0)}
+	err := app.build()
+	for _, hook := range hooks {
+		if err != nil {
+			break
+		}
+		hook := hook
+		err = app.Startup.Phase("hook:"+hook.Name, func() error {
+			return hook.Run(app)
+		})
+	}
+	if app.Logger != nil {
+		app.Startup.Report(app.Logger.Default())
 	}
+	if err == nil {
+		err = app.Startup.Check()
+	}
+	if err != nil {
+		return nil, errors.Join(err, app.Shutdown())
+	}
+	return app, nil
+}
 
-	v, err := NewLocalConfig(configParam)
+func (app *App) build() error {
+	var c GiuConfig[any]
+	err := app.Startup.Phase("config", func() error {
+		if err := app.Viper.Unmarshal(&c); err != nil {
+			return err
+		}
+		return ApplyModeConfig(&c)
+	})
 	if err != nil {
-		panic(err)
+		return err
+	}
+	app.Config = &c
+	if c.Startup != nil {
+		app.Startup.setBudget(c.Startup.Budget)
 	}
 
-	var giuConfig GiuConfig[any]
-	if err := v.Unmarshal(&giuConfig); err != nil {
-		panic(err)
+	err = app.Startup.Phase("logger", func() error {
+		giu, err := NewGiuProviderFromParamsError[*zap.Logger, *LoggerParams](NewZapLoggerWithCheck, c.Logger)
+		if err != nil {
+			return err
+		}
+		app.Logger = &zapProvider{GiuProvider: giu}
+		if app.Logger.Default() == nil {
+			app.Logger.Add("default", DefaultZapLogger())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	logger := app.Logger.Default()
 
+	err = app.Startup.Phase("gorm", func() error {
+		gp, err := NewGormProviderWithLoggerFromParams(c.GormConfig, c.GormConnection, logger)
+		if err != nil {
+			return err
+		}
+		app.Gorm = gp
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_ = app.Startup.Phase("redis", func() error {
+		app.Redis = NewRedisProviderWithReplicasFromParams(c.Redis, c.RedisReplicas)
+		return nil
+	})
+	_ = app.Startup.Phase("resty", func() error {
+		app.Resty = NewRestyProviderFromParams(c.Resty, logger)
+		return nil
+	})
+	_ = app.Startup.Phase("cron", func() error {
+		var params CronParams
+		if c.Cron != nil {
+			params = *c.Cron
+		}
+		app.Cron = NewCron(params)
+		return nil
+	})
+	return nil
+}
+
+// Shutdown stops the cron and waits for the running jobs, then shuts down the providers in reverse order of
+// Bootstrap. All providers are shut down even if some fail, the errors are joined.
+func (app *App) Shutdown() error {
+	return app.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is like Shutdown, but it stops waiting when ctx is done.
+func (app *App) ShutdownContext(ctx context.Context) error {
+	var errs []error
+	if app.Cron != nil {
+		select {
+		case <-app.Cron.Stop().Done():
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("cron: %w", ctx.Err()))
+		}
+	}
+	if app.Resty != nil {
+		errs = append(errs, app.Resty.ShutdownContext(ctx))
+	}
+	if app.Redis != nil {
+		errs = append(errs, app.Redis.ShutdownContext(ctx))
+	}
+	if app.Gorm != nil {
+		errs = append(errs, app.Gorm.ShutdownContext(ctx))
+	}
+	if app.Logger != nil {
+		errs = append(errs, app.Logger.ShutdownContext(ctx))
+	}
+	return errors.Join(errs...)
 }
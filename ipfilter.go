@@ -0,0 +1,168 @@
+package giu
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type IPFilterParams struct {
+	// Allow is the list of allowed CIDRs or IPs, empty means all are allowed.
+	Allow []string
+	// Deny is the list of denied CIDRs or IPs, it takes precedence over Allow.
+	Deny []string
+	// TrustedProxies is the list of proxy CIDRs or IPs whose X-Forwarded-For header is trusted.
+	TrustedProxies []string
+	// ForwardedDepth is the number of proxies in front of the service, the client ip is the
+	// ForwardedDepth-th address from the right of X-Forwarded-For. If it's 0, trusted proxies are
+	// skipped from the right instead.
+	ForwardedDepth int
+}
+
+// GIN_CLIENT_IP is the gin context key of the client ip resolved by ip filter middleware.
+var GIN_CLIENT_IP = "giu_client_ip"
+
+type ipMatcher []*net.IPNet
+
+func newIPMatcher(items []string) (ipMatcher, error) {
+	m := make(ipMatcher, 0, len(items))
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip or cidr: %s", item)
+		}
+		m = append(m, n)
+	}
+	return m, nil
+}
+
+func (m ipMatcher) contains(ip net.IP) bool {
+	for _, n := range m {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIPResolver resolves the real client ip behind proxies.
+type ClientIPResolver struct {
+	trusted ipMatcher
+	depth   int
+}
+
+func NewClientIPResolver(trustedProxies []string, forwardedDepth int) (*ClientIPResolver, error) {
+	trusted, err := newIPMatcher(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientIPResolver{trusted: trusted, depth: forwardedDepth}, nil
+}
+
+// Resolve returns the client ip of the request, X-Forwarded-For is only used when the peer is a trusted proxy.
+func (r *ClientIPResolver) Resolve(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr))
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !r.trusted.contains(remote) {
+		return remote
+	}
+	var hops []string
+	for _, v := range req.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-Ip"))); ip != nil {
+			return ip
+		}
+		return remote
+	}
+	if r.depth > 0 {
+		i := len(hops) - r.depth
+		if i < 0 {
+			i = 0
+		}
+		if ip := net.ParseIP(hops[i]); ip != nil {
+			return ip
+		}
+		return remote
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			return remote
+		}
+		if i == 0 || !r.trusted.contains(ip) {
+			return ip
+		}
+	}
+	return remote
+}
+
+// NewGinMiddlewareIPFilter returns a gin middleware which resolves the client ip and blocks it by allow/deny lists.
+// The resolved ip is set to gin context with key GIN_CLIENT_IP, blocked requests are logged with warn level.
+func NewGinMiddlewareIPFilter(params IPFilterParams, zl *zap.Logger) (gin.HandlerFunc, error) {
+	allow, err := newIPMatcher(params.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := newIPMatcher(params.Deny)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := NewClientIPResolver(params.TrustedProxies, params.ForwardedDepth)
+	if err != nil {
+		return nil, err
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "ip_filter"))
+	return func(c *gin.Context) {
+		ip := resolver.Resolve(c.Request)
+		var reason string
+		switch {
+		case ip == nil:
+			reason = "unknown ip"
+		case deny.contains(ip):
+			reason = "denied"
+		case len(allow) > 0 && !allow.contains(ip):
+			reason = "not allowed"
+		}
+		if reason != "" {
+			zl.Warn("[gin ip filter] request blocked",
+				zap.String("ip", ip.String()),
+				zap.String("reason", reason),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)))
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Set(GIN_CLIENT_IP, ip.String())
+		c.Next()
+	}, nil
+}
+
+// GinClientIP returns the client ip resolved by ip filter middleware, it falls back to gin's ClientIP.
+func GinClientIP(c *gin.Context) string {
+	if ip := c.GetString(GIN_CLIENT_IP); ip != "" {
+		return ip
+	}
+	return c.ClientIP()
+}
@@ -0,0 +1,239 @@
+package giu
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type LdapParams struct {
+	// URL is the directory server, e.g. "ldaps://ldap.example.com:636" or "ldap://ldap.example.com:389".
+	URL string
+	// StartTLS upgrades ldap:// connections to tls.
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// BindDN and BindPassword are the service account used to search users, empty means anonymous search.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the search base of users.
+	BaseDN string
+	// UserFilter is the search filter of users, %s is replaced by the escaped username, default is "(uid=%s)".
+	// Use "(sAMAccountName=%s)" for active directory.
+	UserFilter string
+	// Attributes are the user attributes returned, default is cn and mail.
+	Attributes []string
+	// Timeout is the dial and request timeout, default is 10s.
+	Timeout time.Duration
+}
+
+var _defaultLdapParams = LdapParams{
+	UserFilter: "(uid=%s)",
+	Attributes: []string{"cn", "mail"},
+	Timeout:    10 * time.Second,
+}
+
+var (
+	ERR_LDAP_INVALID_CREDENTIALS = errors.New("invalid ldap credentials")
+	ERR_LDAP_USER_NOT_UNIQUE     = errors.New("ldap user is not unique")
+)
+
+// LdapUser is the verified user entry.
+type LdapUser struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// LdapClient verifies credentials against a directory, every call uses a new connection.
+type LdapClient struct {
+	params LdapParams
+}
+
+func NewLdapClient(params LdapParams) *LdapClient {
+	if params.UserFilter == "" {
+		params.UserFilter = _defaultLdapParams.UserFilter
+	}
+	if len(params.Attributes) == 0 {
+		params.Attributes = _defaultLdapParams.Attributes
+	}
+	if params.Timeout <= 0 {
+		params.Timeout = _defaultLdapParams.Timeout
+	}
+	return &LdapClient{params: params}
+}
+
+// Dial connects and binds with the service account, the caller must close the connection.
+func (c *LdapClient) Dial() (*ldap.Conn, error) {
+	u, err := url.Parse(c.params.URL)
+	if err != nil {
+		return nil, err
+	}
+	// StartTLS verifies the certificate against ServerName, it's not set from the url like ldaps
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: c.params.InsecureSkipVerify}
+	conn, err := ldap.DialURL(c.params.URL, ldap.DialWithTLSConfig(tlsConfig),
+		ldap.DialWithDialer(&net.Dialer{Timeout: c.params.Timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(c.params.Timeout)
+	if c.params.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.params.BindDN != "" {
+		err = conn.Bind(c.params.BindDN, c.params.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Search finds the user entries of username.
+func (c *LdapClient) Search(username string) ([]*ldap.Entry, error) {
+	conn, err := c.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return c.search(conn, username)
+}
+
+func (c *LdapClient) search(conn *ldap.Conn, username string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(c.params.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2,
+		int(c.params.Timeout.Seconds()), false, fmt.Sprintf(c.params.UserFilter, ldap.EscapeFilter(username)),
+		c.params.Attributes, nil)
+	res, err := conn.Search(req)
+	// the size limit is 2, exceeding it means the user is not unique
+	if err != nil && (res == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded)) {
+		return nil, err
+	}
+	return res.Entries, nil
+}
+
+// VerifyCredentials searches the user with the service account, then binds as the user with password.
+// It returns ERR_LDAP_INVALID_CREDENTIALS if the user is not found or the password is wrong.
+func (c *LdapClient) VerifyCredentials(username, password string) (*LdapUser, error) {
+	// an empty password is an unauthenticated bind, which succeeds on many servers
+	if username == "" || password == "" {
+		return nil, ERR_LDAP_INVALID_CREDENTIALS
+	}
+	conn, err := c.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	entries, err := c.search(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ERR_LDAP_INVALID_CREDENTIALS
+	case 1:
+	default:
+		return nil, ERR_LDAP_USER_NOT_UNIQUE
+	}
+	entry := entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ERR_LDAP_INVALID_CREDENTIALS
+		}
+		return nil, err
+	}
+	user := &LdapUser{DN: entry.DN, Attributes: make(map[string][]string, len(entry.Attributes))}
+	for _, attr := range entry.Attributes {
+		user.Attributes[attr.Name] = attr.Values
+	}
+	return user, nil
+}
+
+// GIN_LDAP_USER is the gin context key of the *LdapUser verified by ldap basic auth middleware.
+var GIN_LDAP_USER = "giu_ldap_user"
+
+// NewGinMiddlewareLdapBasicAuth returns a gin middleware which verifies basic auth credentials with client.
+// The username is set as the user of request scope, and the entry is set to gin context with key GIN_LDAP_USER.
+func NewGinMiddlewareLdapBasicAuth(client *LdapClient, realm string, zl *zap.Logger) gin.HandlerFunc {
+	if realm == "" {
+		realm = "Authorization Required"
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "ldap"))
+	challenge := "Basic realm=" + strconv.Quote(realm)
+	return func(c *gin.Context) {
+		username, password, ok := c.Request.BasicAuth()
+		if !ok {
+			c.Header("WWW-Authenticate", challenge)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		user, err := client.VerifyCredentials(username, password)
+		if err != nil {
+			status := http.StatusUnauthorized
+			if !errors.Is(err, ERR_LDAP_INVALID_CREDENTIALS) {
+				status = http.StatusServiceUnavailable
+			}
+			zl.Warn("[gin ldap] auth failed",
+				zap.Error(err),
+				zap.String("user", username),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)))
+			c.Header("WWW-Authenticate", challenge)
+			c.AbortWithStatus(status)
+			return
+		}
+		GinRequestScope(c).User = username
+		c.Set(GIN_LDAP_USER, user)
+		c.Next()
+	}
+}
+
+type LdapProvider interface {
+	Provider[*LdapClient]
+}
+
+type ldapProvider struct {
+	*GiuProvider[*LdapClient]
+}
+
+// NewLdapProvider creates a ldap provider from existing clients, if items is not empty, the first item will be set as default
+func NewLdapProvider(clients ...map[string]*LdapClient) LdapProvider {
+	return &ldapProvider{
+		GiuProvider: NewGiuProvider[*LdapClient](clients...),
+	}
+}
+
+// NewLdapProviderFromParams creates a ldap provider from params, if items is not empty, the first item will be set as default
+func NewLdapProviderFromParams(params map[string]*LdapParams) LdapProvider {
+	return &ldapProvider{
+		GiuProvider: NewGiuProviderFromParams[*LdapClient, *LdapParams](func(p *LdapParams) *LdapClient {
+			return NewLdapClient(*p)
+		}, params),
+	}
+}
+
+// NewLdapProviderFromConfig creates a ldap provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
+func NewLdapProviderFromConfig(config *viper.Viper) (LdapProvider, error) {
+	var params map[string]*LdapParams
+	if err := config.UnmarshalKey("ldap", &params); err != nil {
+		return nil, err
+	}
+	return NewLdapProviderFromParams(params), nil
+}
@@ -0,0 +1,215 @@
+package giu
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type LoadShedParams struct {
+	// Interval is the sampling interval of the watchdog, default is 1s.
+	Interval time.Duration
+	// MaxInFlight is the in-flight request threshold, 0 means disabled.
+	MaxInFlight int
+	// MaxCPU is the cpu usage threshold in [0, 1], 0 means disabled. Only works on unix.
+	MaxCPU float64
+	// MaxHeapMB is the heap in use threshold in megabytes, 0 means disabled.
+	MaxHeapMB uint64
+	// MaxGoroutines is the goroutine count threshold, 0 means disabled.
+	MaxGoroutines int
+	// CriticalRatio is the ratio to a threshold over which normal priority requests are shed too, default is 1.2.
+	// High priority requests are never shed.
+	CriticalRatio float64
+	// PriorityHeader is the request header carrying the priority, default is "X-Giu-Priority".
+	// It should be set by a trusted gateway, or clients can raise their own priority.
+	PriorityHeader string
+	// Routes maps path prefixes to priorities, the longest matched prefix wins over the header.
+	Routes map[string]string
+}
+
+var _defaultLoadShedParams = LoadShedParams{
+	Interval:       time.Second,
+	CriticalRatio:  1.2,
+	PriorityHeader: "X-Giu-Priority",
+}
+
+const (
+	LOAD_SHED_PRIORITY_LOW    = "low"
+	LOAD_SHED_PRIORITY_NORMAL = "normal"
+	LOAD_SHED_PRIORITY_HIGH   = "high"
+)
+
+// LoadShedStats is a snapshot of load shedder counters.
+type LoadShedStats struct {
+	InFlight   int64
+	Pressure   float64 // the max ratio of sampled values to their thresholds
+	Accepted   uint64
+	ShedLow    uint64
+	ShedNormal uint64
+}
+
+// LoadShedder rejects low priority requests when the process is under pressure, and normal priority
+// requests too when the pressure is critical.
+type LoadShedder struct {
+	params     LoadShedParams
+	watchdog   *Watchdog
+	inFlight   atomic.Int64
+	pressure   atomic.Uint64 // math.Float64bits of the sampled pressure
+	sampledAt  atomic.Int64
+	sampling   atomic.Bool
+	accepted   atomic.Uint64
+	shedLow    atomic.Uint64
+	shedNormal atomic.Uint64
+}
+
+// NewLoadShedder creates a load shedder sampling resources with watchdog, a new one is used if watchdog is nil.
+func NewLoadShedder(params LoadShedParams, watchdog *Watchdog) *LoadShedder {
+	if params.Interval <= 0 {
+		params.Interval = _defaultLoadShedParams.Interval
+	}
+	if params.CriticalRatio < 1 {
+		params.CriticalRatio = _defaultLoadShedParams.CriticalRatio
+	}
+	if params.PriorityHeader == "" {
+		params.PriorityHeader = _defaultLoadShedParams.PriorityHeader
+	}
+	if watchdog == nil {
+		watchdog = NewWatchdog(WatchdogParams{}, nil)
+	}
+	return &LoadShedder{params: params, watchdog: watchdog}
+}
+
+// NewLoadShedderFromConfig creates a load shedder from viper config with key "load_shed".
+func NewLoadShedderFromConfig(config *viper.Viper, watchdog *Watchdog) (*LoadShedder, error) {
+	var params LoadShedParams
+	if err := config.UnmarshalKey("load_shed", &params); err != nil {
+		return nil, err
+	}
+	return NewLoadShedder(params, watchdog), nil
+}
+
+// Stats returns the current counters.
+func (s *LoadShedder) Stats() LoadShedStats {
+	return LoadShedStats{
+		InFlight:   s.inFlight.Load(),
+		Pressure:   s.Pressure(),
+		Accepted:   s.accepted.Load(),
+		ShedLow:    s.shedLow.Load(),
+		ShedNormal: s.shedNormal.Load(),
+	}
+}
+
+// Pressure returns the max ratio of the latest sampled values and current in-flight count to their thresholds.
+func (s *LoadShedder) Pressure() float64 {
+	p := math.Float64frombits(s.pressure.Load())
+	if s.params.MaxInFlight > 0 {
+		p = max(p, float64(s.inFlight.Load())/float64(s.params.MaxInFlight))
+	}
+	return p
+}
+
+// Priority classifies the request by the routes first, then the priority header, default is normal.
+func (s *LoadShedder) Priority(r *http.Request) string {
+	matched := ""
+	priority := ""
+	for prefix, p := range s.params.Routes {
+		if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) >= len(matched) {
+			matched, priority = prefix, p
+		}
+	}
+	if priority == "" {
+		priority = strings.ToLower(r.Header.Get(s.params.PriorityHeader))
+	}
+	switch priority {
+	case LOAD_SHED_PRIORITY_LOW, LOAD_SHED_PRIORITY_HIGH:
+		return priority
+	default:
+		return LOAD_SHED_PRIORITY_NORMAL
+	}
+}
+
+// Allow reports whether a request with priority should be served under current pressure.
+func (s *LoadShedder) Allow(priority string) bool {
+	s.refresh()
+	pressure := s.Pressure()
+	switch {
+	case priority == LOAD_SHED_PRIORITY_HIGH || pressure < 1:
+		return true
+	case priority == LOAD_SHED_PRIORITY_LOW:
+		s.shedLow.Add(1)
+		return false
+	case pressure >= s.params.CriticalRatio:
+		s.shedNormal.Add(1)
+		return false
+	default:
+		return true
+	}
+}
+
+// refresh samples the watchdog in background once the interval has passed, so requests never wait for it.
+func (s *LoadShedder) refresh() {
+	if time.Since(time.Unix(0, s.sampledAt.Load())) < s.params.Interval || !s.sampling.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.sampling.Store(false)
+		stats := s.watchdog.Sample()
+		p := 0.0
+		if s.params.MaxCPU > 0 {
+			p = max(p, stats.CPU/s.params.MaxCPU)
+		}
+		if s.params.MaxHeapMB > 0 {
+			p = max(p, float64(stats.HeapMB)/float64(s.params.MaxHeapMB))
+		}
+		if s.params.MaxGoroutines > 0 {
+			p = max(p, float64(stats.Goroutines)/float64(s.params.MaxGoroutines))
+		}
+		s.pressure.Store(math.Float64bits(p))
+		s.sampledAt.Store(time.Now().UnixNano())
+	}()
+}
+
+// Middleware returns a gin middleware which responds 503 to the shed requests.
+func (s *LoadShedder) Middleware(zl *zap.Logger) gin.HandlerFunc {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "load_shed"))
+	return func(c *gin.Context) {
+		leave, ok := s.enter(c, s.Priority(c.Request), zl)
+		if !ok {
+			return
+		}
+		defer leave()
+		c.Next()
+	}
+}
+
+// enter counts the request as in-flight until leave is called, or aborts it with 503 if it's shed.
+func (s *LoadShedder) enter(c *gin.Context, priority string, zl *zap.Logger) (leave func(), ok bool) {
+	if !s.Allow(priority) {
+		zl.Warn("[gin load shed] request shed",
+			zap.String("priority", priority),
+			zap.Float64("pressure", s.Pressure()),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)))
+		c.Header("Retry-After", "1")
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return nil, false
+	}
+	s.accepted.Add(1)
+	s.inFlight.Add(1)
+	return func() { s.inFlight.Add(-1) }, true
+}
+
+// NewGinMiddlewareLoadShed returns a gin middleware which sheds low priority requests under pressure.
+func NewGinMiddlewareLoadShed(params LoadShedParams, watchdog *Watchdog, zl *zap.Logger) gin.HandlerFunc {
+	return NewLoadShedder(params, watchdog).Middleware(zl)
+}
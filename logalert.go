@@ -0,0 +1,95 @@
+package giu
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewZapLoggerWithAlerter creates a zap logger which sends an alert when error and above entries
+// exceed params.AlertThreshold in params.AlertWindow.
+func NewZapLoggerWithAlerter(params *LoggerParams, alerter Alerter) *zap.Logger {
+	logger := NewZapLogger(params)
+	if params.AlertThreshold <= 0 || alerter == nil {
+		return logger
+	}
+	return logger.WithOptions(zap.Hooks(newErrorBurstAlerter(params, alerter).hook))
+}
+
+type errorBurstAlerter struct {
+	alerter   Alerter
+	tag       string
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	lock      sync.Mutex
+	entries   []time.Time
+	lastAlert time.Time
+}
+
+func newErrorBurstAlerter(params *LoggerParams, alerter Alerter) *errorBurstAlerter {
+	window := params.AlertWindow
+	if window <= 0 {
+		window = time.Minute
+	}
+	cooldown := params.AlertCooldown
+	if cooldown <= 0 {
+		cooldown = 5 * time.Minute
+	}
+	return &errorBurstAlerter{
+		alerter:   alerter,
+		tag:       params.Tag,
+		threshold: params.AlertThreshold,
+		window:    window,
+		cooldown:  cooldown,
+	}
+}
+
+func (e *errorBurstAlerter) hook(ent zapcore.Entry) error {
+	if ent.Level < zapcore.ErrorLevel {
+		return nil
+	}
+	e.lock.Lock()
+	now := ent.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	// drop the entries out of window, only threshold+1 entries are needed to decide
+	i := 0
+	for i < len(e.entries) && now.Sub(e.entries[i]) > e.window {
+		i++
+	}
+	if len(e.entries)-i > e.threshold {
+		i = len(e.entries) - e.threshold
+	}
+	e.entries = append(e.entries[i:], now)
+	count := len(e.entries)
+	fire := count > e.threshold && now.Sub(e.lastAlert) >= e.cooldown
+	if fire {
+		e.lastAlert = now
+	}
+	e.lock.Unlock()
+
+	if fire {
+		// send in a new goroutine, never block the logger
+		go func() {
+			_ = e.alerter.Alert(context.Background(), Alert{
+				Level:   LOG_LEVEL_ERROR,
+				Title:   "[logger] too many errors",
+				Content: ent.Message,
+				Fields: map[string]string{
+					"tag":    e.tag,
+					"count":  strconv.Itoa(count),
+					"window": e.window.String(),
+				},
+				Time: now,
+			})
+		}()
+	}
+	return nil
+}
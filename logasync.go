@@ -0,0 +1,199 @@
+package giu
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	LOG_ASYNC_POLICY_DROP  = "drop"
+	LOG_ASYNC_POLICY_BLOCK = "block"
+)
+
+type AsyncLogParams struct {
+	// QueueSize is the max number of queued entries, default is 1024.
+	QueueSize int
+	// Policy is what to do when the queue is full, "drop" drops the entry and "block" waits, default is drop.
+	Policy string
+}
+
+var _defaultAsyncLogParams = AsyncLogParams{
+	QueueSize: 1024,
+	Policy:    LOG_ASYNC_POLICY_DROP,
+}
+
+var ERR_ASYNC_LOG_STOPPED = errors.New("async log writer is stopped")
+
+// asyncLogDropped counts the dropped entries of all async writers.
+var asyncLogDropped atomic.Uint64
+
+// AsyncLogDropped returns the number of entries dropped by all async writers, including the ones of loggers
+// created with LoggerParams.Async.
+func AsyncLogDropped() uint64 {
+	return asyncLogDropped.Load()
+}
+
+// AsyncLogStats is a snapshot of async writer counters.
+type AsyncLogStats struct {
+	Queued  int
+	Written uint64
+	Dropped uint64
+	Failed  uint64
+}
+
+// AsyncWriteSyncer writes entries to the underlying WriteSyncer in background, so slow disks or remote sinks
+// don't block the callers. Sync waits for the queued entries to be written.
+type AsyncWriteSyncer struct {
+	ws      zapcore.WriteSyncer
+	policy  string
+	queue   chan []byte
+	flush   chan chan struct{}
+	lock    sync.RWMutex
+	stopped bool
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	written atomic.Uint64
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+func NewAsyncWriteSyncer(ws zapcore.WriteSyncer, params AsyncLogParams) *AsyncWriteSyncer {
+	if params.QueueSize <= 0 {
+		params.QueueSize = _defaultAsyncLogParams.QueueSize
+	}
+	if params.Policy != LOG_ASYNC_POLICY_BLOCK {
+		params.Policy = _defaultAsyncLogParams.Policy
+	}
+	a := &AsyncWriteSyncer{
+		ws:     ws,
+		policy: params.Policy,
+		queue:  make(chan []byte, params.QueueSize),
+		flush:  make(chan chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Write queues a copy of p, zap reuses its buffers. After Stop, it writes to the underlying WriteSyncer directly.
+func (a *AsyncWriteSyncer) Write(p []byte) (int, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if a.stopped {
+		return a.ws.Write(p)
+	}
+	entry := append([]byte(nil), p...)
+	if a.policy == LOG_ASYNC_POLICY_BLOCK {
+		a.queue <- entry
+		return len(p), nil
+	}
+	select {
+	case a.queue <- entry:
+	default:
+		a.dropped.Add(1)
+		asyncLogDropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Sync waits for the queued entries to be written, then syncs the underlying WriteSyncer.
+func (a *AsyncWriteSyncer) Sync() error {
+	ch := make(chan struct{})
+	select {
+	case a.flush <- ch:
+		<-ch
+	case <-a.done:
+	}
+	return a.ws.Sync()
+}
+
+// Stop writes the queued entries and stops the background goroutine, the later writes are synchronous.
+func (a *AsyncWriteSyncer) Stop() error {
+	a.once.Do(func() {
+		a.lock.Lock()
+		a.stopped = true
+		a.lock.Unlock()
+		close(a.stop)
+	})
+	<-a.done
+	return a.ws.Sync()
+}
+
+// Stats returns the current counters.
+func (a *AsyncWriteSyncer) Stats() AsyncLogStats {
+	return AsyncLogStats{
+		Queued:  len(a.queue),
+		Written: a.written.Load(),
+		Dropped: a.dropped.Load(),
+		Failed:  a.failed.Load(),
+	}
+}
+
+func (a *AsyncWriteSyncer) run() {
+	defer close(a.done)
+	for {
+		select {
+		case entry := <-a.queue:
+			a.write(entry)
+		case ch := <-a.flush:
+			a.drain()
+			close(ch)
+		case <-a.stop:
+			a.drain()
+			return
+		}
+	}
+}
+
+func (a *AsyncWriteSyncer) drain() {
+	for {
+		select {
+		case entry := <-a.queue:
+			a.write(entry)
+		default:
+			return
+		}
+	}
+}
+
+func (a *AsyncWriteSyncer) write(entry []byte) {
+	if _, err := a.ws.Write(entry); err != nil {
+		a.failed.Add(1)
+		return
+	}
+	a.written.Add(1)
+}
+
+// asyncLogWriters collects the async writers created for a logger, so they can be stopped with it.
+type asyncLogWriters []*AsyncWriteSyncer
+
+// asyncLoggers are the async writers of the loggers created from params, *zap.Logger -> asyncLogWriters.
+var asyncLoggers sync.Map
+
+// syncer wraps ws with an AsyncWriteSyncer if the params enable async writing.
+func (w *asyncLogWriters) syncer(params *LoggerParams, ws zapcore.WriteSyncer) zapcore.WriteSyncer {
+	if !params.Async {
+		return ws
+	}
+	a := NewAsyncWriteSyncer(ws, AsyncLogParams{QueueSize: params.AsyncQueueSize, Policy: params.AsyncPolicy})
+	*w = append(*w, a)
+	return a
+}
+
+// closeZapLogger syncs the logger and stops its async writers, the queued entries are written before they stop.
+// It's the close func of the loggers replaced by reload and removed from the provider.
+func closeZapLogger(logger *zap.Logger) error {
+	err := logger.Sync()
+	if v, ok := asyncLoggers.LoadAndDelete(logger); ok {
+		for _, a := range v.(asyncLogWriters) {
+			err = errors.Join(err, a.Stop())
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package giu
+
+import (
+	"log/slog"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	LOG_ENRICHER_HOSTNAME  = "hostname"
+	LOG_ENRICHER_PID       = "pid"
+	LOG_ENRICHER_ENV       = "env"
+	LOG_ENRICHER_POD       = "pod"
+	LOG_ENRICHER_NAMESPACE = "namespace"
+	LOG_ENRICHER_NODE      = "node"
+)
+
+// The env vars of the enrichers, pod, namespace and node are usually set by kubernetes downward api.
+var (
+	GIU_ENV_ENV       = "GIU_ENV"
+	GIU_ENV_POD       = "POD_NAME"
+	GIU_ENV_NAMESPACE = "POD_NAMESPACE"
+	GIU_ENV_NODE      = "NODE_NAME"
+)
+
+// LOG_ENRICHERS are the enrichers of loggers whose params don't set Enrichers, "-" in Enrichers disables them.
+var LOG_ENRICHERS = []string{
+	LOG_ENRICHER_HOSTNAME, LOG_ENRICHER_PID, LOG_ENRICHER_ENV,
+	LOG_ENRICHER_POD, LOG_ENRICHER_NAMESPACE, LOG_ENRICHER_NODE,
+}
+
+// zapEnrichFields returns the fields of the enrichers of params in order, empty values are skipped.
+func zapEnrichFields(params *LoggerParams) []zap.Field {
+	enrichers := params.Enrichers
+	if enrichers == nil {
+		enrichers = LOG_ENRICHERS
+	}
+	var fields []zap.Field
+	for _, e := range enrichers {
+		var v string
+		switch e {
+		case LOG_ENRICHER_HOSTNAME:
+			v, _ = os.Hostname()
+		case LOG_ENRICHER_PID:
+			fields = append(fields, zap.Int(e, os.Getpid()))
+			continue
+		case LOG_ENRICHER_ENV:
+			v = params.Env
+			if v == "" {
+				v = os.Getenv(GIU_ENV_ENV)
+			}
+		case LOG_ENRICHER_POD:
+			v = os.Getenv(GIU_ENV_POD)
+		case LOG_ENRICHER_NAMESPACE:
+			v = os.Getenv(GIU_ENV_NAMESPACE)
+		case LOG_ENRICHER_NODE:
+			v = os.Getenv(GIU_ENV_NODE)
+		}
+		if v != "" {
+			fields = append(fields, zap.String(e, v))
+		}
+	}
+	return fields
+}
+
+func slogEnrichAttrs(params *LoggerParams) []any {
+	var attrs []any
+	for _, f := range zapEnrichFields(params) {
+		if f.Type == zapcore.Int64Type {
+			attrs = append(attrs, slog.Int64(f.Key, f.Integer))
+			continue
+		}
+		attrs = append(attrs, slog.String(f.Key, f.String))
+	}
+	return attrs
+}
@@ -0,0 +1,122 @@
+package giu
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	_defaultLogDirMode  = 0o755
+	_defaultLogFileMode = 0o644
+)
+
+// PrepareLogFiles creates the missing directories of the log files with DirMode, creates the files with FileMode
+// and changes their owner to Owner, it fails if a file is not writable.
+func PrepareLogFiles(params *LoggerParams) error {
+	dirMode, err := parseFileMode(params.DirMode, _defaultLogDirMode)
+	if err != nil {
+		return fmt.Errorf("invalid log dir mode: %w", err)
+	}
+	fileMode, err := parseFileMode(params.FileMode, _defaultLogFileMode)
+	if err != nil {
+		return fmt.Errorf("invalid log file mode: %w", err)
+	}
+	uid, gid := -1, -1
+	if params.Owner != "" {
+		if uid, gid, err = parseOwner(params.Owner); err != nil {
+			return err
+		}
+	}
+	names := []string{params.ErrorLogName}
+	if len(params.Sinks) == 0 || slices.Contains(params.Sinks, LOG_SINK_FILE) {
+		names = append(names, params.LogName)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if err := prepareLogFile(name, dirMode, fileMode, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func prepareLogFile(name string, dirMode, fileMode os.FileMode, uid, gid int) error {
+	if err := os.MkdirAll(filepath.Dir(name), dirMode); err != nil {
+		return fmt.Errorf("create log dir of %s: %w", name, err)
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return fmt.Errorf("log file %s is not writable: %w", name, err)
+	}
+	defer f.Close()
+	if err := f.Chmod(fileMode); err != nil {
+		return fmt.Errorf("chmod log file %s: %w", name, err)
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := f.Chown(uid, gid); err != nil {
+			return fmt.Errorf("chown log file %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// parseFileMode parses an octal mode like "0640", empty means def.
+func parseFileMode(s string, def os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return def, nil
+	}
+	m, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(m), nil
+}
+
+// parseOwner parses "uid:gid", either may be empty to keep it.
+func parseOwner(s string) (int, int, error) {
+	u, g, _ := strings.Cut(s, ":")
+	uid, gid := -1, -1
+	var err error
+	if u != "" {
+		if uid, err = strconv.Atoi(u); err != nil {
+			return 0, 0, fmt.Errorf("invalid log owner %q: %w", s, err)
+		}
+	}
+	if g != "" {
+		if gid, err = strconv.Atoi(g); err != nil {
+			return 0, 0, fmt.Errorf("invalid log owner %q: %w", s, err)
+		}
+	}
+	return uid, gid, nil
+}
+
+// NewZapLoggerWithCheck prepares the log files and sinks before creating the logger, so an unwritable path or
+// an unavailable sink fails fast.
+func NewZapLoggerWithCheck(params *LoggerParams) (*zap.Logger, error) {
+	if err := PrepareLogFiles(params); err != nil {
+		return nil, err
+	}
+	logger, err := newZapLogger(params)
+	if err != nil {
+		_ = closeZapLogger(logger)
+		return nil, err
+	}
+	return logger, nil
+}
+
+// NewSLoggerWithCheck prepares the log files before creating the logger, so an unwritable path fails fast.
+func NewSLoggerWithCheck(params LoggerParams) (*slog.Logger, error) {
+	if err := PrepareLogFiles(&params); err != nil {
+		return nil, err
+	}
+	return NewSLogger(params), nil
+}
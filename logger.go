@@ -6,6 +6,7 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -20,12 +21,56 @@ type LoggerParams struct {
 	MaxAge    int    // max age in days
 	Compress  bool   // compress
 	Tag       string // log tag
+	DirMode   string // octal permission of created log dirs, default is "0755"
+	FileMode  string // octal permission of log files, default is "0644"
+	Owner     string // "uid:gid" owner of log files, default is the process user
+
+	// Preset is one of "development", "production" and "custom", default is development if debug is turned on by
+	// SetMode, otherwise production without sampling, so access logs of the same message are never dropped.
+	// development: DPanic panics and stacktraces from warn level.
+	// production: sampling of 100 initial and 100 thereafter entries per second, stacktraces from error level.
+	// custom: Development, SamplingInitial, SamplingThereafter and StacktraceLevel below.
+	Preset             string
+	Development        bool   // DPanic panics in development mode
+	SamplingInitial    int    // entries of same level and message logged each second, 0 means no sampling
+	SamplingThereafter int    // every Nth entry logged after SamplingInitial
+	StacktraceLevel    string // min level with stacktrace, empty means no stacktrace
+
+	// ErrorLogName is the file which error and above entries are duplicated to, empty means disabled.
+	// The rotation settings default to the main ones.
+	ErrorLogName   string
+	ErrorMaxSize   int
+	ErrorMaxBackup int
+	ErrorMaxAge    int
+
+	Sinks          []string // file, stdout, journald (linux), eventlog (windows), default is file
+	EventLogSource string   // registered source of eventlog sink
+
+	Enrichers []string // global fields: hostname, pid, env, pod, namespace, node, default is LOG_ENRICHERS, "-" disables them
+	Env       string   // environment name of env field, default is env GIU_ENV
+
+	AlertThreshold int           // fire alert when error and above entries in AlertWindow exceed it, 0 means disabled
+	AlertWindow    time.Duration // sliding window of error counting, default is 1 minute
+	AlertCooldown  time.Duration // min interval between two alerts, default is 5 minutes
+
+	// Async writes the file and stdout sinks in background, the entries are dropped or the callers are blocked
+	// by AsyncPolicy when AsyncQueueSize entries are queued, see AsyncWriteSyncer. The writers are flushed and
+	// stopped when the logger is removed, replaced by reload or shut down with the provider.
+	Async          bool
+	AsyncQueueSize int    // max queued entries, default is 1024
+	AsyncPolicy    string // drop or block, default is drop
 }
 
 var (
 	ERR_LOGGER_NOT_INIT = errors.New("logger is nil, please init logger first")
 )
 
+const (
+	LOGGER_PRESET_DEVELOPMENT = "development"
+	LOGGER_PRESET_PRODUCTION  = "production"
+	LOGGER_PRESET_CUSTOM      = "custom"
+)
+
 const (
 	LOG_LEVEL_DEBUG  = "debug"
 	LOG_LEVEL_INFO   = "info"
@@ -46,27 +91,122 @@ var _defaultLoggerParams = LoggerParams{
 	Tag:       "default",
 }
 
+// NewZapLogger creates the logger, the log files and sinks are prepared by best effort, use NewZapLoggerWithCheck to fail fast.
 func NewZapLogger(params *LoggerParams) *zap.Logger {
-	core := newZapCore(params.LogName, params.LogLevel, params.MaxSize, params.MaxBackup, params.MaxAge, params.Compress)
-	return zap.New(core, zap.AddCaller(), zap.Development(), zap.Fields(zap.String("tag", params.Tag)))
+	_ = PrepareLogFiles(params)
+	logger, _ := newZapLogger(params)
+	return logger
+}
+
+// newZapLogger creates the logger with the sinks created successfully, and returns the errors of the others.
+func newZapLogger(params *LoggerParams) (*zap.Logger, error) {
+	level := defaultLogLevel(params.LogLevel)
+	var writers asyncLogWriters
+	cores, err := newZapSinkCores(params, level, &writers)
+	if params.ErrorLogName != "" {
+		cores = append(cores, newZapErrorCore(params, &writers))
+	}
+	opts := []zap.Option{zap.AddCaller(), zap.Fields(append([]zap.Field{zap.String("tag", params.Tag)}, zapEnrichFields(params)...)...)}
+	logger := zap.New(zapcore.NewTee(cores...), append(opts, zapPresetOptions(params)...)...)
+	if len(writers) > 0 {
+		asyncLoggers.Store(logger, writers)
+	}
+	return logger, err
+}
+
+// zapPresetOptions returns the options of the logger preset.
+func zapPresetOptions(params *LoggerParams) []zap.Option {
+	preset := params.Preset
+	if preset == "" {
+		preset = LOGGER_PRESET_PRODUCTION
+		// without SetMode the loggers keep the production behavior
+		if debugToggled() {
+			preset = LOGGER_PRESET_DEVELOPMENT
+		}
+	}
+	var opts []zap.Option
+	development, initial, thereafter, stacktrace := false, 0, 0, ""
+	switch preset {
+	case LOGGER_PRESET_DEVELOPMENT:
+		development, stacktrace = true, LOG_LEVEL_WARN
+	case LOGGER_PRESET_CUSTOM:
+		development, initial, thereafter, stacktrace = params.Development, params.SamplingInitial, params.SamplingThereafter, params.StacktraceLevel
+	default:
+		stacktrace = LOG_LEVEL_ERROR
+		// sampling drops entries silently, it must be chosen explicitly
+		if params.Preset != "" {
+			initial, thereafter = 100, 100
+		}
+	}
+	if development {
+		opts = append(opts, zap.Development())
+	}
+	if stacktrace != "" {
+		opts = append(opts, zap.AddStacktrace(convertZapLevel(stacktrace)))
+	}
+	if initial > 0 {
+		if thereafter <= 0 {
+			thereafter = initial
+		}
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewSamplerWithOptions(core, time.Second, initial, thereafter)
+		}))
+	}
+	return opts
 }
 
 func DefaultZapLogger() *zap.Logger {
 	return NewZapLogger(&_defaultLoggerParams)
 }
 
-func newZapCore(fileName string, level string, maxSize int, maxBackups int, maxAge int, compress bool) zapcore.Core {
+func newZapCore(params *LoggerParams, level string, writers *asyncLogWriters) zapcore.Core {
 	hook := lumberjack.Logger{
-		Filename:   fileName,
-		MaxSize:    maxSize,
-		MaxBackups: maxBackups,
-		MaxAge:     maxAge,
-		Compress:   compress,
+		Filename:   params.LogName,
+		MaxSize:    params.MaxSize,
+		MaxBackups: params.MaxBackup,
+		MaxAge:     params.MaxAge,
+		Compress:   params.Compress,
 	}
 	atomicLevel := zap.NewAtomicLevel()
 	logLevel := convertZapLevel(level)
 	atomicLevel.SetLevel(logLevel)
-	encoderConfig := zapcore.EncoderConfig{
+
+	syncer := zapcore.AddSync(&hook)
+	if logLevel <= zapcore.InfoLevel {
+		// log to stdout when log level is info or lower
+		syncer = zapcore.NewMultiWriteSyncer(syncer, zapcore.AddSync(os.Stdout))
+	}
+
+	return zapcore.NewCore(
+		zapcore.NewJSONEncoder(newZapEncoderConfig()),
+		writers.syncer(params, syncer),
+		atomicLevel,
+	)
+}
+
+// newZapErrorCore returns the core writing error and above entries to the error log file.
+func newZapErrorCore(params *LoggerParams, writers *asyncLogWriters) zapcore.Core {
+	hook := &lumberjack.Logger{
+		Filename:   params.ErrorLogName,
+		MaxSize:    params.ErrorMaxSize,
+		MaxBackups: params.ErrorMaxBackup,
+		MaxAge:     params.ErrorMaxAge,
+		Compress:   params.Compress,
+	}
+	if hook.MaxSize == 0 {
+		hook.MaxSize = params.MaxSize
+	}
+	if hook.MaxBackups == 0 {
+		hook.MaxBackups = params.MaxBackup
+	}
+	if hook.MaxAge == 0 {
+		hook.MaxAge = params.MaxAge
+	}
+	return zapcore.NewCore(zapcore.NewJSONEncoder(newZapEncoderConfig()), writers.syncer(params, zapcore.AddSync(hook)), zapcore.ErrorLevel)
+}
+
+func newZapEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
 		TimeKey:        "time",
 		LevelKey:       "level",
 		NameKey:        "logger",
@@ -79,18 +219,6 @@ func newZapCore(fileName string, level string, maxSize int, maxBackups int, maxA
 		// EncodeCaller:   zapcore.FullCallerEncoder,
 		EncodeName: zapcore.FullNameEncoder,
 	}
-
-	syncer := zapcore.AddSync(&hook)
-	if logLevel <= zapcore.InfoLevel {
-		// log to stdout when log level is info or lower
-		syncer = zapcore.NewMultiWriteSyncer(syncer, zapcore.AddSync(os.Stdout))
-	}
-
-	return zapcore.NewCore(
-		zapcore.NewJSONEncoder(encoderConfig),
-		syncer,
-		atomicLevel,
-	)
 }
 
 type ZapLogger struct {
@@ -149,7 +277,9 @@ func convertSLogLevel(logLevel string) slog.Level {
 	return level
 }
 
+// NewSLogger creates the logger, the log files are prepared by best effort, use NewSLoggerWithCheck to fail fast.
 func NewSLogger(params LoggerParams) *slog.Logger {
+	_ = PrepareLogFiles(&params)
 	var writer io.Writer
 	hook := lumberjack.Logger{
 		Filename:   params.LogName,
@@ -169,6 +299,9 @@ func NewSLogger(params LoggerParams) *slog.Logger {
 	if params.Tag != "" {
 		logger = logger.With(slog.String("tag", params.Tag))
 	}
+	if attrs := slogEnrichAttrs(&params); len(attrs) > 0 {
+		logger = logger.With(attrs...)
+	}
 	return logger
 }
 
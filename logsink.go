@@ -0,0 +1,52 @@
+package giu
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	LOG_SINK_FILE     = "file"
+	LOG_SINK_STDOUT   = "stdout"
+	LOG_SINK_JOURNALD = "journald"
+	LOG_SINK_EVENTLOG = "eventlog"
+)
+
+var ERR_LOG_SINK_UNSUPPORTED = errors.New("log sink is not supported on this platform")
+
+// newZapSinkCores creates the cores of the sinks of params, default is the file sink.
+// The cores of all sinks which are created successfully are returned with the joined errors of the others.
+func newZapSinkCores(params *LoggerParams, level string, writers *asyncLogWriters) ([]zapcore.Core, error) {
+	sinks := params.Sinks
+	if len(sinks) == 0 {
+		sinks = []string{LOG_SINK_FILE}
+	}
+	enabler := convertZapLevel(level)
+	var cores []zapcore.Core
+	var errs []error
+	for _, sink := range sinks {
+		var core zapcore.Core
+		var err error
+		switch sink {
+		case LOG_SINK_FILE:
+			core = newZapCore(params, level, writers)
+		case LOG_SINK_STDOUT:
+			core = zapcore.NewCore(zapcore.NewJSONEncoder(newZapEncoderConfig()), writers.syncer(params, zapcore.Lock(os.Stdout)), enabler)
+		case LOG_SINK_JOURNALD:
+			core, err = newJournaldCore(zapcore.NewJSONEncoder(newZapEncoderConfig()), enabler, params.Tag)
+		case LOG_SINK_EVENTLOG:
+			core, err = newEventLogCore(zapcore.NewJSONEncoder(newZapEncoderConfig()), enabler, params.EventLogSource)
+		default:
+			err = fmt.Errorf("unknown log sink: %s", sink)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink, err))
+			continue
+		}
+		cores = append(cores, core)
+	}
+	return cores, errors.Join(errs...)
+}
@@ -0,0 +1,101 @@
+//go:build linux
+
+package giu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// JOURNALD_SOCKET is the socket of systemd journal native protocol.
+var JOURNALD_SOCKET = "/run/systemd/journal/socket"
+
+// journaldCore writes entries to systemd journal, the message is the encoded entry.
+type journaldCore struct {
+	zapcore.LevelEnabler
+	enc        zapcore.Encoder
+	conn       *net.UnixConn
+	identifier string
+}
+
+func newJournaldCore(enc zapcore.Encoder, enabler zapcore.LevelEnabler, identifier string) (zapcore.Core, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JOURNALD_SOCKET, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldCore{LevelEnabler: enabler, enc: enc, conn: conn, identifier: identifier}, nil
+}
+
+func (c *journaldCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *journaldCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *journaldCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	var b bytes.Buffer
+	writeJournaldField(&b, "MESSAGE", strings.TrimRight(buf.String(), "\n"))
+	writeJournaldField(&b, "PRIORITY", journaldPriority(e.Level))
+	if c.identifier != "" {
+		writeJournaldField(&b, "SYSLOG_IDENTIFIER", c.identifier)
+	}
+	_, err = c.conn.Write(b.Bytes())
+	return err
+}
+
+func (c *journaldCore) Sync() error {
+	return nil
+}
+
+// writeJournaldField writes a field of native protocol, values with newlines are written in binary form.
+func writeJournaldField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+func journaldPriority(l zapcore.Level) string {
+	switch l {
+	case zapcore.DebugLevel:
+		return "7"
+	case zapcore.InfoLevel:
+		return "6"
+	case zapcore.WarnLevel:
+		return "4"
+	case zapcore.ErrorLevel:
+		return "3"
+	default:
+		return "2"
+	}
+}
+
+func newEventLogCore(zapcore.Encoder, zapcore.LevelEnabler, string) (zapcore.Core, error) {
+	return nil, ERR_LOG_SINK_UNSUPPORTED
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package giu
+
+import "go.uber.org/zap/zapcore"
+
+func newJournaldCore(zapcore.Encoder, zapcore.LevelEnabler, string) (zapcore.Core, error) {
+	return nil, ERR_LOG_SINK_UNSUPPORTED
+}
+
+func newEventLogCore(zapcore.Encoder, zapcore.LevelEnabler, string) (zapcore.Core, error) {
+	return nil, ERR_LOG_SINK_UNSUPPORTED
+}
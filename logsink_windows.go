@@ -0,0 +1,66 @@
+//go:build windows
+
+package giu
+
+import (
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventLogCore writes entries to Windows Event Log, the source must be registered, e.g. by eventlog.InstallAsEventCreate.
+type eventLogCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	log *eventlog.Log
+}
+
+func newEventLogCore(enc zapcore.Encoder, enabler zapcore.LevelEnabler, source string) (zapcore.Core, error) {
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogCore{LevelEnabler: enabler, enc: enc, log: l}, nil
+}
+
+func (c *eventLogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *eventLogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *eventLogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	msg := strings.TrimRight(buf.String(), "\r\n")
+	switch {
+	case e.Level >= zapcore.ErrorLevel:
+		return c.log.Error(1, msg)
+	case e.Level == zapcore.WarnLevel:
+		return c.log.Warning(1, msg)
+	default:
+		return c.log.Info(1, msg)
+	}
+}
+
+func (c *eventLogCore) Sync() error {
+	return nil
+}
+
+func newJournaldCore(zapcore.Encoder, zapcore.LevelEnabler, string) (zapcore.Core, error) {
+	return nil, ERR_LOG_SINK_UNSUPPORTED
+}
@@ -0,0 +1,129 @@
+package giu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+)
+
+type MirrorParams struct {
+	// Upstream is the base url of the shadow service, e.g. "http://shadow:8080".
+	Upstream string
+	// SampleRate is the fraction of mirrored requests in [0, 1].
+	SampleRate float64
+	// Timeout is the timeout of shadow requests, default is 5s.
+	Timeout time.Duration
+	// MaxBodySize is the max request body mirrored, requests with bigger bodies are not mirrored, default is 1MB.
+	MaxBodySize int64
+	// Concurrency is the max in-flight shadow requests, requests over it are not mirrored, default is 16.
+	Concurrency int
+}
+
+// GIN_MIRROR_HEADER is set on shadow requests, so the shadow service can tell them from the real ones.
+var GIN_MIRROR_HEADER = "X-Giu-Mirror"
+
+// NewGinMiddlewareMirror returns a gin middleware which mirrors a sample of requests to the shadow upstream
+// asynchronously with client, and logs the differences of status and body between the responses.
+// If client is nil, a new resty client is used. Shadow responses never affect the real ones.
+func NewGinMiddlewareMirror(params MirrorParams, client *resty.Client, zl *zap.Logger) gin.HandlerFunc {
+	if params.Timeout <= 0 {
+		params.Timeout = 5 * time.Second
+	}
+	if params.MaxBodySize <= 0 {
+		params.MaxBodySize = 1 << 20
+	}
+	if params.Concurrency <= 0 {
+		params.Concurrency = 16
+	}
+	if client == nil {
+		client = NewResty(nil)
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "mirror"))
+	upstream := strings.TrimSuffix(params.Upstream, "/")
+	sem := make(chan struct{}, params.Concurrency)
+	return func(c *gin.Context) {
+		if params.SampleRate <= 0 || rand.Float64() >= params.SampleRate || c.Request.ContentLength > params.MaxBodySize {
+			c.Next()
+			return
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			data, err := io.ReadAll(io.LimitReader(c.Request.Body, params.MaxBodySize+1))
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), c.Request.Body))
+			if err != nil || int64(len(data)) > params.MaxBodySize {
+				c.Next()
+				return
+			}
+			body = data
+		}
+		bw := bodyLogWriter{body: bytes.NewBuffer(nil), ResponseWriter: c.Writer, max: -1}
+		c.Writer = bw
+		c.Next()
+
+		select {
+		case sem <- struct{}{}:
+		default:
+			zl.Debug("[gin mirror] too many shadow requests, skipped", zap.String("path", c.Request.URL.Path))
+			return
+		}
+		req := c.Request
+		header := req.Header.Clone()
+		url := upstream + req.URL.RequestURI()
+		status, respBody := c.Writer.Status(), bw.body.Bytes()
+		if decoded, err := decodeLogBody(c.Writer.Header().Get("Content-Encoding"), respBody); err == nil {
+			respBody = decoded
+		}
+		// keep the scope of request for logging
+		ctx := context.WithoutCancel(req.Context())
+		go func() {
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(ctx, params.Timeout)
+			defer cancel()
+			header.Set(GIN_MIRROR_HEADER, "1")
+			header.Del("Accept-Encoding")
+			resp, err := client.R().SetContext(ctx).SetHeaderMultiValues(header).SetBody(body).Execute(req.Method, url)
+			zl := LoggerWithScope(ctx, zl).With(zap.String("method", req.Method), zap.String("path", req.URL.Path))
+			if err != nil {
+				zl.Warn("[gin mirror] shadow request failed", zap.Error(err))
+				return
+			}
+			if resp.StatusCode() != status || !equalMirrorBody(respBody, resp.Body()) {
+				zl.Warn("[gin mirror] shadow response differs",
+					zap.Int("status", status),
+					zap.Int("shadow_status", resp.StatusCode()),
+					zap.ByteString("body", truncateBytes(respBody, 1024)),
+					zap.ByteString("shadow_body", truncateBytes(resp.Body(), 1024)))
+				return
+			}
+			zl.Debug("[gin mirror] shadow response matches", zap.Int("status", status))
+		}()
+	}
+}
+
+// equalMirrorBody compares json bodies semantically and others bytewise.
+func equalMirrorBody(a, b []byte) bool {
+	var ja, jb interface{}
+	if json.Unmarshal(a, &ja) == nil && json.Unmarshal(b, &jb) == nil {
+		return reflect.DeepEqual(ja, jb)
+	}
+	return bytes.Equal(a, b)
+}
+
+func truncateBytes(b []byte, n int) []byte {
+	if len(b) <= n {
+		return b
+	}
+	return b[:n]
+}
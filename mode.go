@@ -0,0 +1,101 @@
+package giu
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	MODE_DEBUG   = gin.DebugMode
+	MODE_RELEASE = gin.ReleaseMode
+	MODE_TEST    = gin.TestMode
+)
+
+var (
+	modeLock  sync.RWMutex
+	giuMode   string
+	giuDebug  bool
+	modeIsSet bool
+)
+
+// SetMode sets the global mode and debug toggle, they decide the defaults of gin mode, zap development options,
+// resty debug and gorm log level, so the subsystems are toggled coherently.
+// Debug is always on in debug mode, and it can be turned on in release mode for troubleshooting.
+func SetMode(mode string, debug bool) error {
+	switch mode {
+	case "":
+		mode = MODE_DEBUG
+	case MODE_DEBUG, MODE_RELEASE, MODE_TEST:
+	default:
+		return fmt.Errorf("unsupported mode: %s", mode)
+	}
+	modeLock.Lock()
+	defer modeLock.Unlock()
+	giuMode = mode
+	giuDebug = debug || mode == MODE_DEBUG
+	modeIsSet = true
+	gin.SetMode(mode)
+	return nil
+}
+
+// Mode returns the global mode, it's empty if SetMode is not called.
+func Mode() string {
+	modeLock.RLock()
+	defer modeLock.RUnlock()
+	return giuMode
+}
+
+// IsDebug reports whether debug is on, it's true if SetMode is not called, which is the legacy behavior.
+func IsDebug() bool {
+	modeLock.RLock()
+	defer modeLock.RUnlock()
+	return !modeIsSet || giuDebug
+}
+
+// debugToggled reports whether debug is turned on by SetMode explicitly.
+func debugToggled() bool {
+	modeLock.RLock()
+	defer modeLock.RUnlock()
+	return modeIsSet && giuDebug
+}
+
+// defaultLogLevel returns the log level by mode if level is empty.
+func defaultLogLevel(level string) string {
+	if level != "" {
+		return level
+	}
+	modeLock.RLock()
+	defer modeLock.RUnlock()
+	switch {
+	case !modeIsSet:
+		return ""
+	case giuDebug:
+		return LOG_LEVEL_DEBUG
+	default:
+		return LOG_LEVEL_INFO
+	}
+}
+
+// defaultGormLogLevel returns the gorm log level by mode if level is empty.
+func defaultGormLogLevel(level string) string {
+	if level != "" {
+		return level
+	}
+	modeLock.RLock()
+	defer modeLock.RUnlock()
+	switch {
+	case !modeIsSet:
+		return ""
+	case giuDebug:
+		return LOG_LEVEL_INFO
+	default:
+		return LOG_LEVEL_WARN
+	}
+}
+
+// ApplyModeConfig sets the global mode by the mode and debug of config.
+func ApplyModeConfig[ExtendParams any](config *GiuConfig[ExtendParams]) error {
+	return SetMode(config.Mode, config.Debug)
+}
@@ -0,0 +1,166 @@
+package giu
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type MongoParams struct {
+	URI string
+	// SlowThreshold is the duration over which commands are logged as slow, default is 200ms, negative disables it.
+	SlowThreshold time.Duration
+	// LogLevel is the level of command logging, "info" logs all commands, "warn" logs slow and failed commands,
+	// "error" logs failed commands only, default is "warn".
+	LogLevel string
+	// LogValues logs the documents as is, otherwise the values are replaced with "?", like the placeholders of sql.
+	LogValues bool
+}
+
+var _defaultMongoParams = MongoParams{
+	URI:           "mongodb://localhost:27017",
+	SlowThreshold: 200 * time.Millisecond,
+	LogLevel:      LOG_LEVEL_WARN,
+}
+
+// NewMongo connects to mongo, commands are logged with zl if it's not nil.
+func NewMongo(params MongoParams, zl *zap.Logger) (*mongo.Client, error) {
+	opts := options.Client().ApplyURI(params.URI)
+	if zl != nil {
+		opts.SetMonitor(NewMongoCommandMonitor(zl, params))
+	}
+	return mongo.Connect(context.Background(), opts)
+}
+
+func DefaultMongo() (*mongo.Client, error) {
+	return NewMongo(_defaultMongoParams, nil)
+}
+
+// mongoSensitiveCommands are never logged with documents.
+var mongoSensitiveCommands = map[string]bool{
+	"authenticate": true, "saslStart": true, "saslContinue": true, "getnonce": true,
+	"createUser": true, "updateUser": true, "copydbgetnonce": true, "copydbsaslstart": true, "copydb": true,
+}
+
+type mongoCommandLogger struct {
+	logger  *zap.Logger
+	params  MongoParams
+	started sync.Map // request id -> bson.Raw
+}
+
+// NewMongoCommandMonitor returns a command monitor which logs commands like ZapGormLogger logs sql.
+func NewMongoCommandMonitor(zl *zap.Logger, params MongoParams) *event.CommandMonitor {
+	if params.SlowThreshold == 0 {
+		params.SlowThreshold = _defaultMongoParams.SlowThreshold
+	}
+	if params.LogLevel == "" {
+		params.LogLevel = _defaultMongoParams.LogLevel
+	}
+	l := &mongoCommandLogger{logger: zl.With(zap.String("module", "mongo")), params: params}
+	return &event.CommandMonitor{
+		Started:   l.onStarted,
+		Succeeded: l.onSucceeded,
+		Failed:    l.onFailed,
+	}
+}
+
+func (l *mongoCommandLogger) onStarted(_ context.Context, e *event.CommandStartedEvent) {
+	if mongoSensitiveCommands[e.CommandName] {
+		return
+	}
+	l.started.Store(e.RequestID, e.Command)
+}
+
+func (l *mongoCommandLogger) fields(e event.CommandFinishedEvent) []zap.Field {
+	fields := []zap.Field{
+		zap.String("database", e.DatabaseName),
+		zap.String("command", e.CommandName),
+		zap.Duration("elapsed", e.Duration),
+		zap.String("connection", e.ConnectionID),
+	}
+	if v, ok := l.started.LoadAndDelete(e.RequestID); ok {
+		doc := v.(bson.Raw)
+		if l.params.LogValues {
+			fields = append(fields, zap.String("document", doc.String()))
+		} else {
+			fields = append(fields, zap.String("document", redactMongoCommand(doc)))
+		}
+	}
+	return fields
+}
+
+func (l *mongoCommandLogger) onSucceeded(ctx context.Context, e *event.CommandSucceededEvent) {
+	slow := l.params.SlowThreshold > 0 && e.Duration > l.params.SlowThreshold
+	if !slow && l.params.LogLevel != LOG_LEVEL_INFO {
+		l.started.Delete(e.RequestID)
+		return
+	}
+	zl := LoggerWithScope(ctx, l.logger)
+	if slow && l.params.LogLevel != LOG_LEVEL_ERROR {
+		zl.Warn("[mongo] slow command", l.fields(e.CommandFinishedEvent)...)
+		return
+	}
+	if l.params.LogLevel == LOG_LEVEL_INFO {
+		zl.Info("[mongo] command", l.fields(e.CommandFinishedEvent)...)
+		return
+	}
+	l.started.Delete(e.RequestID)
+}
+
+func (l *mongoCommandLogger) onFailed(ctx context.Context, e *event.CommandFailedEvent) {
+	fields := append(l.fields(e.CommandFinishedEvent), zap.String("error", e.Failure))
+	LoggerWithScope(ctx, l.logger).Error("[mongo] command failed", fields...)
+}
+
+// redactMongoCommand keeps the first element of the command, e.g. {"find": "users"}, and redacts the others.
+func redactMongoCommand(cmd bson.Raw) string {
+	elems, err := cmd.Elements()
+	if err != nil || len(elems) == 0 {
+		return ""
+	}
+	d := redactBSON(cmd)
+	d[0].Value = elems[0].Value()
+	data, err := bson.MarshalExtJSON(d, false, false)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// redactBSON keeps the keys of doc and replaces the values with "?".
+func redactBSON(doc bson.Raw) bson.D {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil
+	}
+	d := make(bson.D, 0, len(elems))
+	for _, e := range elems {
+		d = append(d, bson.E{Key: e.Key(), Value: redactBSONValue(e.Value())})
+	}
+	return d
+}
+
+func redactBSONValue(v bson.RawValue) interface{} {
+	switch v.Type {
+	case bson.TypeEmbeddedDocument:
+		return redactBSON(v.Document())
+	case bson.TypeArray:
+		values, err := v.Array().Values()
+		if err != nil {
+			return "?"
+		}
+		arr := make(bson.A, len(values))
+		for i, e := range values {
+			arr[i] = redactBSONValue(e)
+		}
+		return arr
+	default:
+		return "?"
+	}
+}
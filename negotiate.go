@@ -0,0 +1,71 @@
+package giu
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// GIN_NEGOTIATED_TYPE is the gin context key of the response type negotiated from Accept header.
+var GIN_NEGOTIATED_TYPE = "giu_negotiated_type"
+
+const (
+	MIME_PROTOBUF   = "application/protobuf"
+	MIME_X_PROTOBUF = "application/x-protobuf"
+)
+
+// NewGinMiddlewareNegotiate returns a gin middleware which negotiates json or protobuf responses from Accept header,
+// the negotiated type is set to gin context with key GIN_NEGOTIATED_TYPE and logged by the gin logger.
+func NewGinMiddlewareNegotiate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(GIN_NEGOTIATED_TYPE, negotiateType(c))
+		c.Header("Vary", "Accept")
+		c.Next()
+	}
+}
+
+func negotiateType(c *gin.Context) string {
+	switch t := c.NegotiateFormat(gin.MIMEJSON, MIME_X_PROTOBUF, MIME_PROTOBUF); t {
+	case MIME_X_PROTOBUF, MIME_PROTOBUF:
+		return t
+	default:
+		return gin.MIMEJSON
+	}
+}
+
+// NegotiatedType returns the negotiated response type, it negotiates if the middleware is not used.
+func NegotiatedType(c *gin.Context) string {
+	if t := c.GetString(GIN_NEGOTIATED_TYPE); t != "" {
+		return t
+	}
+	t := negotiateType(c)
+	c.Set(GIN_NEGOTIATED_TYPE, t)
+	return t
+}
+
+// RespondNegotiated writes obj as the negotiated type. Protobuf is only used for proto messages, other
+// values are always written as json. Proto messages are written as json with protojson.
+func RespondNegotiated(c *gin.Context, code int, obj interface{}) {
+	msg, ok := obj.(proto.Message)
+	if !ok {
+		c.JSON(code, obj)
+		return
+	}
+	if t := NegotiatedType(c); t != gin.MIMEJSON {
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			AbortWithErrorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(code, t, data)
+		return
+	}
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		AbortWithErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(code, gin.MIMEJSON+"; charset=utf-8", data)
+}
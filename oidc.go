@@ -0,0 +1,282 @@
+package giu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+type OidcParams struct {
+	// Issuer is the provider url, the endpoints are discovered from "<Issuer>/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute url of the callback handler, e.g. "https://dashboard.example.com/auth/callback".
+	RedirectURL string
+	// Scopes are requested besides "openid", default is profile and email.
+	Scopes []string
+	// UserClaim and TenantClaim are the claims mapped to user and tenant of request scope, default user claim is "email".
+	UserClaim   string
+	TenantClaim string
+	// SessionTTL is the lifetime of sessions, default is 8h.
+	SessionTTL time.Duration
+	// SessionCookie is the cookie name of session id, default is "giu_session".
+	SessionCookie string
+	// SessionPrefix is the redis key prefix of sessions and login states, default is "giu:oidc:".
+	SessionPrefix string
+	// InsecureCookie allows the session cookie over http, only for local development.
+	InsecureCookie bool
+}
+
+var _defaultOidcParams = OidcParams{
+	Scopes:        []string{"profile", "email"},
+	UserClaim:     "email",
+	SessionTTL:    8 * time.Hour,
+	SessionCookie: "giu_session",
+	SessionPrefix: "giu:oidc:",
+}
+
+var (
+	ERR_OIDC_STATE_INVALID = errors.New("oidc state is invalid or expired")
+	ERR_OIDC_NONCE_INVALID = errors.New("oidc nonce mismatch")
+	ERR_OIDC_NO_ID_TOKEN   = errors.New("oidc token response has no id_token")
+	ERR_OIDC_NO_SESSION    = errors.New("oidc session is missing or expired")
+)
+
+// GIN_OIDC_SESSION is the gin context key of the *OidcSession set by the oidc middleware.
+var GIN_OIDC_SESSION = "giu_oidc_session"
+
+// OidcSession is the login session stored in redis.
+type OidcSession struct {
+	Subject string                 `json:"sub"`
+	User    string                 `json:"user"`
+	Tenant  string                 `json:"tenant,omitempty"`
+	Claims  map[string]interface{} `json:"claims"`
+	Expiry  time.Time              `json:"expiry"`
+}
+
+type oidcLoginState struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+}
+
+// OidcRelyingParty implements the auth code flow with PKCE, sessions are stored in redis.
+type OidcRelyingParty struct {
+	params   OidcParams
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	rdb      redis.UniversalClient
+	logger   *zap.Logger
+}
+
+// NewOidcRelyingParty discovers the provider endpoints and creates the relying party.
+func NewOidcRelyingParty(params OidcParams, rdb redis.UniversalClient, zl *zap.Logger) (*OidcRelyingParty, error) {
+	if len(params.Scopes) == 0 {
+		params.Scopes = _defaultOidcParams.Scopes
+	}
+	if params.UserClaim == "" {
+		params.UserClaim = _defaultOidcParams.UserClaim
+	}
+	if params.SessionTTL <= 0 {
+		params.SessionTTL = _defaultOidcParams.SessionTTL
+	}
+	if params.SessionCookie == "" {
+		params.SessionCookie = _defaultOidcParams.SessionCookie
+	}
+	if params.SessionPrefix == "" {
+		params.SessionPrefix = _defaultOidcParams.SessionPrefix
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	provider, err := oidc.NewProvider(context.Background(), params.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	return &OidcRelyingParty{
+		params: params,
+		oauth2: oauth2.Config{
+			ClientID:     params.ClientID,
+			ClientSecret: params.ClientSecret,
+			RedirectURL:  params.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, params.Scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: params.ClientID}),
+		rdb:      rdb,
+		logger:   zl.With(zap.String("module", "oidc")),
+	}, nil
+}
+
+// NewOidcRelyingPartyFromConfig creates the relying party from viper config with key "oidc".
+func NewOidcRelyingPartyFromConfig(config *viper.Viper, rdb redis.UniversalClient, zl *zap.Logger) (*OidcRelyingParty, error) {
+	var params OidcParams
+	if err := config.UnmarshalKey("oidc", &params); err != nil {
+		return nil, err
+	}
+	return NewOidcRelyingParty(params, rdb, zl)
+}
+
+// Mount adds "GET /login", "GET /callback" and "GET /logout" to r, the path of callback must match RedirectURL.
+// Login redirects back to the relative url in query "rd" after the callback.
+func (rp *OidcRelyingParty) Mount(r gin.IRouter) {
+	r.GET("/login", rp.login)
+	r.GET("/callback", rp.callback)
+	r.GET("/logout", rp.logout)
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// safeRedirect only allows relative urls, so login can't be used as an open redirect.
+func safeRedirect(rd string) string {
+	if !strings.HasPrefix(rd, "/") || strings.HasPrefix(rd, "//") || strings.HasPrefix(rd, "/\\") {
+		return "/"
+	}
+	return rd
+}
+
+func (rp *OidcRelyingParty) login(c *gin.Context) {
+	state := randomToken()
+	ls := oidcLoginState{Nonce: randomToken(), Verifier: oauth2.GenerateVerifier(), Redirect: safeRedirect(c.Query("rd"))}
+	data, _ := json.Marshal(ls)
+	if err := rp.rdb.Set(c.Request.Context(), rp.params.SessionPrefix+"state:"+state, data, 10*time.Minute).Err(); err != nil {
+		AbortWithErrorResponse(c, http.StatusServiceUnavailable, err)
+		return
+	}
+	c.Redirect(http.StatusFound, rp.oauth2.AuthCodeURL(state, oidc.Nonce(ls.Nonce), oauth2.S256ChallengeOption(ls.Verifier)))
+}
+
+func (rp *OidcRelyingParty) callback(c *gin.Context) {
+	ctx := c.Request.Context()
+	if e := c.Query("error"); e != "" {
+		AbortWithErrorResponse(c, http.StatusUnauthorized, fmt.Errorf("oidc: %s %s", e, c.Query("error_description")))
+		return
+	}
+	data, err := rp.rdb.GetDel(ctx, rp.params.SessionPrefix+"state:"+c.Query("state")).Bytes()
+	var ls oidcLoginState
+	if err != nil || json.Unmarshal(data, &ls) != nil {
+		AbortWithErrorResponse(c, http.StatusBadRequest, ERR_OIDC_STATE_INVALID)
+		return
+	}
+	token, err := rp.oauth2.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(ls.Verifier))
+	if err != nil {
+		rp.logger.Warn("[oidc] code exchange failed", zap.Error(err))
+		AbortWithErrorResponse(c, http.StatusUnauthorized, err)
+		return
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		AbortWithErrorResponse(c, http.StatusUnauthorized, ERR_OIDC_NO_ID_TOKEN)
+		return
+	}
+	idToken, err := rp.verifier.Verify(ctx, raw)
+	if err != nil {
+		rp.logger.Warn("[oidc] id token verification failed", zap.Error(err))
+		AbortWithErrorResponse(c, http.StatusUnauthorized, err)
+		return
+	}
+	if idToken.Nonce != ls.Nonce {
+		AbortWithErrorResponse(c, http.StatusUnauthorized, ERR_OIDC_NONCE_INVALID)
+		return
+	}
+	session := OidcSession{Subject: idToken.Subject, Expiry: time.Now().Add(rp.params.SessionTTL)}
+	if err := idToken.Claims(&session.Claims); err != nil {
+		AbortWithErrorResponse(c, http.StatusUnauthorized, err)
+		return
+	}
+	session.User, _ = session.Claims[rp.params.UserClaim].(string)
+	if session.User == "" {
+		session.User = idToken.Subject
+	}
+	if rp.params.TenantClaim != "" {
+		session.Tenant, _ = session.Claims[rp.params.TenantClaim].(string)
+	}
+	id := randomToken()
+	data, _ = json.Marshal(session)
+	if err := rp.rdb.Set(ctx, rp.params.SessionPrefix+"session:"+id, data, rp.params.SessionTTL).Err(); err != nil {
+		AbortWithErrorResponse(c, http.StatusServiceUnavailable, err)
+		return
+	}
+	rp.setCookie(c, id, int(rp.params.SessionTTL.Seconds()))
+	rp.logger.Info("[oidc] login", zap.String("user", session.User), zap.String("sub", session.Subject))
+	c.Redirect(http.StatusFound, ls.Redirect)
+}
+
+func (rp *OidcRelyingParty) logout(c *gin.Context) {
+	if id, err := c.Cookie(rp.params.SessionCookie); err == nil {
+		_ = rp.rdb.Del(c.Request.Context(), rp.params.SessionPrefix+"session:"+id).Err()
+	}
+	rp.setCookie(c, "", -1)
+	c.Redirect(http.StatusFound, "/")
+}
+
+func (rp *OidcRelyingParty) setCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(rp.params.SessionCookie, value, maxAge, "/", "", !rp.params.InsecureCookie, true)
+}
+
+// Session returns the session of the request cookie.
+func (rp *OidcRelyingParty) Session(c *gin.Context) (*OidcSession, error) {
+	id, err := c.Cookie(rp.params.SessionCookie)
+	if err != nil || id == "" {
+		return nil, ERR_OIDC_NO_SESSION
+	}
+	data, err := rp.rdb.Get(c.Request.Context(), rp.params.SessionPrefix+"session:"+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ERR_OIDC_NO_SESSION
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session OidcSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Middleware returns a gin middleware which requires a session, the user and tenant claims are set to the
+// request scope and the session is set to gin context with key GIN_OIDC_SESSION. Browser GET requests without
+// session are redirected to loginPath, others get 401.
+func (rp *OidcRelyingParty) Middleware(loginPath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, err := rp.Session(c)
+		switch {
+		case err == nil:
+		case errors.Is(err, ERR_OIDC_NO_SESSION):
+			if c.Request.Method == http.MethodGet && strings.Contains(c.GetHeader("Accept"), "text/html") {
+				c.Redirect(http.StatusFound, loginPath+"?rd="+c.Request.URL.RequestURI())
+				c.Abort()
+				return
+			}
+			AbortWithErrorResponse(c, http.StatusUnauthorized, err)
+			return
+		default:
+			AbortWithErrorResponse(c, http.StatusServiceUnavailable, err)
+			return
+		}
+		scope := GinRequestScope(c)
+		scope.User = session.User
+		scope.Tenant = session.Tenant
+		c.Set(GIN_OIDC_SESSION, session)
+		c.Next()
+	}
+}
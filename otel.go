@@ -0,0 +1,183 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/bridges/otelzap"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type OtelParams struct {
+	// Endpoint is the "host:port" of OTLP/HTTP collector, default is "localhost:4318".
+	Endpoint string
+	Insecure bool
+	Headers  map[string]string
+	// ServiceName is the service.name resource attribute, default is the service label of build info.
+	ServiceName string
+	// Traces, Metrics and Logs enable the providers of each signal.
+	Traces  bool
+	Metrics bool
+	Logs    bool
+	// SampleRatio is the ratio of sampled root spans, default is 1.
+	SampleRatio float64
+	// MetricInterval is the export interval of metrics, default is 30s.
+	MetricInterval time.Duration
+}
+
+var _defaultOtelParams = OtelParams{
+	Endpoint:       "localhost:4318",
+	SampleRatio:    1,
+	MetricInterval: 30 * time.Second,
+}
+
+// OTEL_ENV_EXEMPLAR is the feature flag of experimental exemplars of the metric sdk. When both traces and metrics
+// are enabled, it's turned on unless set explicitly, so the measurements recorded in sampled spans carry their trace ids.
+const OTEL_ENV_EXEMPLAR = "OTEL_GO_X_EXEMPLAR"
+
+// Otel holds the OTLP providers, the enabled ones are also set as the global providers.
+// Providers of disabled signals are nil.
+type Otel struct {
+	TracerProvider *sdktrace.TracerProvider
+	MeterProvider  *sdkmetric.MeterProvider
+	LoggerProvider *sdklog.LoggerProvider
+	serviceName    string
+}
+
+func NewOtel(params OtelParams) (*Otel, error) {
+	if params.Endpoint == "" {
+		params.Endpoint = _defaultOtelParams.Endpoint
+	}
+	if params.SampleRatio <= 0 {
+		params.SampleRatio = _defaultOtelParams.SampleRatio
+	}
+	if params.MetricInterval <= 0 {
+		params.MetricInterval = _defaultOtelParams.MetricInterval
+	}
+	labels := BuildInfoLabels()
+	if params.ServiceName == "" {
+		params.ServiceName = labels["service"]
+	}
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(
+			attribute.String("service.name", params.ServiceName),
+			attribute.String("service.version", labels["version"]),
+		))
+	if err != nil {
+		return nil, err
+	}
+	o := &Otel{serviceName: params.ServiceName}
+	if params.Traces {
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(params.Endpoint), otlptracehttp.WithHeaders(params.Headers)}
+		if params.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		o.TracerProvider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exp),
+			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(params.SampleRatio))))
+		otel.SetTracerProvider(o.TracerProvider)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	}
+	if params.Metrics {
+		if params.Traces {
+			if _, ok := os.LookupEnv(OTEL_ENV_EXEMPLAR); !ok {
+				_ = os.Setenv(OTEL_ENV_EXEMPLAR, "true")
+			}
+		}
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(params.Endpoint), otlpmetrichttp.WithHeaders(params.Headers)}
+		if params.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		exp, err := otlpmetrichttp.New(ctx, opts...)
+		if err != nil {
+			return nil, errors.Join(err, o.Shutdown())
+		}
+		o.MeterProvider = sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp, sdkmetric.WithInterval(params.MetricInterval))),
+			sdkmetric.WithResource(res))
+		otel.SetMeterProvider(o.MeterProvider)
+	}
+	if params.Logs {
+		opts := []otlploghttp.Option{otlploghttp.WithEndpoint(params.Endpoint), otlploghttp.WithHeaders(params.Headers)}
+		if params.Insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		exp, err := otlploghttp.New(ctx, opts...)
+		if err != nil {
+			return nil, errors.Join(err, o.Shutdown())
+		}
+		o.LoggerProvider = sdklog.NewLoggerProvider(
+			sdklog.WithProcessor(sdklog.NewBatchProcessor(exp)),
+			sdklog.WithResource(res))
+		global.SetLoggerProvider(o.LoggerProvider)
+	}
+	return o, nil
+}
+
+// NewOtelFromConfig creates the providers from viper config with key "otel".
+func NewOtelFromConfig(config *viper.Viper) (*Otel, error) {
+	var params OtelParams
+	if err := config.UnmarshalKey("otel", &params); err != nil {
+		return nil, err
+	}
+	return NewOtel(params)
+}
+
+// ZapCore returns a zap core exporting entries as OTLP logs, it's a no-op core if logs are disabled.
+func (o *Otel) ZapCore() zapcore.Core {
+	if o.LoggerProvider == nil {
+		return zapcore.NewNopCore()
+	}
+	return otelzap.NewCore(o.serviceName, otelzap.WithLoggerProvider(o.LoggerProvider))
+}
+
+// WrapZap returns a logger writing to both zl and the OTLP log exporter.
+func (o *Otel) WrapZap(zl *zap.Logger) *zap.Logger {
+	if o.LoggerProvider == nil {
+		return zl
+	}
+	return zl.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, o.ZapCore())
+	}))
+}
+
+// Shutdown flushes and stops the providers.
+func (o *Otel) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var errs []error
+	if o.TracerProvider != nil {
+		errs = append(errs, o.TracerProvider.Shutdown(ctx))
+	}
+	if o.MeterProvider != nil {
+		errs = append(errs, o.MeterProvider.Shutdown(ctx))
+	}
+	if o.LoggerProvider != nil {
+		errs = append(errs, o.LoggerProvider.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,159 @@
+package giu
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// OTEL_INSTRUMENTATION is the instrumentation scope name of giu tracers and meters.
+const OTEL_INSTRUMENTATION = "github.com/pkoukk/go-init-utils"
+
+// NewGinMiddlewareOtel returns a gin middleware which starts a server span from the propagated context and
+// records the request duration histogram. The duration is recorded with the span context, so it carries
+// trace id exemplars when traces are enabled too, see OTEL_ENV_EXEMPLAR.
+func NewGinMiddlewareOtel() gin.HandlerFunc {
+	tracer := otel.Tracer(OTEL_INSTRUMENTATION)
+	duration, _ := otel.Meter(OTEL_INSTRUMENTATION).Float64Histogram("http.server.request.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of HTTP server requests."))
+	return func(c *gin.Context) {
+		start := time.Now()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path)))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		// the requests canceled by clients are recorded as 499, not server errors
+		status := ginResponseStatus(c)
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.Int("http.response.status_code", status)))
+	}
+}
+
+// OtelPlugin traces gorm statements as child spans of the statement context, and records the statement
+// duration histogram with the span context, so it carries trace id exemplars when traces are enabled too,
+// see OTEL_ENV_EXEMPLAR. Use db.WithContext(ctx), or the repositories, for the spans to join the request trace.
+type OtelPlugin struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+}
+
+const (
+	otelStartKey = "giu:otel_start"
+	otelSpanKey  = "giu:otel_span"
+)
+
+func (*OtelPlugin) Name() string {
+	return "giu:otel"
+}
+
+func (p *OtelPlugin) Initialize(db *gorm.DB) error {
+	var err error
+	p.tracer = otel.Tracer(OTEL_INSTRUMENTATION)
+	p.duration, err = otel.Meter(OTEL_INSTRUMENTATION).Float64Histogram("db.client.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of database client operations."))
+	if err != nil {
+		return err
+	}
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register(p.Name()+"_before_query", p.before("select")); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:after_query").Register(p.Name()+"_after_query", p.after("select")); err != nil {
+		return err
+	}
+	if err := cb.Create().Before("gorm:create").Register(p.Name()+"_before_create", p.before("insert")); err != nil {
+		return err
+	}
+	if err := cb.Create().After("gorm:create").Register(p.Name()+"_after_create", p.after("insert")); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register(p.Name()+"_before_update", p.before("update")); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register(p.Name()+"_after_update", p.after("update")); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register(p.Name()+"_before_delete", p.before("delete")); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register(p.Name()+"_after_delete", p.after("delete")); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register(p.Name()+"_before_row", p.before("row")); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register(p.Name()+"_after_row", p.after("row")); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register(p.Name()+"_before_raw", p.before("raw")); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register(p.Name()+"_after_raw", p.after("raw"))
+}
+
+func (p *OtelPlugin) before(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx, span := p.tracer.Start(tx.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", tx.Dialector.Name()),
+				attribute.String("db.operation.name", operation),
+				attribute.String("db.collection.name", tx.Statement.Table)))
+		tx.Statement.Context = ctx
+		tx.InstanceSet(otelSpanKey, span)
+		tx.InstanceSet(otelStartKey, time.Now())
+	}
+}
+
+func (p *OtelPlugin) after(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		if v, ok := tx.InstanceGet(otelSpanKey); ok {
+			span := v.(trace.Span)
+			span.SetAttributes(
+				attribute.String("db.query.text", tx.Statement.SQL.String()),
+				attribute.Int64("db.rows_affected", tx.Statement.RowsAffected))
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				span.RecordError(tx.Error)
+				span.SetStatus(codes.Error, tx.Error.Error())
+			}
+			span.End()
+		}
+		v, ok := tx.InstanceGet(otelStartKey)
+		if !ok {
+			return
+		}
+		p.duration.Record(tx.Statement.Context, time.Since(v.(time.Time)).Seconds(), metric.WithAttributes(
+			attribute.String("db.system", tx.Dialector.Name()),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.collection.name", tx.Statement.Table),
+			attribute.Bool("error", tx.Error != nil)))
+	}
+}
@@ -0,0 +1,127 @@
+package giu
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// OutboxMessage is a message written in the business transaction and relayed to a redis stream after commit,
+// migrate it with db.AutoMigrate(&OutboxMessage{}).
+type OutboxMessage struct {
+	ID          uint   `gorm:"primaryKey"`
+	Topic       string `gorm:"size:128"`
+	Payload     string
+	CreatedAt   time.Time
+	PublishedAt *time.Time `gorm:"index"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "giu_outbox"
+}
+
+// OutboxPublish writes payload as json to the outbox, in the transaction of ctx if there's one (see WithTx),
+// so the message is published only if the transaction commits.
+func OutboxPublish(ctx context.Context, db *gorm.DB, topic string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if tx, ok := TxFromContext(ctx); ok {
+		db = tx
+	}
+	return db.WithContext(ctx).Create(&OutboxMessage{Topic: topic, Payload: string(data)}).Error
+}
+
+type OutboxRelayParams struct {
+	// Interval is the polling interval of unpublished messages, default is 1s.
+	Interval time.Duration
+	// BatchSize is the max messages of each poll, default is 100.
+	BatchSize int
+}
+
+var _defaultOutboxRelayParams = OutboxRelayParams{
+	Interval:  time.Second,
+	BatchSize: 100,
+}
+
+// OutboxRelay adds the outbox messages to the redis streams named by their topics, the message is in field
+// "payload". Messages are delivered at least once, run a single relay or make consumers idempotent.
+type OutboxRelay struct {
+	db     *gorm.DB
+	rdb    redis.UniversalClient
+	params OutboxRelayParams
+	logger *zap.Logger
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewOutboxRelay(db *gorm.DB, rdb redis.UniversalClient, params OutboxRelayParams, zl *zap.Logger) *OutboxRelay {
+	if params.Interval <= 0 {
+		params.Interval = _defaultOutboxRelayParams.Interval
+	}
+	if params.BatchSize <= 0 {
+		params.BatchSize = _defaultOutboxRelayParams.BatchSize
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &OutboxRelay{db: db, rdb: rdb, params: params, logger: zl.With(zap.String("module", "outbox"))}
+}
+
+// Start starts relaying in a new goroutine.
+func (r *OutboxRelay) Start(_ context.Context) error {
+	runCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.wg.Add(1)
+	go r.run(runCtx)
+	return nil
+}
+
+// Shutdown stops relaying and waits for the relaying batch.
+func (r *OutboxRelay) Shutdown() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+func (r *OutboxRelay) run(ctx context.Context) {
+	defer r.wg.Done()
+	for ctx.Err() == nil {
+		n, err := r.Relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("[outbox] relay failed", zap.Error(err))
+		}
+		// keep draining a backlog without waiting
+		if err != nil || n < r.params.BatchSize {
+			sleepContext(ctx, r.params.Interval)
+		}
+	}
+}
+
+// Relay publishes a batch of unpublished messages, it returns the number of published messages.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	var msgs []OutboxMessage
+	err := r.db.WithContext(ctx).Where("published_at IS NULL").Order("id").Limit(r.params.BatchSize).Find(&msgs).Error
+	if err != nil {
+		return 0, err
+	}
+	for i, msg := range msgs {
+		err := r.rdb.XAdd(ctx, &redis.XAddArgs{Stream: msg.Topic, Values: map[string]interface{}{"payload": msg.Payload}}).Err()
+		if err != nil {
+			return i, err
+		}
+		now := time.Now()
+		if err := r.db.WithContext(ctx).Model(&msg).Update("published_at", &now).Error; err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
@@ -0,0 +1,219 @@
+package giu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PaginatorParams struct {
+	// DefaultSize is the page size when it's not in query, default is 20.
+	DefaultSize int
+	// MaxSize is the max page size, default is 100.
+	MaxSize int
+	// MaxPage is the max page number, larger pages are capped to it, so the offset can't overflow or scan the
+	// whole table, default is 10000.
+	MaxPage int
+	// DefaultSort is used when sort is not in query, e.g. "-id".
+	DefaultSort string
+	// SortFields is the allowlist of sortable columns.
+	SortFields []string
+	// FilterFields is the allowlist of filterable columns.
+	FilterFields []string
+}
+
+var _defaultPaginatorParams = PaginatorParams{
+	DefaultSize: 20,
+	MaxSize:     100,
+	MaxPage:     10000,
+}
+
+const (
+	FILTER_OP_EQ   = "eq"
+	FILTER_OP_NE   = "ne"
+	FILTER_OP_GT   = "gt"
+	FILTER_OP_GTE  = "gte"
+	FILTER_OP_LT   = "lt"
+	FILTER_OP_LTE  = "lte"
+	FILTER_OP_LIKE = "like"
+	FILTER_OP_IN   = "in"
+)
+
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// Page is the parsed pagination, sorting and filtering of a request.
+type Page struct {
+	Page    int
+	Size    int
+	Sorts   []Sort
+	Filters []Filter
+}
+
+// PagedResponse is the standard paged response.
+type PagedResponse[T any] struct {
+	Items      []T   `json:"items"`
+	Page       int   `json:"page"`
+	Size       int   `json:"size"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+// Paginator parses query params like "?page=2&size=20&sort=-created_at,name&filter=status:eq:active".
+type Paginator struct {
+	params  PaginatorParams
+	sorts   map[string]bool
+	filters map[string]bool
+}
+
+func NewPaginator(params PaginatorParams) *Paginator {
+	if params.DefaultSize <= 0 {
+		params.DefaultSize = _defaultPaginatorParams.DefaultSize
+	}
+	if params.MaxSize <= 0 {
+		params.MaxSize = _defaultPaginatorParams.MaxSize
+	}
+	if params.MaxPage <= 0 {
+		params.MaxPage = _defaultPaginatorParams.MaxPage
+	}
+	p := &Paginator{params: params, sorts: make(map[string]bool), filters: make(map[string]bool)}
+	for _, f := range params.SortFields {
+		p.sorts[f] = true
+	}
+	for _, f := range params.FilterFields {
+		p.filters[f] = true
+	}
+	return p
+}
+
+func DefaultPaginator() *Paginator {
+	return NewPaginator(_defaultPaginatorParams)
+}
+
+// Parse parses the query params of the request, fields out of allowlists are rejected.
+func (p *Paginator) Parse(c *gin.Context) (*Page, error) {
+	page := &Page{Page: 1, Size: p.params.DefaultSize}
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid page: %s", v)
+		}
+		if n > p.params.MaxPage {
+			n = p.params.MaxPage
+		}
+		page.Page = n
+	}
+	if v := c.Query("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid size: %s", v)
+		}
+		if n > p.params.MaxSize {
+			n = p.params.MaxSize
+		}
+		page.Size = n
+	}
+	sort := c.DefaultQuery("sort", p.params.DefaultSort)
+	for _, s := range strings.Split(sort, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		field, desc := strings.TrimPrefix(s, "-"), strings.HasPrefix(s, "-")
+		if !p.sorts[field] {
+			return nil, fmt.Errorf("unsupported sort field: %s", field)
+		}
+		page.Sorts = append(page.Sorts, Sort{Field: field, Desc: desc})
+	}
+	for _, f := range c.QueryArray("filter") {
+		parts := strings.SplitN(f, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid filter: %s", f)
+		}
+		if !p.filters[parts[0]] {
+			return nil, fmt.Errorf("unsupported filter field: %s", parts[0])
+		}
+		filter := Filter{Field: parts[0], Op: parts[1], Value: parts[2]}
+		if _, err := filter.expression(); err != nil {
+			return nil, err
+		}
+		page.Filters = append(page.Filters, filter)
+	}
+	return page, nil
+}
+
+func (f Filter) expression() (clause.Expression, error) {
+	column := clause.Column{Name: f.Field}
+	switch f.Op {
+	case FILTER_OP_EQ:
+		return clause.Eq{Column: column, Value: f.Value}, nil
+	case FILTER_OP_NE:
+		return clause.Neq{Column: column, Value: f.Value}, nil
+	case FILTER_OP_GT:
+		return clause.Gt{Column: column, Value: f.Value}, nil
+	case FILTER_OP_GTE:
+		return clause.Gte{Column: column, Value: f.Value}, nil
+	case FILTER_OP_LT:
+		return clause.Lt{Column: column, Value: f.Value}, nil
+	case FILTER_OP_LTE:
+		return clause.Lte{Column: column, Value: f.Value}, nil
+	case FILTER_OP_LIKE:
+		return clause.Like{Column: column, Value: "%" + f.Value + "%"}, nil
+	case FILTER_OP_IN:
+		values := make([]interface{}, 0)
+		for _, v := range strings.Split(f.Value, ",") {
+			values = append(values, v)
+		}
+		return clause.IN{Column: column, Values: values}, nil
+	default:
+		return nil, fmt.Errorf("unsupported filter operator: %s", f.Op)
+	}
+}
+
+// Filter applies the filters to db.
+func (pg *Page) Filter(db *gorm.DB) *gorm.DB {
+	for _, f := range pg.Filters {
+		if expr, err := f.expression(); err == nil {
+			db = db.Where(expr)
+		}
+	}
+	return db
+}
+
+// Apply applies the filters, sorts, limit and offset to db, it can be used as a gorm scope.
+func (pg *Page) Apply(db *gorm.DB) *gorm.DB {
+	db = pg.Filter(db)
+	for _, s := range pg.Sorts {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: s.Field}, Desc: s.Desc})
+	}
+	return db.Limit(pg.Size).Offset((pg.Page - 1) * pg.Size)
+}
+
+// Paginate counts and finds the items of the page in db.
+func Paginate[T any](db *gorm.DB, page *Page) (PagedResponse[T], error) {
+	resp := PagedResponse[T]{Items: make([]T, 0), Page: page.Page, Size: page.Size}
+	// db may be a chained query, use new sessions so the count and the find don't share conditions
+	if err := db.Session(&gorm.Session{}).Model(new(T)).Scopes(page.Filter).Count(&resp.Total).Error; err != nil {
+		return resp, err
+	}
+	if resp.Total == 0 {
+		return resp, nil
+	}
+	resp.TotalPages = int((resp.Total + int64(page.Size) - 1) / int64(page.Size))
+	if err := db.Session(&gorm.Session{}).Scopes(page.Apply).Find(&resp.Items).Error; err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
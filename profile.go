@@ -0,0 +1,133 @@
+package giu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type ProfileParams struct {
+	// Profiles are the runtime/pprof profiles captured, "cpu" captures a cpu profile of CPUDuration,
+	// default is heap, goroutine and cpu.
+	Profiles []string
+	// CPUDuration is the duration of cpu profile, default is 10s.
+	CPUDuration time.Duration
+	// Prefix is the name prefix of the uploaded profiles, default is the hostname.
+	Prefix string
+}
+
+var _defaultProfileParams = ProfileParams{
+	Profiles:    []string{"heap", "goroutine", "cpu"},
+	CPUDuration: 10 * time.Second,
+}
+
+var ERR_PROFILE_NOT_FOUND = errors.New("profile not found")
+
+// ProfileStorage stores the captured profiles.
+type ProfileStorage interface {
+	Put(ctx context.Context, name string, data []byte) error
+}
+
+// DirProfileStorage stores profiles as files under a local directory, e.g. a mounted volume.
+type DirProfileStorage string
+
+func (d DirProfileStorage) Put(_ context.Context, name string, data []byte) error {
+	path := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Profiler captures profiles on demand and uploads them to storage.
+type Profiler struct {
+	params  ProfileParams
+	storage ProfileStorage
+	logger  *zap.Logger
+}
+
+func NewProfiler(params ProfileParams, storage ProfileStorage, zl *zap.Logger) *Profiler {
+	if len(params.Profiles) == 0 {
+		params.Profiles = _defaultProfileParams.Profiles
+	}
+	if params.CPUDuration <= 0 {
+		params.CPUDuration = _defaultProfileParams.CPUDuration
+	}
+	if params.Prefix == "" {
+		params.Prefix, _ = os.Hostname()
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &Profiler{params: params, storage: storage, logger: zl.With(zap.String("module", "profile"))}
+}
+
+// Snapshot captures the profiles and uploads them with timestamped names like
+// "<prefix>/20060102T150405Z-heap.pb.gz", it returns the names of uploaded profiles.
+func (p *Profiler) Snapshot(ctx context.Context) ([]string, error) {
+	ts := time.Now().UTC().Format("20060102T150405Z")
+	var names []string
+	var errs []error
+	for _, profile := range p.params.Profiles {
+		data, err := p.capture(ctx, profile)
+		if err == nil {
+			name := fmt.Sprintf("%s/%s-%s.pb.gz", p.params.Prefix, ts, profile)
+			if err = p.storage.Put(ctx, name, data); err == nil {
+				names = append(names, name)
+				continue
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", profile, err))
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		p.logger.Error("[profile] snapshot failed", zap.Strings("uploaded", names), zap.Error(err))
+	} else {
+		p.logger.Info("[profile] snapshot uploaded", zap.Strings("uploaded", names))
+	}
+	return names, err
+}
+
+func (p *Profiler) capture(ctx context.Context, profile string) ([]byte, error) {
+	var buf bytes.Buffer
+	if profile != "cpu" {
+		prof := pprof.Lookup(profile)
+		if prof == nil {
+			return nil, ERR_PROFILE_NOT_FOUND
+		}
+		if err := prof.WriteTo(&buf, 0); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return nil, err
+	}
+	sleepContext(ctx, p.params.CPUDuration)
+	pprof.StopCPUProfile()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Mount adds "POST /profile/snapshot" to the admin group, it responds the names of uploaded profiles.
+func (p *Profiler) Mount(admin *gin.RouterGroup) {
+	admin.POST("/profile/snapshot", func(c *gin.Context) {
+		names, err := p.Snapshot(c.Request.Context())
+		if err != nil {
+			AbortWithErrorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"profiles": names})
+	})
+}
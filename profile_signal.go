@@ -0,0 +1,31 @@
+//go:build !windows
+
+package giu
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleSignal takes a snapshot on SIGUSR1 until the returned function is called.
+func (p *Profiler) HandleSignal() func() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				_, _ = p.Snapshot(context.Background())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
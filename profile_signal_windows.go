@@ -0,0 +1,9 @@
+//go:build windows
+
+package giu
+
+// HandleSignal is a no-op on windows, use the admin endpoint instead.
+func (p *Profiler) HandleSignal() func() {
+	p.logger.Warn("[profile] signal snapshot is not supported on windows")
+	return func() {}
+}
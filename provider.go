@@ -1,28 +1,191 @@
 package giu
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 )
 
 type Provider[T any] interface {
 	Add(name string, d T, isDefault ...bool)
+	// TryAdd adds the item if the name doesn't exist, otherwise it returns an error wrapping ERR_PROVIDER_ITEM_EXISTS.
+	TryAdd(name string, d T, isDefault ...bool) error
+	// Set adds or replaces the item of name.
+	Set(name string, d T, isDefault ...bool)
+	// AddAlias makes the item of target resolvable as alias too, an item added later with the alias name takes
+	// the place of the alias.
+	AddAlias(alias, target string) error
+	// Replace adds or replaces the item of name atomically, it returns the replaced item and true if it existed.
+	Replace(name string, d T) (T, bool)
 	Get(name string) (T, bool)
+	// GetE returns the item of name, or an error wrapping ERR_PROVIDER_ITEM_NOT_FOUND with the name.
+	GetE(name string) (T, error)
+	// MustGet returns the item of name, it panics with the error of GetE.
+	MustGet(name string) T
 	Default() T
 	SetDefault(name string) bool
+	// Remove removes the item of name and closes it, if it's the default, the first remaining item by name
+	// becomes the default.
+	Remove(name string) error
+	// Keys returns the sorted names of the items, the lazy items not built yet are not included.
+	Keys() []string
+	// Range calls fn for each item in the order of Keys until fn returns false, so the lazy items not built yet
+	// are skipped.
+	Range(fn func(name string, v T) bool)
+	// Wrap decorates the current items and the items added or built later with fn.
+	Wrap(fn func(name string, item T) T)
+	// Snapshot returns a copy of the built items, the lazy items not built yet are not included.
+	Snapshot() map[string]T
+	// CloneWith returns a copy of the provider of the same kind with overrides added or replaced, e.g. to swap some
+	// items with fakes in tests. The items are shared, the clone never closes them.
+	CloneWith(overrides map[string]T) Provider[T]
 	Shutdown() error
+	// ShutdownContext closes every item even if some fail and joins the errors, the items not closed when ctx is
+	// done are abandoned.
+	ShutdownContext(ctx context.Context) error
 }
 
+// ContextProvider is a provider which checks the liveness of an item before returning it.
+type ContextProvider[T any] interface {
+	Provider[T]
+	// GetContext returns the item of name if a ping within PROVIDER_PING_TIMEOUT succeeds. If the ping fails and
+	// the provider can rebuild the item, it's replaced by a new one.
+	GetContext(ctx context.Context, name string) (T, error)
+}
+
+var (
+	ERR_PROVIDER_ITEM_NOT_FOUND = errors.New("provider item not found")
+	ERR_PROVIDER_ITEM_EXISTS    = errors.New("provider item already exists")
+)
+
+// PROVIDER_PING_TIMEOUT is the deadline of the ping of GetContext.
+var PROVIDER_PING_TIMEOUT = time.Second
+
 type GiuProvider[T any] struct {
-	lock      sync.RWMutex
+	// lock serializes the changes, reads take the state without locking.
+	lock     sync.Mutex
+	state    atomic.Pointer[providerState[T]]
+	group    singleflight.Group
+	wrappers []func(name string, item T) T
+	hooks    []ProviderHooks[T]
+	// borrowed is set for the clones of CloneWith, the items are owned by the provider cloned from and not closed.
+	borrowed bool
+}
+
+// providerState is an immutable snapshot of the items, a change stores a modified copy of it, so the hot Get and
+// Default path is lock free.
+type providerState[T any] struct {
 	d         T
+	dName     string
 	container map[string]T
+	// lazy is the factories of the items not built yet, see AddLazy.
+	lazy     map[string]func() (T, error)
+	failover []string
+	healthy  ProviderHealthFunc[T]
+	// aliases maps the alias names to the item names, see AddAlias.
+	aliases map[string]string
+}
+
+func (s *providerState[T]) clone() *providerState[T] {
+	next := *s
+	next.container = make(map[string]T, len(s.container)+1)
+	for k, v := range s.container {
+		next.container[k] = v
+	}
+	next.lazy = make(map[string]func() (T, error), len(s.lazy))
+	for k, v := range s.lazy {
+		next.lazy[k] = v
+	}
+	next.aliases = make(map[string]string, len(s.aliases))
+	for k, v := range s.aliases {
+		next.aliases[k] = v
+	}
+	return &next
 }
 
+// resolve returns the item name of an alias, other names are returned as is.
+func (s *providerState[T]) resolve(name string) string {
+	if _, ok := s.container[name]; ok {
+		return name
+	}
+	if _, ok := s.lazy[name]; ok {
+		return name
+	}
+	if target, ok := s.aliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+// promoteDefault makes the first item by name the default, it reports whether there's an item left.
+func (s *providerState[T]) promoteDefault() bool {
+	var zero T
+	s.d, s.dName = zero, ""
+	names := make([]string, 0, len(s.container)+len(s.lazy))
+	for name := range s.container {
+		names = append(names, name)
+	}
+	for name := range s.lazy {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return false
+	}
+	sort.Strings(names)
+	// a lazy default is built by its first Get
+	s.d, s.dName = s.container[names[0]], names[0]
+	return true
+}
+
+// snapshot returns the current state, it must not be modified.
+func (p *GiuProvider[T]) snapshot() *providerState[T] {
+	if st := p.state.Load(); st != nil {
+		return st
+	}
+	return &providerState[T]{}
+}
+
+// update stores the state modified by fn, the caller must hold the lock.
+func (p *GiuProvider[T]) update(fn func(st *providerState[T])) {
+	next := p.snapshot().clone()
+	fn(next)
+	p.state.Store(next)
+}
+
+// ProviderHooks are called on the lifecycle events of provider items, nil hooks are skipped. They're called
+// after the provider is unlocked, so they can call the provider.
+type ProviderHooks[T any] struct {
+	// OnAdd is called when an item is added or replaced, including lazy items when they're built.
+	OnAdd func(name string, v T)
+	// OnRemove is called after an item is removed and closed, v is the zero value for lazy items not built yet.
+	OnRemove func(name string, v T)
+	// OnSetDefault is called when SetDefault succeeds.
+	OnSetDefault func(name string, v T)
+	// BeforeShutdown and AfterShutdown are called around the close of each item in ShutdownContext.
+	BeforeShutdown func(name string, v T)
+	AfterShutdown  func(name string, v T, err error)
+}
+
+// ProviderHealthFunc reports whether an item is healthy, it's called by Default, so it should not block.
+type ProviderHealthFunc[T any] func(name string, v T) bool
+
+// PROVIDER_HEALTH_INTERVAL is the ping interval of the health funcs created by the provider constructors.
+var PROVIDER_HEALTH_INTERVAL = 5 * time.Second
+
 func MapToSet[T any](m map[string]T) []Set[T] {
 	var s []Set[T]
 	for k, v := range m {
@@ -43,9 +206,8 @@ func NewProvider[T any](items ...map[string]T) Provider[T] {
 
 // NewGiuProvider creates a generic provider, if items is not empty, the first item will be set as default
 func NewGiuProvider[T any](items ...map[string]T) *GiuProvider[T] {
-	g := &GiuProvider[T]{
-		lock:      sync.RWMutex{},
-		container: make(map[string]T)}
+	g := &GiuProvider[T]{}
+	g.state.Store(&providerState[T]{container: make(map[string]T)})
 	if len(items) > 0 {
 		for k, v := range items[0] {
 			g.Add(k, v)
@@ -54,35 +216,96 @@ func NewGiuProvider[T any](items ...map[string]T) *GiuProvider[T] {
 	return g
 }
 
-// NewGiuProviderWithLogger creates a generic provider with item init function and the params used in the init function
-func NewGiuProviderFromParams[T any, U any](newFunc func(U) T, params map[string]U) *GiuProvider[T] {
-	itemMap := make(map[string]T)
+// PROVIDER_BUILD_CONCURRENCY is the max items built concurrently by the FromParams constructors, 0 or negative
+// means unlimited.
+var PROVIDER_BUILD_CONCURRENCY = 8
+
+// buildItems builds the items of params concurrently, at most PROVIDER_BUILD_CONCURRENCY at a time.
+// All items are built even if some fail, the errors are joined in name order. On errors, the built items are
+// closed with closeFunc, or Close if closeFunc is nil and the item is an io.Closer.
+func buildItems[T any, U any](params map[string]U, newFunc func(U) (T, error), closeFunc func(T) error) (map[string]T, error) {
+	var lock sync.Mutex
+	itemMap := make(map[string]T, len(params))
+	errMap := make(map[string]error)
+	var g errgroup.Group
+	if PROVIDER_BUILD_CONCURRENCY > 0 {
+		g.SetLimit(PROVIDER_BUILD_CONCURRENCY)
+	}
+	for k, v := range params {
+		k, v := k, v
+		g.Go(func() error {
+			item, err := newFunc(v)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errMap[k] = err
+				return nil
+			}
+			itemMap[k] = item
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if len(errMap) == 0 {
+		return itemMap, nil
+	}
+	names := make([]string, 0, len(errMap))
+	for name := range errMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := make([]error, 0, len(names))
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("%s: %w", name, errMap[name]))
+	}
+	for _, item := range itemMap {
+		if closeFunc != nil {
+			_ = closeFunc(item)
+		} else if c, ok := any(item).(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// NewLazyGiuProviderFromParams creates a generic provider which builds each item on its first Get with the init
+// function and the params, so the startup isn't slowed down by items which may not be used.
+func NewLazyGiuProviderFromParams[T any, U any](newFunc func(U) (T, error), params map[string]U) *GiuProvider[T] {
+	g := NewGiuProvider[T]()
 	for k, v := range params {
-		itemMap[k] = newFunc(v)
+		v := v
+		g.AddLazy(k, func() (T, error) {
+			return newFunc(v)
+		})
 	}
+	return g
+}
+
+// NewGiuProviderWithLogger creates a generic provider with item init function and the params used in the init function
+func NewGiuProviderFromParams[T any, U any](newFunc func(U) T, params map[string]U) *GiuProvider[T] {
+	itemMap, _ := buildItems(params, func(u U) (T, error) {
+		return newFunc(u), nil
+	}, nil)
 	return NewGiuProvider(itemMap)
 }
 
 // NewGiuProviderWithLogger creates a generic provider with item init function and the params used in the init function.
 // The item needs a zap logger to init, so the logger is also passed in.
 func NewGiuProviderWithLoggerFromParams[T any, U any](newFunc func(U, *zap.Logger) T, params map[string]U, logger *zap.Logger) *GiuProvider[T] {
-	itemMap := make(map[string]T)
-	for k, v := range params {
-		itemMap[k] = newFunc(v, logger)
-	}
+	itemMap, _ := buildItems(params, func(u U) (T, error) {
+		return newFunc(u, logger), nil
+	}, nil)
 	return NewGiuProvider(itemMap)
 }
 
 // NewGiuProviderWithLogger creates a generic provider with item init function and the params used in the init function.
 // The init function needs a logger and it may return an error.
 func NewGiuProviderWithLoggerFromParamsError[T any, U any](newFunc func(U, *zap.Logger) (T, error), params map[string]U, logger *zap.Logger) (*GiuProvider[T], error) {
-	itemMap := make(map[string]T)
-	for k, v := range params {
-		item, err := newFunc(v, logger)
-		if err != nil {
-			return nil, err
-		}
-		itemMap[k] = item
+	itemMap, err := buildItems(params, func(u U) (T, error) {
+		return newFunc(u, logger)
+	}, nil)
+	if err != nil {
+		return nil, err
 	}
 	return NewGiuProvider(itemMap), nil
 }
@@ -90,13 +313,9 @@ func NewGiuProviderWithLoggerFromParamsError[T any, U any](newFunc func(U, *zap.
 // NewGiuProviderWithLogger creates a generic provider with item init function and the params used in the init function.
 // The init function may return an error.
 func NewGiuProviderFromParamsError[T any, U any](newFunc func(U) (T, error), params map[string]U) (*GiuProvider[T], error) {
-	itemMap := make(map[string]T)
-	for k, v := range params {
-		item, err := newFunc(v)
-		if err != nil {
-			return nil, err
-		}
-		itemMap[k] = item
+	itemMap, err := buildItems(params, newFunc, nil)
+	if err != nil {
+		return nil, err
 	}
 	return NewGiuProvider(itemMap), nil
 }
@@ -140,44 +359,449 @@ func NewGiuProviderWithLoggerFromConfigError[T any, U any](config *viper.Viper,
 	return NewGiuProviderWithLoggerFromParamsError[T, U](newFunc, params, logger)
 }
 
-// Add adds a value to the generic provider
+// Add adds a value to the generic provider, an item of the same name is replaced silently, use TryAdd to
+// detect collisions or Set to replace on purpose.
 func (p *GiuProvider[T]) Add(name string, d T, isDefault ...bool) {
+	_, _, _ = p.add(name, d, true, isDefault...)
+}
+
+// TryAdd adds a value to the generic provider, if the name exists, it returns an error wrapping
+// ERR_PROVIDER_ITEM_EXISTS and the provider is not changed.
+func (p *GiuProvider[T]) TryAdd(name string, d T, isDefault ...bool) error {
+	_, _, err := p.add(name, d, false, isDefault...)
+	return err
+}
+
+// Set adds or replaces the value of name, the replaced item is not closed.
+func (p *GiuProvider[T]) Set(name string, d T, isDefault ...bool) {
+	_, _, _ = p.add(name, d, true, isDefault...)
+}
+
+// Replace adds or replaces the value of name atomically, and returns the replaced item so the caller can close
+// it when it's drained. The concurrent Gets return either the old or the new item.
+func (p *GiuProvider[T]) Replace(name string, d T) (T, bool) {
+	old, existed, _ := p.add(name, d, true)
+	return old, existed
+}
+
+func (p *GiuProvider[T]) add(name string, d T, replace bool, isDefault ...bool) (T, bool, error) {
+	p.lock.Lock()
+	cur := p.snapshot()
+	old, exists := cur.container[name]
+	_, lazy := cur.lazy[name]
+	if !replace && (exists || lazy) {
+		p.lock.Unlock()
+		return old, exists, fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_EXISTS, name)
+	}
+	d = p.wrapLocked(name, d)
+	p.update(func(st *providerState[T]) {
+		if (len(isDefault) > 0 && isDefault[0]) || st.dName == name {
+			st.d, st.dName = d, name
+		}
+		if len(st.container) == 0 && len(st.lazy) == 0 {
+			st.d, st.dName = d, name
+		}
+		delete(st.lazy, name)
+		delete(st.aliases, name)
+		st.container[name] = d
+	})
+	hooks := p.hooks
+	p.lock.Unlock()
+	for _, h := range hooks {
+		if h.OnAdd != nil {
+			h.OnAdd(name, d)
+		}
+	}
+	return old, exists, nil
+}
+
+// AddAlias makes the item of target resolvable as alias too, e.g. "orders" and "billing" sharing a database until
+// they're split. Get, GetE, GetContext, SetDefault and Remove accept aliases, Remove of an alias removes only the
+// alias, and the aliases of a removed item are removed too. Keys and Range don't include aliases, so the item is
+// closed once. It returns an error wrapping ERR_PROVIDER_ITEM_NOT_FOUND if target doesn't exist, or
+// ERR_PROVIDER_ITEM_EXISTS if alias is an item.
+func (p *GiuProvider[T]) AddAlias(alias, target string) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	if len(isDefault) > 0 && isDefault[0] {
-		p.d = d
+	cur := p.snapshot()
+	target = cur.resolve(target)
+	_, ok := cur.container[target]
+	_, lazy := cur.lazy[target]
+	if !ok && !lazy {
+		return fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, target)
+	}
+	_, exists := cur.container[alias]
+	_, lazyExists := cur.lazy[alias]
+	if exists || lazyExists {
+		return fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_EXISTS, alias)
+	}
+	p.update(func(st *providerState[T]) {
+		st.aliases[alias] = target
+	})
+	return nil
+}
+
+// AddHooks registers the lifecycle hooks of items.
+func (p *GiuProvider[T]) AddHooks(hooks ProviderHooks[T]) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.hooks = append(p.hooks, hooks)
+}
+
+func (p *GiuProvider[T]) lifecycleHooks() []ProviderHooks[T] {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.hooks
+}
+
+// Wrap decorates the current items and the items added or built later with fn, e.g. to instrument clients.
+// The wrappers are applied in the order of Wrap calls, fn must not call the provider.
+func (p *GiuProvider[T]) Wrap(fn func(name string, item T) T) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.wrappers = append(p.wrappers, fn)
+	p.update(func(st *providerState[T]) {
+		for name, v := range st.container {
+			st.container[name] = fn(name, v)
+			if st.dName == name {
+				st.d = st.container[name]
+			}
+		}
+	})
+}
+
+// wrapLocked applies the wrappers to v, the caller must hold p.lock, so a concurrent Wrap can't miss v.
+func (p *GiuProvider[T]) wrapLocked(name string, v T) T {
+	for _, fn := range p.wrappers {
+		v = fn(name, v)
 	}
-	if len(p.container) == 0 {
-		p.d = d
+	return v
+}
+
+// AddLazy adds an item which is built by newFunc on its first Get, concurrent Gets share a single build.
+// If the build fails, Get returns false and the next Get builds it again.
+func (p *GiuProvider[T]) AddLazy(name string, newFunc func() (T, error), isDefault ...bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.update(func(st *providerState[T]) {
+		if (len(isDefault) > 0 && isDefault[0]) || (len(st.container) == 0 && len(st.lazy) == 0) {
+			var zero T
+			st.d, st.dName = zero, name
+		}
+		delete(st.container, name)
+		st.lazy[name] = newFunc
+	})
+}
+
+// load returns the item of name, it builds the item if it's lazy.
+func (p *GiuProvider[T]) load(name string) (T, error) {
+	st := p.snapshot()
+	name = st.resolve(name)
+	v, ok := st.container[name]
+	_, lazy := st.lazy[name]
+	if ok {
+		return v, nil
 	}
-	p.container[name] = d
+	if !lazy {
+		return v, fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, name)
+	}
+	res, err, _ := p.group.Do(name, func() (interface{}, error) {
+		st := p.snapshot()
+		v, ok := st.container[name]
+		newFunc, lazy := st.lazy[name]
+		// built by the previous call or removed
+		if ok || !lazy {
+			return v, nil
+		}
+		v, err := newFunc()
+		if err != nil {
+			return nil, err
+		}
+		p.lock.Lock()
+		v = p.wrapLocked(name, v)
+		_, added := p.snapshot().lazy[name]
+		if added {
+			p.update(func(st *providerState[T]) {
+				delete(st.lazy, name)
+				st.container[name] = v
+				if st.dName == name {
+					st.d = v
+				}
+			})
+		}
+		hooks := p.hooks
+		p.lock.Unlock()
+		if added {
+			for _, h := range hooks {
+				if h.OnAdd != nil {
+					h.OnAdd(name, v)
+				}
+			}
+		}
+		return v, nil
+	})
+	if err != nil {
+		return v, fmt.Errorf("%s: %w", name, err)
+	}
+	t, _ := res.(T)
+	return t, nil
 }
 
-// Get returns the value of the generic provider, if the name is not found, it returns false
+// Get returns the value of the generic provider, if the name is not found, it returns false.
+// A lazy item is built on the first Get, it returns false if the build fails.
 func (p *GiuProvider[T]) Get(name string) (T, bool) {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
-	v, ok := p.container[name]
-	return v, ok
+	v, err := p.load(name)
+	return v, err == nil
+}
+
+// GetE returns the value of the generic provider, if the name is not found, it returns an error wrapping
+// ERR_PROVIDER_ITEM_NOT_FOUND. A lazy item is built on the first call, the build error is returned.
+func (p *GiuProvider[T]) GetE(name string) (T, error) {
+	return p.load(name)
+}
+
+// MustGet returns the value of the generic provider, it panics with the error of GetE.
+func (p *GiuProvider[T]) MustGet(name string) T {
+	return mustGet[T](p, name)
+}
+
+func mustGet[T any](p Provider[T], name string) T {
+	v, err := p.GetE(name)
+	if err != nil {
+		panic(fmt.Errorf("giu provider of %s: %w", reflect.TypeOf((*T)(nil)).Elem(), err))
+	}
+	return v
 }
 
-// Default returns the default value of the generic provider, if no default value is set, it returns the first value
+// Default returns the default value of the generic provider, if no default value is set, it returns the first value.
+// If failover is set, it returns the first healthy item of the failover names, or the default value if none is healthy.
 func (p *GiuProvider[T]) Default() T {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
-	return p.d
+	st := p.snapshot()
+	if _, lazy := st.lazy[st.dName]; lazy {
+		// load sets the default value after the build
+		_, _ = p.load(st.dName)
+		st = p.snapshot()
+	}
+	if len(st.failover) == 0 || st.healthy == nil {
+		return st.d
+	}
+	for _, name := range st.failover {
+		if v, ok := st.container[name]; ok && st.healthy(name, v) {
+			return v
+		}
+	}
+	return st.d
+}
+
+// SetFailover makes Default return the first healthy item of names, names[0] is set as the default value, which is
+// returned if none is healthy. Empty names turns failover off.
+func (p *GiuProvider[T]) SetFailover(names []string, healthy ProviderHealthFunc[T]) {
+	if len(names) > 0 {
+		p.SetDefault(names[0])
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.update(func(st *providerState[T]) {
+		st.failover = append([]string(nil), names...)
+		st.healthy = healthy
+	})
+}
+
+type pingHealthState struct {
+	healthy bool
+	pinging bool
+	at      time.Time
+}
+
+// NewPingHealth returns a health func which reports the result of the last ping of each item, initially healthy.
+// Items are pinged in background when the result is older than interval, so the func never blocks.
+func NewPingHealth[T any](ping func(ctx context.Context, v T) error, interval time.Duration) ProviderHealthFunc[T] {
+	var lock sync.Mutex
+	states := make(map[string]*pingHealthState)
+	return func(name string, v T) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		st, ok := states[name]
+		if !ok {
+			st = &pingHealthState{healthy: true}
+			states[name] = st
+		}
+		if !st.pinging && time.Since(st.at) >= interval {
+			st.pinging = true
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), PROVIDER_PING_TIMEOUT)
+				err := ping(ctx, v)
+				cancel()
+				lock.Lock()
+				st.healthy, st.pinging, st.at = err == nil, false, time.Now()
+				lock.Unlock()
+			}()
+		}
+		return st.healthy
+	}
 }
 
 // SetDefault sets the default value of the generic provider, if the name is not found, it returns false
 func (p *GiuProvider[T]) SetDefault(name string) bool {
 	p.lock.Lock()
-	defer p.lock.Unlock()
-	if _, ok := p.container[name]; ok {
-		p.d = p.container[name]
-		return true
+	cur := p.snapshot()
+	name = cur.resolve(name)
+	v, ok := cur.container[name]
+	_, lazy := cur.lazy[name]
+	if ok || lazy {
+		p.update(func(st *providerState[T]) {
+			st.d, st.dName = v, name
+		})
+	}
+	hooks := p.hooks
+	p.lock.Unlock()
+	if !ok && !lazy {
+		return false
+	}
+	for _, h := range hooks {
+		if h.OnSetDefault != nil {
+			h.OnSetDefault(name, v)
+		}
+	}
+	return true
+}
+
+// Remove removes the item of name and closes it if it has a Close method.
+func (p *GiuProvider[T]) Remove(name string) error {
+	return p.remove(name, func(v T) error {
+		if c, ok := any(v).(io.Closer); ok {
+			return c.Close()
+		}
+		return nil
+	})
+}
+
+func (p *GiuProvider[T]) remove(name string, closeFunc func(v T) error) error {
+	return p.removeIf(name, nil, closeFunc)
+}
+
+// removeIf removes the item of name only if match reports true for it, a nil match matches every item.
+// Aliases are not matched, they're kept.
+func (p *GiuProvider[T]) removeIf(name string, match func(v T) bool, closeFunc func(v T) error) error {
+	p.lock.Lock()
+	cur := p.snapshot()
+	v, ok := cur.container[name]
+	_, lazy := cur.lazy[name]
+	if match != nil && (ok || lazy) && !match(v) {
+		p.lock.Unlock()
+		return nil
+	}
+	if !ok && !lazy {
+		_, alias := cur.aliases[name]
+		if alias && match != nil {
+			p.lock.Unlock()
+			return nil
+		}
+		if alias {
+			// only the alias is removed, the item is kept
+			p.update(func(st *providerState[T]) {
+				delete(st.aliases, name)
+			})
+		}
+		p.lock.Unlock()
+		if alias {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, name)
+	}
+	promoted := false
+	p.update(func(st *providerState[T]) {
+		delete(st.container, name)
+		delete(st.lazy, name)
+		for alias, target := range st.aliases {
+			if target == name {
+				delete(st.aliases, alias)
+			}
+		}
+		if st.dName == name {
+			promoted = st.promoteDefault()
+		}
+	})
+	hooks := p.hooks
+	next := p.snapshot()
+	p.lock.Unlock()
+	var err error
+	// a lazy item not built yet needs no close
+	if ok && !p.borrowed {
+		err = closeFunc(v)
+	}
+	for _, h := range hooks {
+		if h.OnRemove != nil {
+			h.OnRemove(name, v)
+		}
+		if promoted && h.OnSetDefault != nil {
+			h.OnSetDefault(next.dName, next.d)
+		}
+	}
+	return err
+}
+
+// Keys returns the sorted names of the built items, the lazy items not built yet are not included, like Range.
+func (p *GiuProvider[T]) Keys() []string {
+	st := p.snapshot()
+	keys := make([]string, 0, len(st.container))
+	for k := range st.container {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Range calls fn for each item in the order of Keys until fn returns false. The items are taken before the
+// iteration, so fn can change the provider. The lazy items not built yet are skipped, they're not in Keys either,
+// so ranging doesn't build them.
+func (p *GiuProvider[T]) Range(fn func(name string, v T) bool) {
+	items := MapToSet(p.snapshot().container)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	for _, item := range items {
+		if !fn(item.Name, item.Value) {
+			return
+		}
 	}
-	return false
+}
+
+// Snapshot returns a copy of the built items, the lazy items not built yet are not included.
+func (p *GiuProvider[T]) Snapshot() map[string]T {
+	st := p.snapshot()
+	items := make(map[string]T, len(st.container))
+	for k, v := range st.container {
+		items[k] = v
+	}
+	return items
+}
+
+// CloneWith returns a generic provider with the items, lazy items, default and failover of p, and the overrides
+// which add or replace items, e.g. to swap some items with fakes in tests. p is not changed, the items are shared
+// but the clone doesn't close them, neither by Remove nor by Shutdown. Wrappers and hooks are not cloned.
+func (p *GiuProvider[T]) CloneWith(overrides map[string]T) Provider[T] {
+	return p.cloneWith(overrides)
+}
 
+func (p *GiuProvider[T]) cloneWith(overrides map[string]T) *GiuProvider[T] {
+	st := p.snapshot().clone()
+	for name, v := range overrides {
+		delete(st.lazy, name)
+		st.container[name] = v
+		if st.dName == name {
+			st.d = v
+		}
+	}
+	if st.dName == "" {
+		for name, v := range st.container {
+			st.d, st.dName = v, name
+			break
+		}
+	}
+	g := &GiuProvider[T]{borrowed: true}
+	g.state.Store(st)
+	return g
 }
 
 // Shutdown is a placeholder for the generic provider, it should be implemented by the specific provider
@@ -185,23 +809,354 @@ func (p *GiuProvider[T]) Shutdown() error {
 	return nil
 }
 
+// ShutdownContext is a placeholder for the generic provider, it should be implemented by the specific provider
+func (p *GiuProvider[T]) ShutdownContext(_ context.Context) error {
+	return nil
+}
+
+// shutdownItems closes the items one by one with closeFunc and joins the errors. When ctx is done, the closing
+// item is abandoned and the rest are skipped.
+func (p *GiuProvider[T]) shutdownItems(ctx context.Context, closeFunc func(v T) error) error {
+	if p.borrowed {
+		return nil
+	}
+	items := MapToSet(p.snapshot().container)
+	hooks := p.lifecycleHooks()
+	var errs []error
+	for _, item := range items {
+		for _, h := range hooks {
+			if h.BeforeShutdown != nil {
+				h.BeforeShutdown(item.Name, item.Value)
+			}
+		}
+		err := closeContext(ctx, item.Value, closeFunc)
+		for _, h := range hooks {
+			if h.AfterShutdown != nil {
+				h.AfterShutdown(item.Name, item.Value, err)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", item.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func closeContext[T any](ctx context.Context, v T, closeFunc func(v T) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- closeFunc(v)
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scopedProvider is a view of the items of a provider with a name prefix.
+type scopedProvider[T any] struct {
+	parent Provider[T]
+	prefix string
+	lock   sync.RWMutex
+	dName  string
+}
+
+// Scope returns a view of the items of p whose names start with prefix, e.g. "analytics_", the names are not
+// trimmed. Items added to the view are added to p, names without the prefix are ignored. The default of the view
+// is the item set by SetDefault, or the first one of Keys. Shutdown of the view does nothing, the items are
+// owned by p, but Remove removes and closes the item of p.
+func Scope[T any](p Provider[T], prefix string) Provider[T] {
+	return &scopedProvider[T]{parent: p, prefix: prefix}
+}
+
+func (s *scopedProvider[T]) inScope(name string) bool {
+	return strings.HasPrefix(name, s.prefix)
+}
+
+func (s *scopedProvider[T]) Add(name string, d T, isDefault ...bool) {
+	s.Set(name, d, isDefault...)
+}
+
+func (s *scopedProvider[T]) TryAdd(name string, d T, isDefault ...bool) error {
+	if !s.inScope(name) {
+		return fmt.Errorf("name %s is out of scope %s", name, s.prefix)
+	}
+	if err := s.parent.TryAdd(name, d); err != nil {
+		return err
+	}
+	if len(isDefault) > 0 && isDefault[0] {
+		s.lock.Lock()
+		s.dName = name
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+func (s *scopedProvider[T]) Set(name string, d T, isDefault ...bool) {
+	if !s.inScope(name) {
+		return
+	}
+	s.parent.Set(name, d)
+	if len(isDefault) > 0 && isDefault[0] {
+		s.lock.Lock()
+		s.dName = name
+		s.lock.Unlock()
+	}
+}
+
+func (s *scopedProvider[T]) AddAlias(alias, target string) error {
+	if !s.inScope(alias) || !s.inScope(target) {
+		return fmt.Errorf("alias %s or target %s is out of scope %s", alias, target, s.prefix)
+	}
+	return s.parent.AddAlias(alias, target)
+}
+
+func (s *scopedProvider[T]) Replace(name string, d T) (T, bool) {
+	if !s.inScope(name) {
+		var zero T
+		return zero, false
+	}
+	return s.parent.Replace(name, d)
+}
+
+func (s *scopedProvider[T]) Get(name string) (T, bool) {
+	if !s.inScope(name) {
+		var zero T
+		return zero, false
+	}
+	return s.parent.Get(name)
+}
+
+func (s *scopedProvider[T]) GetE(name string) (T, error) {
+	if !s.inScope(name) {
+		var zero T
+		return zero, fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, name)
+	}
+	return s.parent.GetE(name)
+}
+
+func (s *scopedProvider[T]) MustGet(name string) T {
+	return mustGet[T](s, name)
+}
+
+func (s *scopedProvider[T]) Default() T {
+	s.lock.RLock()
+	name := s.dName
+	s.lock.RUnlock()
+	if v, ok := s.Get(name); ok {
+		return v
+	}
+	for _, k := range s.Keys() {
+		if v, ok := s.parent.Get(k); ok {
+			return v
+		}
+	}
+	var zero T
+	return zero
+}
+
+func (s *scopedProvider[T]) SetDefault(name string) bool {
+	if _, ok := s.Get(name); !ok {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dName = name
+	return true
+}
+
+func (s *scopedProvider[T]) Remove(name string) error {
+	if !s.inScope(name) {
+		return fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, name)
+	}
+	return s.parent.Remove(name)
+}
+
+func (s *scopedProvider[T]) Keys() []string {
+	var keys []string
+	for _, k := range s.parent.Keys() {
+		if s.inScope(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (s *scopedProvider[T]) Range(fn func(name string, v T) bool) {
+	s.parent.Range(func(name string, v T) bool {
+		if !s.inScope(name) {
+			return true
+		}
+		return fn(name, v)
+	})
+}
+
+// Wrap decorates the items of p in the scope.
+func (s *scopedProvider[T]) Wrap(fn func(name string, item T) T) {
+	s.parent.Wrap(func(name string, item T) T {
+		if !s.inScope(name) {
+			return item
+		}
+		return fn(name, item)
+	})
+}
+
+func (s *scopedProvider[T]) Snapshot() map[string]T {
+	items := s.parent.Snapshot()
+	for name := range items {
+		if !s.inScope(name) {
+			delete(items, name)
+		}
+	}
+	return items
+}
+
+// CloneWith returns a view of the same scope on a clone of the parent, the overrides out of scope are ignored.
+func (s *scopedProvider[T]) CloneWith(overrides map[string]T) Provider[T] {
+	scoped := make(map[string]T, len(overrides))
+	for name, v := range overrides {
+		if s.inScope(name) {
+			scoped[name] = v
+		}
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return &scopedProvider[T]{parent: s.parent.CloneWith(scoped), prefix: s.prefix, dName: s.dName}
+}
+
+func (s *scopedProvider[T]) Shutdown() error {
+	return nil
+}
+
+func (s *scopedProvider[T]) ShutdownContext(_ context.Context) error {
+	return nil
+}
+
+// getContext pings the item of name, if the ping fails and reconnect is not nil, the item is rebuilt once for
+// concurrent calls and replaces the old one, which is closed by closeFunc.
+func getContext[T comparable](ctx context.Context, p *GiuProvider[T], group *singleflight.Group, name string,
+	ping func(ctx context.Context, v T) error, reconnect func(name string) (T, error), closeFunc func(v T) error) (T, error) {
+	name = p.snapshot().resolve(name)
+	v, ok := p.Get(name)
+	if !ok {
+		return v, fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, name)
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, PROVIDER_PING_TIMEOUT)
+	defer cancel()
+	err := ping(pingCtx, v)
+	if err == nil || reconnect == nil {
+		return v, err
+	}
+	nv, rerr, _ := group.Do(name, func() (interface{}, error) {
+		// another call may have replaced or removed it
+		if cur, ok := p.Get(name); !ok {
+			return nil, fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, name)
+		} else if cur != v {
+			return cur, nil
+		}
+		nv, err := reconnect(name)
+		if err != nil {
+			return nil, err
+		}
+		p.lock.Lock()
+		nv = p.wrapLocked(name, nv)
+		cur, exists := p.snapshot().container[name]
+		replaced := exists && cur == v
+		if replaced {
+			p.update(func(st *providerState[T]) {
+				st.container[name] = nv
+				if st.dName == name {
+					st.d = nv
+				}
+			})
+		}
+		p.lock.Unlock()
+		if !replaced {
+			// the item is changed meanwhile, the reopened one is not stored
+			_ = closeFunc(nv)
+			if !exists {
+				return nil, fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, name)
+			}
+			return cur, nil
+		}
+		// the old item is closed only after the swap, so no caller gets a closed one from p
+		_ = closeFunc(v)
+		for _, h := range p.lifecycleHooks() {
+			if h.OnRemove != nil {
+				h.OnRemove(name, v)
+			}
+			if h.OnAdd != nil {
+				h.OnAdd(name, nv)
+			}
+		}
+		return nv, nil
+	})
+	if rerr != nil {
+		return v, errors.Join(err, rerr)
+	}
+	return nv.(T), nil
+}
+
 type GormProvider interface {
-	Provider[*gorm.DB]
+	ContextProvider[*gorm.DB]
+	HealthChecker
 }
 
 type gormProvider struct {
 	*GiuProvider[*gorm.DB]
+	// reconnect rebuilds a connection from its params, it's nil if the provider is created from connections.
+	reconnect func(name string) (*gorm.DB, error)
+	group     singleflight.Group
 }
 
-func (gp *gormProvider) Shutdown() error {
-	for _, v := range gp.container {
-		if db, err := v.DB(); err == nil {
-			if err := db.Close(); err != nil {
-				return err
-			}
-		}
+// GetContext returns the connection of name if a ping succeeds, the connections built from params are reopened
+// if the ping fails.
+func (gp *gormProvider) GetContext(ctx context.Context, name string) (*gorm.DB, error) {
+	return getContext(ctx, gp.GiuProvider, &gp.group, name, pingGorm, gp.reconnect, closeGorm)
+}
+
+func pingGorm(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
 	}
-	return nil
+	return sqlDB.PingContext(ctx)
+}
+
+func closeGorm(db *gorm.DB) error {
+	// flush the queued audit records before the pool is closed
+	if audit, ok := db.Config.Plugins[(&AuditPlugin{}).Name()].(*AuditPlugin); ok {
+		audit.Close()
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Remove removes the connection of name and closes it.
+func (gp *gormProvider) Remove(name string) error {
+	return gp.remove(name, closeGorm)
+}
+
+func (gp *gormProvider) Shutdown() error {
+	return gp.ShutdownContext(context.Background())
+}
+
+func (gp *gormProvider) ShutdownContext(ctx context.Context) error {
+	return gp.shutdownItems(ctx, closeGorm)
+}
+
+// CloneWith returns a gorm provider with the connections of gp and overrides, it can be asserted to GormProvider.
+// The clone doesn't reopen or close the shared connections.
+func (gp *gormProvider) CloneWith(overrides map[string]*gorm.DB) Provider[*gorm.DB] {
+	return &gormProvider{GiuProvider: gp.cloneWith(overrides)}
 }
 
 // NewGormProvider creates a gorm provider from existing connection, if items is not empty, the first item will be set as default
@@ -218,15 +1173,47 @@ func newGormProvider(connections ...map[string]*gorm.DB) *gormProvider {
 
 // NewGormProviderFromParams creates a gorm provider from params, if items is not empty, the first item will be set as default
 func NewGormProviderFromParams(configParams *GormConfigParams, connectionParams map[string]*GormConnectionParams) (GormProvider, error) {
-	connections := make(map[string]*gorm.DB)
-	for k, v := range connectionParams {
-		conn, err := NewGorm(*v, configParams)
+	return newGormProviderFromParams(configParams, connectionParams, func(v *GormConnectionParams) (*gorm.DB, error) {
+		return NewGorm(*v, configParams)
+	})
+}
+
+// NewGormProviderWithLoggerFromParams creates a gorm provider from params and replace default logger with zap logger, if items is not empty, the first item will be set as default
+func NewGormProviderWithLoggerFromParams(configParams *GormConfigParams, connectionParams map[string]*GormConnectionParams, logger *zap.Logger) (GormProvider, error) {
+	return newGormProviderFromParams(configParams, connectionParams, func(v *GormConnectionParams) (*gorm.DB, error) {
+		return NewGormWithLogger(*v, logger, configParams)
+	})
+}
+
+func newGormProviderFromParams(configParams *GormConfigParams, connectionParams map[string]*GormConnectionParams, newFunc func(*GormConnectionParams) (*gorm.DB, error)) (GormProvider, error) {
+	var p *gormProvider
+	if configParams != nil && configParams.Lazy {
+		p = &gormProvider{GiuProvider: NewLazyGiuProviderFromParams(newFunc, connectionParams)}
+	} else {
+		connections, err := buildItems(connectionParams, newFunc, closeGorm)
 		if err != nil {
 			return nil, err
 		}
-		connections[k] = conn
+		p = newGormProvider(connections)
+	}
+	p.reconnect = func(name string) (*gorm.DB, error) {
+		v, ok := connectionParams[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ERR_PROVIDER_ITEM_NOT_FOUND, name)
+		}
+		return newFunc(v)
+	}
+	if configParams != nil && len(configParams.Failover) > 0 {
+		p.SetFailover(configParams.Failover, NewPingHealth(pingGorm, PROVIDER_HEALTH_INTERVAL))
 	}
-	return NewGormProvider(connections), nil
+	if configParams != nil {
+		for alias, target := range configParams.Aliases {
+			if err := p.AddAlias(alias, target); err != nil {
+				return nil, errors.Join(fmt.Errorf("alias %s: %w", alias, err), p.Shutdown())
+			}
+		}
+	}
+	return p, nil
 }
 
 // NewGormProviderFromConfig creates a gorm provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
@@ -252,15 +1239,7 @@ func NewGormProviderWithLoggerFromConfig(config *viper.Viper, logger *zap.Logger
 	if err := config.UnmarshalKey("gorm_connection", &connectionParams); err != nil {
 		return nil, err
 	}
-	connections := make(map[string]*gorm.DB)
-	for k, v := range connectionParams {
-		conn, err := NewGormWithLogger(*v, logger, &c)
-		if err != nil {
-			return nil, err
-		}
-		connections[k] = conn
-	}
-	return NewGormProvider(connections), nil
+	return NewGormProviderWithLoggerFromParams(&c, connectionParams, logger)
 }
 
 type ZapProvider interface {
@@ -271,13 +1250,17 @@ type zapProvider struct {
 	*GiuProvider[*zap.Logger]
 }
 
+// Remove removes the logger of name, syncs it and stops its async writers.
+func (zp *zapProvider) Remove(name string) error {
+	return zp.remove(name, closeZapLogger)
+}
+
 func (zp *zapProvider) Shutdown() error {
-	for _, v := range zp.container {
-		if err := v.Sync(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return zp.ShutdownContext(context.Background())
+}
+
+func (zp *zapProvider) ShutdownContext(ctx context.Context) error {
+	return zp.shutdownItems(ctx, closeZapLogger)
 }
 
 // NewZapProvider creates a zap provider from existing logger, if items is not empty, the first item will be set as default
@@ -296,7 +1279,7 @@ func NewZapProviderFromParams(params map[string]*LoggerParams) ZapProvider {
 
 // NewZapProviderFromConfig creates a zap provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
 func NewZapProviderFromConfig(config *viper.Viper) (ZapProvider, error) {
-	giu, err := NewGiuProviderFromConfig[*zap.Logger, *LoggerParams](config, "logger", NewZapLogger)
+	giu, err := NewGiuProviderFromConfigError[*zap.Logger, *LoggerParams](config, "logger", NewZapLoggerWithCheck)
 	if err != nil {
 		return nil, err
 	}
@@ -306,20 +1289,53 @@ func NewZapProviderFromConfig(config *viper.Viper) (ZapProvider, error) {
 }
 
 type RedisProvider interface {
-	Provider[redis.UniversalClient]
+	ContextProvider[redis.UniversalClient]
+	HealthChecker
 }
 
 type redisProvider struct {
 	*GiuProvider[redis.UniversalClient]
+	replicas []redis.UniversalClient
+	group    singleflight.Group
+}
+
+// GetContext returns the client of name if a ping succeeds. The clients are not rebuilt, because they reconnect by
+// themselves. The providers returned as Provider[redis.UniversalClient] can be asserted to RedisProvider.
+func (rp *redisProvider) GetContext(ctx context.Context, name string) (redis.UniversalClient, error) {
+	return getContext(ctx, rp.GiuProvider, &rp.group, name, func(ctx context.Context, rdb redis.UniversalClient) error {
+		return rdb.Ping(ctx).Err()
+	}, nil, nil)
 }
 
 func (rp *redisProvider) Shutdown() error {
-	for _, v := range rp.container {
-		if err := v.Close(); err != nil {
-			return err
+	return rp.ShutdownContext(context.Background())
+}
+
+func (rp *redisProvider) ShutdownContext(ctx context.Context) error {
+	errs := []error{rp.shutdownItems(ctx, redis.UniversalClient.Close)}
+	for i, v := range rp.replicas {
+		if err := closeContext(ctx, v, redis.UniversalClient.Close); err != nil {
+			errs = append(errs, fmt.Errorf("replica %d: %w", i, err))
 		}
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// CloneWith returns a redis provider with the clients of rp and overrides, it can be asserted to RedisProvider.
+// The replicas are owned by rp, the clone doesn't close them.
+func (rp *redisProvider) CloneWith(overrides map[string]redis.UniversalClient) Provider[redis.UniversalClient] {
+	return &redisProvider{GiuProvider: rp.cloneWith(overrides)}
+}
+
+// withReplicas registers the read replicas of the named clients, see NewRedisWithReplicas.
+func (rp *redisProvider) withReplicas(params map[string]*RedisReplicaParams) *redisProvider {
+	for name, p := range params {
+		if primary, ok := rp.Get(name); ok && p != nil {
+			_, replicas := NewRedisWithReplicas(primary, p)
+			rp.replicas = append(rp.replicas, replicas...)
+		}
+	}
+	return rp
 }
 
 // NewRedisProvider creates a redis provider from existing connection, if items is not empty, the first item will be set as default
@@ -336,14 +1352,47 @@ func NewRedisProviderFromParams(params map[string]*RedisParams) Provider[redis.U
 	}
 }
 
+// NewRedisProviderWithReplicasFromParams creates a redis provider from params, and routes the read-only commands of
+// the clients with replicas in replicaParams to their replicas, if items is not empty, the first item will be set as default
+func NewRedisProviderWithReplicasFromParams(params map[string]*RedisParams, replicaParams map[string]*RedisReplicaParams) Provider[redis.UniversalClient] {
+	rp := &redisProvider{
+		GiuProvider: NewGiuProviderFromParams[redis.UniversalClient, *RedisParams](NewRedis, params),
+	}
+	return rp.withReplicas(replicaParams)
+}
+
 // NewRedisProviderFromConfig creates a redis provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default.
+// The read replicas of key "redis_replicas" are registered to the clients of the same names.
 // NOTE: it's not a good idea to log redis cmd, so we don't use zap logger here.
 func NewRedisProviderFromConfig(config *viper.Viper) (Provider[redis.UniversalClient], error) {
 	giu, err := NewGiuProviderFromConfig[redis.UniversalClient, *RedisParams](config, "redis", NewRedis)
 	if err != nil {
 		return nil, err
 	}
-	return &redisProvider{
+	var replicas map[string]*RedisReplicaParams
+	if err := config.UnmarshalKey("redis_replicas", &replicas); err != nil {
+		return nil, err
+	}
+	rp := &redisProvider{
 		GiuProvider: giu,
-	}, nil
+	}
+	return rp.withReplicas(replicas), nil
+}
+
+// NewAlerterProviderFromParams creates an alerter provider from params, if items is not empty, the first item will be set as default
+func NewAlerterProviderFromParams(params map[string]*AlerterParams) (Provider[Alerter], error) {
+	giu, err := NewGiuProviderFromParamsError[Alerter, *AlerterParams](NewAlerter, params)
+	if err != nil {
+		return nil, err
+	}
+	return giu, nil
+}
+
+// NewAlerterProviderFromConfig creates an alerter provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
+func NewAlerterProviderFromConfig(config *viper.Viper) (Provider[Alerter], error) {
+	giu, err := NewGiuProviderFromConfigError[Alerter, *AlerterParams](config, "alerter", NewAlerter)
+	if err != nil {
+		return nil, err
+	}
+	return giu, nil
 }
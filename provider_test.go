@@ -0,0 +1,78 @@
+package giu
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func benchmarkProvider() *GiuProvider[int] {
+	items := make(map[string]int, 16)
+	for i := 0; i < 16; i++ {
+		items["item"+strconv.Itoa(i)] = i
+	}
+	return NewGiuProvider(items)
+}
+
+func BenchmarkProviderGet(b *testing.B) {
+	p := benchmarkProvider()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, ok := p.Get("item7"); !ok {
+				b.Error("item7 not found")
+			}
+		}
+	})
+}
+
+func BenchmarkProviderDefault(b *testing.B) {
+	p := benchmarkProvider()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = p.Default()
+		}
+	})
+}
+
+func BenchmarkProviderSet(b *testing.B) {
+	p := benchmarkProvider()
+	var n int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(atomic.AddInt64(&n, 1))
+			p.Set("item"+strconv.Itoa(i%16), i)
+		}
+	})
+}
+
+// BenchmarkProviderGetWithSet measures the readers while a writer keeps replacing items.
+func BenchmarkProviderGetWithSet(b *testing.B) {
+	p := benchmarkProvider()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				p.Set("item"+strconv.Itoa(i%16), i)
+			}
+		}
+	}()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, ok := p.Get("item7"); !ok {
+				b.Error("item7 not found")
+			}
+		}
+	})
+}
@@ -0,0 +1,432 @@
+package giu
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+	oauth2jwt "golang.org/x/oauth2/jwt"
+	"golang.org/x/sync/errgroup"
+)
+
+// PushNotification is a notification sent to devices by a PushClient.
+type PushNotification struct {
+	Title string
+	Body  string
+	Data  map[string]string
+	// Badge and Sound are only used by apns.
+	Badge *int
+	Sound string
+	// HighPriority delivers the notification immediately and wakes the device.
+	HighPriority bool
+	// TTL is how long the notification is kept if the device is offline, zero means the backend default.
+	TTL time.Duration
+	// CollapseKey replaces the pending notification with the same key.
+	CollapseKey string
+}
+
+// PushSender sends a notification to a single device token. It returns ERR_PUSH_INVALID_TOKEN if the token is
+// unregistered or malformed, and the token should be removed.
+type PushSender interface {
+	Send(ctx context.Context, token string, n PushNotification) error
+}
+
+var (
+	ERR_PUSH_INVALID_TOKEN = errors.New("push token is invalid or unregistered")
+	ERR_PUSH_NO_CREDENTIAL = errors.New("push credentials are not configured")
+)
+
+type PushParams struct {
+	// Type is the push backend: fcm, apns.
+	Type string
+	// Credentials is the fcm service account json, or the apns .p8 auth key, e.g. from a secret.
+	// CredentialsFile is read if Credentials is empty.
+	Credentials     string
+	CredentialsFile string
+	// ProjectID is the firebase project, default is the project of the service account, used by fcm.
+	ProjectID string
+	// KeyID, TeamID and Topic (the app bundle id) are used by apns.
+	KeyID  string
+	TeamID string
+	Topic  string
+	// Sandbox sends to the apns development environment.
+	Sandbox bool
+	// Timeout is the http request timeout, default is 10s.
+	Timeout time.Duration
+	// Concurrency is the max number of concurrent requests of a batch, default is 16.
+	Concurrency int
+}
+
+var _defaultPushParams = PushParams{
+	Timeout:     10 * time.Second,
+	Concurrency: 16,
+}
+
+const (
+	PUSH_TYPE_FCM  = "fcm"
+	PUSH_TYPE_APNS = "apns"
+)
+
+func (p *PushParams) credentials() ([]byte, error) {
+	if p.Credentials != "" {
+		return []byte(p.Credentials), nil
+	}
+	if p.CredentialsFile != "" {
+		return os.ReadFile(p.CredentialsFile)
+	}
+	return nil, ERR_PUSH_NO_CREDENTIAL
+}
+
+// NewPushSender creates a push sender by params type.
+func NewPushSender(params *PushParams) (PushSender, error) {
+	if params.Timeout <= 0 {
+		params.Timeout = _defaultPushParams.Timeout
+	}
+	switch params.Type {
+	case PUSH_TYPE_FCM:
+		return NewFcmSender(params)
+	case PUSH_TYPE_APNS:
+		return NewApnsSender(params)
+	default:
+		return nil, fmt.Errorf("unsupported push type: %s", params.Type)
+	}
+}
+
+// FcmSender sends notifications with the firebase cloud messaging http v1 api.
+type FcmSender struct {
+	client *resty.Client
+	url    string
+}
+
+func NewFcmSender(params *PushParams) (*FcmSender, error) {
+	data, err := params.credentials()
+	if err != nil {
+		return nil, err
+	}
+	var sa struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("invalid fcm service account: %w", err)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	project := params.ProjectID
+	if project == "" {
+		project = sa.ProjectID
+	}
+	conf := &oauth2jwt.Config{
+		Email:      sa.ClientEmail,
+		PrivateKey: []byte(sa.PrivateKey),
+		TokenURL:   sa.TokenURI,
+		Scopes:     []string{"https://www.googleapis.com/auth/firebase.messaging"},
+	}
+	client := resty.NewWithClient(conf.Client(context.Background())).SetTimeout(params.Timeout)
+	return &FcmSender{client: client, url: "https://fcm.googleapis.com/v1/projects/" + project + "/messages:send"}, nil
+}
+
+func (f *FcmSender) Send(ctx context.Context, token string, n PushNotification) error {
+	android := map[string]interface{}{"priority": "normal"}
+	if n.HighPriority {
+		android["priority"] = "high"
+	}
+	if n.TTL > 0 {
+		android["ttl"] = strconv.FormatInt(int64(n.TTL.Seconds()), 10) + "s"
+	}
+	if n.CollapseKey != "" {
+		android["collapse_key"] = n.CollapseKey
+	}
+	message := map[string]interface{}{
+		"token":        token,
+		"notification": map[string]string{"title": n.Title, "body": n.Body},
+		"android":      android,
+	}
+	if len(n.Data) > 0 {
+		message["data"] = n.Data
+	}
+	var fail struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	resp, err := f.client.R().SetContext(ctx).SetBody(map[string]interface{}{"message": message}).SetError(&fail).Post(f.url)
+	if err != nil {
+		return err
+	}
+	if !resp.IsError() {
+		return nil
+	}
+	for _, d := range fail.Error.Details {
+		if d.ErrorCode == "UNREGISTERED" {
+			return ERR_PUSH_INVALID_TOKEN
+		}
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return ERR_PUSH_INVALID_TOKEN
+	}
+	return fmt.Errorf("fcm request failed, status: %d, error: %s %s", resp.StatusCode(), fail.Error.Status, fail.Error.Message)
+}
+
+// ApnsSender sends notifications with the apple push notification service, authenticated by a .p8 token key.
+type ApnsSender struct {
+	client *resty.Client
+	url    string
+	key    *ecdsa.PrivateKey
+	keyID  string
+	teamID string
+	topic  string
+
+	lock     sync.Mutex
+	bearer   string
+	bearerAt time.Time
+}
+
+func NewApnsSender(params *PushParams) (*ApnsSender, error) {
+	data, err := params.credentials()
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apns auth key: %w", err)
+	}
+	u := "https://api.push.apple.com"
+	if params.Sandbox {
+		u = "https://api.sandbox.push.apple.com"
+	}
+	return &ApnsSender{
+		client: NewResty(&RestyParams{Timeout: params.Timeout}),
+		url:    u,
+		key:    key,
+		keyID:  params.KeyID,
+		teamID: params.TeamID,
+		topic:  params.Topic,
+	}, nil
+}
+
+// token returns the provider token, apple rejects tokens older than 1h and refreshing more than every 20m.
+func (a *ApnsSender) token() (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.bearer != "" && time.Since(a.bearerAt) < 45*time.Minute {
+		return a.bearer, nil
+	}
+	now := time.Now()
+	t := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"iss": a.teamID, "iat": now.Unix()})
+	t.Header["kid"] = a.keyID
+	signed, err := t.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.bearer, a.bearerAt = signed, now
+	return signed, nil
+}
+
+func (a *ApnsSender) Send(ctx context.Context, token string, n PushNotification) error {
+	bearer, err := a.token()
+	if err != nil {
+		return err
+	}
+	aps := map[string]interface{}{"alert": map[string]string{"title": n.Title, "body": n.Body}}
+	if n.Badge != nil {
+		aps["badge"] = *n.Badge
+	}
+	if n.Sound != "" {
+		aps["sound"] = n.Sound
+	}
+	body := map[string]interface{}{"aps": aps}
+	for k, v := range n.Data {
+		if k != "aps" {
+			body[k] = v
+		}
+	}
+	req := a.client.R().SetContext(ctx).
+		SetHeader("authorization", "bearer "+bearer).
+		SetHeader("apns-topic", a.topic).
+		SetHeader("apns-push-type", "alert").
+		SetHeader("apns-priority", "5")
+	if n.HighPriority {
+		req.SetHeader("apns-priority", "10")
+	}
+	if n.TTL > 0 {
+		req.SetHeader("apns-expiration", strconv.FormatInt(time.Now().Add(n.TTL).Unix(), 10))
+	}
+	if n.CollapseKey != "" {
+		req.SetHeader("apns-collapse-id", n.CollapseKey)
+	}
+	var fail struct {
+		Reason string `json:"reason"`
+	}
+	resp, err := req.SetBody(body).SetError(&fail).Post(a.url + "/3/device/" + token)
+	if err != nil {
+		return err
+	}
+	if !resp.IsError() {
+		return nil
+	}
+	switch fail.Reason {
+	case "BadDeviceToken", "Unregistered", "DeviceTokenNotForTopic":
+		return ERR_PUSH_INVALID_TOKEN
+	}
+	if resp.StatusCode() == http.StatusGone {
+		return ERR_PUSH_INVALID_TOKEN
+	}
+	return fmt.Errorf("apns request failed, status: %d, reason: %s", resp.StatusCode(), fail.Reason)
+}
+
+// PushInvalidTokenFunc is called with the tokens rejected as invalid, so they can be removed from storage.
+type PushInvalidTokenFunc func(ctx context.Context, tokens []string)
+
+// PushResult is the result of a batch send.
+type PushResult struct {
+	Sent    int
+	Failed  int
+	Invalid []string
+}
+
+// PushClient sends notifications to batches of tokens.
+type PushClient struct {
+	sender      PushSender
+	backend     string
+	concurrency int
+	onInvalid   PushInvalidTokenFunc
+	logger      *zap.Logger
+	counter     metric.Int64Counter
+}
+
+// NewPushClient creates the sender of params type, onInvalid may be nil.
+func NewPushClient(params *PushParams, onInvalid PushInvalidTokenFunc, zl *zap.Logger) (*PushClient, error) {
+	sender, err := NewPushSender(params)
+	if err != nil {
+		return nil, err
+	}
+	return NewPushClientWithSender(sender, params, onInvalid, zl), nil
+}
+
+// NewPushClientWithSender creates a push client with a custom sender, only the concurrency of params is used.
+func NewPushClientWithSender(sender PushSender, params *PushParams, onInvalid PushInvalidTokenFunc, zl *zap.Logger) *PushClient {
+	concurrency := params.Concurrency
+	if concurrency <= 0 {
+		concurrency = _defaultPushParams.Concurrency
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	counter, _ := otel.Meter(OTEL_INSTRUMENTATION).Int64Counter("push.notifications",
+		metric.WithDescription("Number of push notifications by backend and outcome."))
+	return &PushClient{
+		sender:      sender,
+		backend:     params.Type,
+		concurrency: concurrency,
+		onInvalid:   onInvalid,
+		logger:      zl.With(zap.String("module", "push"), zap.String("type", params.Type)),
+		counter:     counter,
+	}
+}
+
+// Push sends n to every token concurrently. Invalid tokens are reported to the invalid token callback once the
+// batch is done. The returned error joins the other failures.
+func (p *PushClient) Push(ctx context.Context, tokens []string, n PushNotification) (PushResult, error) {
+	var (
+		lock   sync.Mutex
+		result PushResult
+		errs   []error
+		g      errgroup.Group
+	)
+	g.SetLimit(p.concurrency)
+	for _, token := range tokens {
+		token := token
+		g.Go(func() error {
+			err := p.sender.Send(ctx, token, n)
+			outcome := "sent"
+			lock.Lock()
+			switch {
+			case err == nil:
+				result.Sent++
+			case errors.Is(err, ERR_PUSH_INVALID_TOKEN):
+				outcome = "invalid"
+				result.Invalid = append(result.Invalid, token)
+			default:
+				outcome = "failed"
+				result.Failed++
+				errs = append(errs, err)
+			}
+			lock.Unlock()
+			p.counter.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("push.backend", p.backend),
+				attribute.String("push.outcome", outcome)))
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if len(result.Invalid) > 0 && p.onInvalid != nil {
+		p.onInvalid(ctx, result.Invalid)
+	}
+	if result.Failed > 0 || len(result.Invalid) > 0 {
+		p.logger.Warn("[push] batch has failures",
+			zap.Int("sent", result.Sent),
+			zap.Int("failed", result.Failed),
+			zap.Int("invalid", len(result.Invalid)),
+			zap.Error(errors.Join(errs...)))
+	} else {
+		p.logger.Debug("[push] batch sent", zap.Int("sent", result.Sent))
+	}
+	return result, errors.Join(errs...)
+}
+
+type PushProvider interface {
+	Provider[*PushClient]
+}
+
+type pushProvider struct {
+	*GiuProvider[*PushClient]
+}
+
+// NewPushProvider creates a push provider from existing clients, if items is not empty, the first item will be set as default
+func NewPushProvider(clients ...map[string]*PushClient) PushProvider {
+	return &pushProvider{
+		GiuProvider: NewGiuProvider[*PushClient](clients...),
+	}
+}
+
+// NewPushProviderFromParams creates a push provider from params, if items is not empty, the first item will be set as default
+func NewPushProviderFromParams(params map[string]*PushParams, onInvalid PushInvalidTokenFunc, zl *zap.Logger) (PushProvider, error) {
+	giu, err := NewGiuProviderWithLoggerFromParamsError[*PushClient, *PushParams](func(p *PushParams, zl *zap.Logger) (*PushClient, error) {
+		return NewPushClient(p, onInvalid, zl)
+	}, params, zl)
+	if err != nil {
+		return nil, err
+	}
+	return &pushProvider{GiuProvider: giu}, nil
+}
+
+// NewPushProviderFromConfig creates a push provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
+func NewPushProviderFromConfig(config *viper.Viper, onInvalid PushInvalidTokenFunc, zl *zap.Logger) (PushProvider, error) {
+	var params map[string]*PushParams
+	if err := config.UnmarshalKey("push", &params); err != nil {
+		return nil, err
+	}
+	return NewPushProviderFromParams(params, onInvalid, zl)
+}
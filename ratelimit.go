@@ -0,0 +1,211 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type RateLimiterParams struct {
+	// Limit is the number of requests allowed in Period.
+	Limit int
+	// Period is the window of Limit, default is 1s.
+	Period time.Duration
+	// Burst is the bucket size of local limiter, default is Limit.
+	Burst int
+	// Prefix is the key prefix of redis limiter, default is "giu:ratelimit:".
+	Prefix string
+}
+
+var ERR_RATE_LIMITED = errors.New("rate limited")
+
+// Limiter decides whether an event of key is allowed.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+// WaitLimiter waits until the event of key is allowed or ctx is done, polling every interval.
+// The optional clock is the time source of polling, default is SystemClock.
+func WaitLimiter(ctx context.Context, l Limiter, key string, interval time.Duration, clock ...Clock) error {
+	c := SystemClock
+	if len(clock) > 0 {
+		c = clockOrSystem(clock[0])
+	}
+	for {
+		ok, err := l.Allow(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		t := c.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C():
+		}
+	}
+}
+
+func normalizeRateLimiterParams(params RateLimiterParams) RateLimiterParams {
+	if params.Period <= 0 {
+		params.Period = time.Second
+	}
+	if params.Burst <= 0 {
+		params.Burst = params.Limit
+	}
+	if params.Prefix == "" {
+		params.Prefix = "giu:ratelimit:"
+	}
+	return params
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// LocalLimiter is an in-memory token bucket limiter per key.
+type LocalLimiter struct {
+	params  RateLimiterParams
+	rate    float64 // tokens per nanosecond
+	clock   Clock
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewLocalLimiter(params RateLimiterParams) *LocalLimiter {
+	params = normalizeRateLimiterParams(params)
+	return &LocalLimiter{
+		params:  params,
+		rate:    float64(params.Limit) / float64(params.Period),
+		clock:   SystemClock,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// SetClock sets the time source of the limiter, nil means SystemClock.
+func (l *LocalLimiter) SetClock(c Clock) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.clock = clockOrSystem(c)
+}
+
+func (l *LocalLimiter) Allow(_ context.Context, key string) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.clock.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.params.Burst), last: now}
+		l.buckets[key] = b
+		l.gc(now)
+	}
+	b.tokens += float64(now.Sub(b.last)) * l.rate
+	if b.tokens > float64(l.params.Burst) {
+		b.tokens = float64(l.params.Burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, nil
+	}
+	b.tokens--
+	return true, nil
+}
+
+// gc removes the full buckets when there are too many keys, they are the same as new ones.
+func (l *LocalLimiter) gc(now time.Time) {
+	if len(l.buckets) < 10000 {
+		return
+	}
+	full := time.Duration(float64(l.params.Burst) / l.rate)
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// slidingWindowScript keeps the timestamps of allowed events in a sorted set.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+if redis.call('ZCARD', key) >= limit then
+	return 0
+end
+redis.call('ZADD', key, now, ARGV[4])
+redis.call('PEXPIRE', key, math.ceil(window / 1000))
+return 1
+`)
+
+// RedisLimiter is a sliding window limiter shared by all instances through redis.
+type RedisLimiter struct {
+	rdb    redis.UniversalClient
+	params RateLimiterParams
+	clock  Clock
+}
+
+func NewRedisLimiter(rdb redis.UniversalClient, params RateLimiterParams) *RedisLimiter {
+	return &RedisLimiter{rdb: rdb, params: normalizeRateLimiterParams(params), clock: SystemClock}
+}
+
+// SetClock sets the time source of the limiter, nil means SystemClock.
+// The clocks of all instances sharing the limiter should be the same.
+func (l *RedisLimiter) SetClock(c Clock) {
+	l.clock = clockOrSystem(c)
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	now := l.clock.Now().UnixMicro()
+	window := l.params.Period.Microseconds()
+	member := strconv.FormatInt(now, 10) + "-" + uuid.NewString()
+	res, err := slidingWindowScript.Run(ctx, l.rdb, []string{l.params.Prefix + key}, now, window, l.params.Limit, member).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// NewGinMiddlewareRateLimit returns a gin middleware which responds 429 when the key of request is limited.
+// If keyFunc is nil, the client ip is used as key. Requests are allowed when the limiter fails.
+func NewGinMiddlewareRateLimit(l Limiter, keyFunc func(c *gin.Context) string) gin.HandlerFunc {
+	if keyFunc == nil {
+		keyFunc = GinClientIP
+	}
+	return func(c *gin.Context) {
+		ok, err := l.Allow(c.Request.Context(), keyFunc(c))
+		if err == nil && !ok {
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// SetRestyRateLimit limits the outgoing requests of the client with key, requests over limit fail with ERR_RATE_LIMITED.
+func SetRestyRateLimit(client *resty.Client, l Limiter, key string) {
+	client.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+		ok, err := l.Allow(r.Context(), key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s %s", ERR_RATE_LIMITED, r.Method, r.URL)
+		}
+		return nil
+	})
+}
@@ -1,7 +1,18 @@
 package giu
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type RedisParams = redis.UniversalOptions
@@ -36,3 +47,203 @@ func NewFailOverRedisClient(addrs []string, masterName string) redis.UniversalCl
 func DefaultRedis() redis.UniversalClient {
 	return NewRedis(&_defaultRedisOptions)
 }
+
+// RedisReplicaParams registers the read replicas of a standalone or failover redis. Cluster clients should use
+// the ReadOnly and RouteByLatency options instead.
+type RedisReplicaParams struct {
+	Replicas []*RedisParams
+	// Commands are the lower case names of commands routed to replicas, default is REDIS_READONLY_COMMANDS.
+	Commands []string
+	// Cooldown is how long a replica is skipped after a connection error, default is 5s.
+	Cooldown time.Duration
+}
+
+// REDIS_READONLY_COMMANDS is the default set of commands routed to replicas.
+var REDIS_READONLY_COMMANDS = []string{
+	"get", "mget", "getrange", "strlen", "exists", "ttl", "pttl", "type", "getbit", "bitcount", "scan",
+	"hget", "hmget", "hgetall", "hexists", "hlen", "hkeys", "hvals", "hstrlen", "hscan",
+	"lrange", "llen", "lindex",
+	"smembers", "sismember", "smismember", "scard", "srandmember", "sscan",
+	"zrange", "zrangebyscore", "zrevrange", "zrevrangebyscore", "zrangebylex", "zscore", "zmscore",
+	"zcard", "zcount", "zrank", "zrevrank", "zscan",
+	"pfcount", "geopos", "geodist", "geosearch", "geohash", "xrange", "xrevrange", "xlen",
+}
+
+var _defaultRedisReplicaParams = RedisReplicaParams{
+	Cooldown: 5 * time.Second,
+}
+
+type redisReplica struct {
+	client    redis.UniversalClient
+	downUntil atomic.Int64
+}
+
+// redisReplicaHook routes the read-only commands to the replicas in turn. Commands fall back to the primary when
+// the replica has a connection error, the replica is skipped until the cooldown ends. Pipelines and transactions
+// always use the primary.
+type redisReplicaHook struct {
+	replicas []*redisReplica
+	commands map[string]bool
+	cooldown time.Duration
+	next     atomic.Uint64
+}
+
+func (h *redisReplicaHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *redisReplicaHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (h *redisReplicaHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !h.commands[cmd.Name()] {
+			return next(ctx, cmd)
+		}
+		now := time.Now().UnixNano()
+		start := h.next.Add(1)
+		for i := range h.replicas {
+			r := h.replicas[(start+uint64(i))%uint64(len(h.replicas))]
+			if r.downUntil.Load() > now {
+				continue
+			}
+			err := r.client.Process(ctx, cmd)
+			var redisErr redis.Error
+			// nil replies and server errors are results, the others mean the replica is unreachable
+			if err == nil || errors.As(err, &redisErr) || ctx.Err() != nil {
+				return err
+			}
+			r.downUntil.Store(time.Now().Add(h.cooldown).UnixNano())
+		}
+		return next(ctx, cmd)
+	}
+}
+
+// NewRedisWithReplicas adds the read replicas to primary, the returned client is primary itself.
+// The replica clients are returned too, so they can be closed with primary.
+func NewRedisWithReplicas(primary redis.UniversalClient, params *RedisReplicaParams) (redis.UniversalClient, []redis.UniversalClient) {
+	if len(params.Replicas) == 0 {
+		return primary, nil
+	}
+	commands := params.Commands
+	if len(commands) == 0 {
+		commands = REDIS_READONLY_COMMANDS
+	}
+	cooldown := params.Cooldown
+	if cooldown <= 0 {
+		cooldown = _defaultRedisReplicaParams.Cooldown
+	}
+	hook := &redisReplicaHook{commands: make(map[string]bool, len(commands)), cooldown: cooldown}
+	for _, c := range commands {
+		hook.commands[strings.ToLower(c)] = true
+	}
+	clients := make([]redis.UniversalClient, 0, len(params.Replicas))
+	for _, p := range params.Replicas {
+		client := NewRedis(p)
+		clients = append(clients, client)
+		hook.replicas = append(hook.replicas, &redisReplica{client: client})
+	}
+	primary.AddHook(hook)
+	return primary, clients
+}
+
+// REDIS_PIPELINE_CHUNK_SIZE is the max commands of a pipeline round trip of PipelineEach and MGetTyped.
+var REDIS_PIPELINE_CHUNK_SIZE = 1000
+
+var redisPipelineSize, _ = otel.Meter(OTEL_INSTRUMENTATION).Int64Histogram("redis.pipeline.size",
+	metric.WithUnit("{command}"),
+	metric.WithDescription("Number of commands of redis pipeline round trips."))
+
+// Pipeline queues commands with fn and executes them in a round trip. Unlike go-redis, a redis.Nil reply is not
+// returned as the error, check the commands for their own errors.
+func Pipeline(ctx context.Context, rdb redis.UniversalClient, fn func(pipe redis.Pipeliner) error) ([]redis.Cmder, error) {
+	pipe := rdb.Pipeline()
+	if err := fn(pipe); err != nil {
+		pipe.Discard()
+		return nil, err
+	}
+	n := pipe.Len()
+	if n == 0 {
+		return nil, nil
+	}
+	redisPipelineSize.Record(ctx, int64(n), metric.WithAttributes(attribute.String("redis.pipeline.op", "pipeline")))
+	cmds, err := pipe.Exec(ctx)
+	if errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	return cmds, err
+}
+
+// PipelineEach queues the commands of every item with fn, and executes them in chunks of REDIS_PIPELINE_CHUNK_SIZE
+// items, so very large batches don't block the connection or the server. It stops at the first failed chunk.
+func PipelineEach[T any](ctx context.Context, rdb redis.UniversalClient, items []T, fn func(pipe redis.Pipeliner, item T)) ([]redis.Cmder, error) {
+	cmds := make([]redis.Cmder, 0, len(items))
+	for start := 0; start < len(items); start += REDIS_PIPELINE_CHUNK_SIZE {
+		chunk := items[start:min(start+REDIS_PIPELINE_CHUNK_SIZE, len(items))]
+		res, err := Pipeline(ctx, rdb, func(pipe redis.Pipeliner) error {
+			for _, item := range chunk {
+				fn(pipe, item)
+			}
+			return nil
+		})
+		cmds = append(cmds, res...)
+		if err != nil {
+			return cmds, err
+		}
+	}
+	return cmds, nil
+}
+
+// MGetTyped gets the json values of keys in chunks, missing keys are not in the result. Cluster clients get the
+// keys with pipelined GET, because MGET can't cross slots.
+func MGetTyped[T any](ctx context.Context, rdb redis.UniversalClient, keys []string) (map[string]T, error) {
+	result := make(map[string]T, len(keys))
+	decode := func(key string, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return nil
+		}
+		var t T
+		if err := json.Unmarshal([]byte(s), &t); err != nil {
+			return fmt.Errorf("decode %s: %w", key, err)
+		}
+		result[key] = t
+		return nil
+	}
+	if _, ok := rdb.(*redis.ClusterClient); ok {
+		cmds, err := PipelineEach(ctx, rdb, keys, func(pipe redis.Pipeliner, key string) {
+			pipe.Get(ctx, key)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i, cmd := range cmds {
+			v, err := cmd.(*redis.StringCmd).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := decode(keys[i], v); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	for start := 0; start < len(keys); start += REDIS_PIPELINE_CHUNK_SIZE {
+		chunk := keys[start:min(start+REDIS_PIPELINE_CHUNK_SIZE, len(keys))]
+		redisPipelineSize.Record(ctx, int64(len(chunk)), metric.WithAttributes(attribute.String("redis.pipeline.op", "mget")))
+		values, err := rdb.MGet(ctx, chunk...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			if err := decode(chunk[i], v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
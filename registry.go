@@ -0,0 +1,97 @@
+package giu
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var ERR_REGISTRY_NOT_FOUND = errors.New("registry item not found")
+
+// Registry is the package-level service locator, the items are registered by type and name, so the modules can
+// look them up without threading providers through every constructor. It's optional, prefer passing providers
+// explicitly when possible.
+var Registry = &registry{
+	items:     make(map[registryKey]interface{}),
+	providers: make(map[reflect.Type]interface{}),
+}
+
+type registryKey struct {
+	t    reflect.Type
+	name string
+}
+
+type registry struct {
+	lock      sync.RWMutex
+	items     map[registryKey]interface{}
+	providers map[reflect.Type]interface{}
+}
+
+// Reset removes all registered items and providers, e.g. between tests.
+func (r *registry) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.items = make(map[registryKey]interface{})
+	r.providers = make(map[reflect.Type]interface{})
+}
+
+func registryType[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Register registers v as name of type T, the previous one of the same type and name is replaced.
+func Register[T any](name string, v T) {
+	Registry.lock.Lock()
+	defer Registry.lock.Unlock()
+	Registry.items[registryKey{t: registryType[T](), name: name}] = v
+}
+
+// RegisterProvider registers p as the fallback of type T, Resolve looks up the items of p which are not registered
+// by Register, so the items replaced by reloads are resolved too. An empty name resolves the default item of p.
+func RegisterProvider[T any](p Provider[T]) {
+	Registry.lock.Lock()
+	defer Registry.lock.Unlock()
+	Registry.providers[registryType[T]()] = p
+}
+
+// Unregister removes the item of type T and name.
+func Unregister[T any](name string) {
+	Registry.lock.Lock()
+	defer Registry.lock.Unlock()
+	delete(Registry.items, registryKey{t: registryType[T](), name: name})
+}
+
+// Resolve returns the item of type T and name, it returns ERR_REGISTRY_NOT_FOUND if none is registered.
+func Resolve[T any](name string) (T, error) {
+	t := registryType[T]()
+	Registry.lock.RLock()
+	v, ok := Registry.items[registryKey{t: t, name: name}]
+	p, hasProvider := Registry.providers[t]
+	Registry.lock.RUnlock()
+	if ok {
+		return v.(T), nil
+	}
+	if hasProvider {
+		provider := p.(Provider[T])
+		if name == "" {
+			// the default may be a lazy item, which is not in Keys until it's built
+			if v := provider.Default(); !reflect.ValueOf(&v).Elem().IsZero() {
+				return v, nil
+			}
+		} else if v, err := provider.GetE(name); err == nil {
+			return v, nil
+		}
+	}
+	var zero T
+	return zero, fmt.Errorf("%w: %s %q", ERR_REGISTRY_NOT_FOUND, t, name)
+}
+
+// MustResolve is like Resolve, but it panics if the item is not found.
+func MustResolve[T any](name string) T {
+	v, err := Resolve[T](name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
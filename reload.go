@@ -0,0 +1,177 @@
+package giu
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ProviderReloader rebuilds the changed items of providers when the viper config changes, e.g. to rotate database
+// passwords without restarts. The new items are swapped in, the replaced ones are closed after the drain delay,
+// so the requests using them can finish.
+type ProviderReloader struct {
+	config   *viper.Viper
+	drain    time.Duration
+	logger   *zap.Logger
+	lock     sync.Mutex
+	sections []func()
+}
+
+func NewProviderReloader(config *viper.Viper, drain time.Duration, zl *zap.Logger) *ProviderReloader {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &ProviderReloader{config: config, drain: drain, logger: zl.With(zap.String("module", "reload"))}
+}
+
+// Watch watches the config file and reloads on changes, it replaces the OnConfigChange func of the config.
+func (r *ProviderReloader) Watch() {
+	r.config.OnConfigChange(func(fsnotify.Event) {
+		r.Reload()
+	})
+	r.config.WatchConfig()
+}
+
+// Reload rebuilds the changed items of all watched providers.
+func (r *ProviderReloader) Reload() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, reload := range r.sections {
+		reload()
+	}
+}
+
+// fingerprints returns the params of each named item under key as json, so the changed items can be found.
+func (r *ProviderReloader) fingerprints(key string) map[string]string {
+	prints := make(map[string]string)
+	items, _ := r.config.Get(key).(map[string]interface{})
+	for name, v := range items {
+		data, _ := json.Marshal(v)
+		prints[name] = string(data)
+	}
+	return prints
+}
+
+// WatchProvider registers the items of p under config key. When the params of an item change, newFunc builds a new
+// one which replaces it, and closeFunc closes the old one after the drain delay. Items removed from the config are
+// removed from p and closed after the drain delay, unless they're added again meanwhile. If newFunc fails, the old item is kept and it's retried on the next change.
+func WatchProvider[T any, U any](r *ProviderReloader, p Provider[T], key string, newFunc func(U) (T, error), closeFunc func(T) error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	prints := r.fingerprints(key)
+	// gens counts the changes of each item, so a delayed removal can tell the item was added again
+	gens := make(map[string]uint64)
+	logger := r.logger.With(zap.String("key", key))
+	r.sections = append(r.sections, func() {
+		next := r.fingerprints(key)
+		var params map[string]U
+		if err := r.config.UnmarshalKey(key, &params); err != nil {
+			logger.Error("[reload] unmarshal config failed", zap.Error(err))
+			return
+		}
+		for name, fp := range next {
+			name := name
+			if prints[name] == fp {
+				continue
+			}
+			item, err := newFunc(params[name])
+			if err != nil {
+				logger.Error("[reload] rebuild failed, the old item is kept", zap.String("name", name), zap.Error(err))
+				continue
+			}
+			old, existed := p.Replace(name, item)
+			prints[name] = fp
+			gens[name]++
+			logger.Info("[reload] item reloaded", zap.String("name", name))
+			if existed {
+				time.AfterFunc(r.drain, func() {
+					if err := closeFunc(old); err != nil {
+						logger.Warn("[reload] close replaced item failed", zap.String("name", name), zap.Error(err))
+					}
+				})
+			}
+		}
+		for name := range prints {
+			name := name
+			if _, ok := next[name]; ok {
+				continue
+			}
+			delete(prints, name)
+			logger.Info("[reload] item removed", zap.String("name", name))
+			old, ok := p.Get(name)
+			if !ok {
+				continue
+			}
+			gen := gens[name]
+			time.AfterFunc(r.drain, func() {
+				r.lock.Lock()
+				readded := gens[name] != gen
+				r.lock.Unlock()
+				// the item may be added again during the drain, only the removed instance goes
+				if readded {
+					return
+				}
+				if err := removeProviderItem(p, name, old, closeFunc); err != nil {
+					logger.Warn("[reload] remove item failed", zap.String("name", name), zap.Error(err))
+				}
+			})
+		}
+	})
+}
+
+// removeProviderItem removes the item of name from p and closes it with closeFunc, if it's still old.
+func removeProviderItem[T any](p Provider[T], name string, old T, closeFunc func(T) error) error {
+	same := func(v T) bool {
+		return sameProviderItem(v, old)
+	}
+	if rp, ok := p.(interface {
+		removeIf(name string, match func(v T) bool, closeFunc func(v T) error) error
+	}); ok {
+		return rp.removeIf(name, same, closeFunc)
+	}
+	// other providers close the item by Remove
+	if cur, ok := p.Get(name); !ok || !same(cur) {
+		return nil
+	}
+	return p.Remove(name)
+}
+
+// sameProviderItem reports whether a and b are the same item, items of types which are not comparable are
+// treated as the same.
+func sameProviderItem[T any](a, b T) bool {
+	x, y := any(a), any(b)
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	if reflect.TypeOf(x) != reflect.TypeOf(y) {
+		return false
+	}
+	if !reflect.TypeOf(x).Comparable() {
+		return true
+	}
+	return x == y
+}
+
+// WatchConfig reloads the changed items of the logger, gorm_connection and redis sections on config changes, the
+// replaced items are closed after drain. Gorm connections are rebuilt with the gorm_config of Bootstrap, and the
+// reloaded redis clients don't route reads to replicas.
+func (app *App) WatchConfig(drain time.Duration) *ProviderReloader {
+	logger := app.Logger.Default()
+	r := NewProviderReloader(app.Viper, drain, logger)
+	WatchProvider[*zap.Logger, *LoggerParams](r, app.Logger, "logger", NewZapLoggerWithCheck, closeZapLogger)
+	WatchProvider[*gorm.DB, *GormConnectionParams](r, app.Gorm, "gorm_connection", func(p *GormConnectionParams) (*gorm.DB, error) {
+		return NewGormWithLogger(*p, logger, app.Config.GormConfig)
+	}, closeGorm)
+	WatchProvider[redis.UniversalClient, *RedisParams](r, app.Redis, "redis", func(p *RedisParams) (redis.UniversalClient, error) {
+		return NewRedis(p), nil
+	}, redis.UniversalClient.Close)
+	r.Watch()
+	return r
+}
@@ -0,0 +1,342 @@
+package giu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+	"github.com/spf13/viper"
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+)
+
+// ReportColumn is a column of a report template.
+type ReportColumn struct {
+	Title string
+	// Field is the struct field name, json tag or map key of the row value.
+	Field string
+	// Width is the column width, in characters for excel and in mm for pdf, default is even widths.
+	Width float64
+	// Format is the go time layout of time values in pdf, default is "2006-01-02 15:04:05".
+	// Excel keeps time values as dates formatted "yyyy-mm-dd hh:mm:ss".
+	Format string
+}
+
+// ReportTemplate describes the layout of a report.
+type ReportTemplate struct {
+	Title   string
+	Columns []ReportColumn
+	// Landscape is the page orientation of pdf.
+	Landscape bool
+}
+
+type ReportParams struct {
+	Templates map[string]ReportTemplate
+	// FontFile is a ttf font of pdf reports, required for non latin text, default is helvetica.
+	FontFile string
+	// Prefix is the storage key prefix of saved reports, default is "reports/".
+	Prefix string
+}
+
+var _defaultReportParams = ReportParams{
+	Prefix: "reports/",
+}
+
+const (
+	REPORT_FORMAT_XLSX = "xlsx"
+	REPORT_FORMAT_PDF  = "pdf"
+)
+
+var (
+	ERR_REPORT_TEMPLATE_NOT_FOUND = errors.New("report template not found")
+	ERR_REPORT_NO_STORAGE         = errors.New("report storage is not configured")
+)
+
+// ReportSource emits the rows of a report one by one, so large results don't need to be loaded at once,
+// e.g. iterating gorm Rows. The row is a struct, a pointer to struct or a map[string]interface{}.
+type ReportSource func(emit func(row interface{}) error) error
+
+// ReportSlice returns a source of rows.
+func ReportSlice[T any](rows []T) ReportSource {
+	return func(emit func(row interface{}) error) error {
+		for i := range rows {
+			if err := emit(rows[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Reporter generates excel and pdf reports from templates.
+type Reporter struct {
+	params  ReportParams
+	font    []byte
+	storage ProfileStorage
+	logger  *zap.Logger
+}
+
+// NewReporter creates a reporter, storage is used by Save and may be nil, e.g. the DirProfileStorage of profiles.
+func NewReporter(params ReportParams, storage ProfileStorage, zl *zap.Logger) (*Reporter, error) {
+	if params.Prefix == "" {
+		params.Prefix = _defaultReportParams.Prefix
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	r := &Reporter{params: params, storage: storage, logger: zl.With(zap.String("module", "report"))}
+	if params.FontFile != "" {
+		font, err := os.ReadFile(params.FontFile)
+		if err != nil {
+			return nil, err
+		}
+		r.font = font
+	}
+	return r, nil
+}
+
+// NewReporterFromConfig creates a reporter from viper config with key "report".
+func NewReporterFromConfig(config *viper.Viper, storage ProfileStorage, zl *zap.Logger) (*Reporter, error) {
+	var params ReportParams
+	if err := config.UnmarshalKey("report", &params); err != nil {
+		return nil, err
+	}
+	return NewReporter(params, storage, zl)
+}
+
+func (r *Reporter) template(name string) (ReportTemplate, error) {
+	t, ok := r.params.Templates[name]
+	if !ok {
+		return t, fmt.Errorf("%w: %s", ERR_REPORT_TEMPLATE_NOT_FOUND, name)
+	}
+	return t, nil
+}
+
+// reportValue returns the value of field in row, nil if it's missing.
+func reportValue(row interface{}, field string) interface{} {
+	if m, ok := row.(map[string]interface{}); ok {
+		return m[field]
+	}
+	v := reflect.Indirect(reflect.ValueOf(row))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	if f := v.FieldByName(field); f.IsValid() {
+		return f.Interface()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); tag == field && t.Field(i).IsExported() {
+			return v.Field(i).Interface()
+		}
+	}
+	return nil
+}
+
+func reportText(v interface{}, col ReportColumn) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if col.Format != "" {
+			return x.Format(col.Format)
+		}
+		return x.Format(time.DateTime)
+	case *time.Time:
+		if x == nil {
+			return ""
+		}
+		return reportText(*x, col)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// Render writes the report of template name in format to w.
+func (r *Reporter) Render(ctx context.Context, w io.Writer, format, name string, src ReportSource) error {
+	t, err := r.template(name)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	var rows int
+	counted := func(row interface{}) error {
+		if rows++; rows%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch format {
+	case REPORT_FORMAT_XLSX:
+		err = r.renderExcel(w, t, src, counted)
+	case REPORT_FORMAT_PDF:
+		err = r.renderPdf(w, t, src, counted)
+	default:
+		err = fmt.Errorf("unsupported report format: %s", format)
+	}
+	if err != nil {
+		r.logger.Warn("[report] render failed", zap.Error(err), zap.String("template", name), zap.String("format", format))
+		return err
+	}
+	r.logger.Info("[report] rendered",
+		zap.String("template", name),
+		zap.String("format", format),
+		zap.Int("rows", rows),
+		zap.Duration("latency", time.Since(start)))
+	return nil
+}
+
+func (r *Reporter) renderExcel(w io.Writer, t ReportTemplate, src ReportSource, counted func(interface{}) error) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	layout := "yyyy-mm-dd hh:mm:ss"
+	timeStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &layout})
+	if err != nil {
+		return err
+	}
+	header := make([]interface{}, len(t.Columns))
+	for i, col := range t.Columns {
+		header[i] = excelize.Cell{StyleID: bold, Value: col.Title}
+		if col.Width > 0 {
+			if err := sw.SetColWidth(i+1, i+1, col.Width); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+	line := 1
+	err = src(func(row interface{}) error {
+		if err := counted(row); err != nil {
+			return err
+		}
+		line++
+		values := make([]interface{}, len(t.Columns))
+		for i, col := range t.Columns {
+			v := reportValue(row, col.Field)
+			if tm, ok := v.(time.Time); ok {
+				v = excelize.Cell{StyleID: timeStyle, Value: tm}
+			}
+			values[i] = v
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, line)
+		return sw.SetRow(cell, values)
+	})
+	if err != nil {
+		return err
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	return f.Write(w)
+}
+
+func (r *Reporter) renderPdf(w io.Writer, t ReportTemplate, src ReportSource, counted func(interface{}) error) error {
+	orientation := "P"
+	if t.Landscape {
+		orientation = "L"
+	}
+	pdf := fpdf.New(orientation, "mm", "A4", "")
+	family := "Helvetica"
+	if r.font != nil {
+		family = "giu"
+		pdf.AddUTF8FontFromBytes(family, "", r.font)
+		pdf.AddUTF8FontFromBytes(family, "B", r.font)
+	}
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	widths := make([]float64, len(t.Columns))
+	var fixed float64
+	var flexible int
+	for i, col := range t.Columns {
+		widths[i] = col.Width
+		if col.Width > 0 {
+			fixed += col.Width
+		} else {
+			flexible++
+		}
+	}
+	for i := range widths {
+		if widths[i] <= 0 {
+			widths[i] = (pageWidth - left - right - fixed) / float64(flexible)
+		}
+	}
+	pdf.SetHeaderFunc(func() {
+		if t.Title != "" && pdf.PageNo() == 1 {
+			pdf.SetFont(family, "B", 14)
+			pdf.CellFormat(0, 10, t.Title, "", 1, "L", false, 0, "")
+		}
+		pdf.SetFont(family, "B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		for i, col := range t.Columns {
+			pdf.CellFormat(widths[i], 7, col.Title, "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont(family, "", 9)
+	})
+	pdf.AddPage()
+	err := src(func(row interface{}) error {
+		if err := counted(row); err != nil {
+			return err
+		}
+		for i, col := range t.Columns {
+			pdf.CellFormat(widths[i], 6, reportText(reportValue(row, col.Field), col), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+		return pdf.Error()
+	})
+	if err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+// Respond streams the report to the response as an attachment named filename.
+// The status is already sent when rendering fails, so the error is only logged and added to the gin context.
+func (r *Reporter) Respond(c *gin.Context, format, name, filename string, src ReportSource) {
+	contentType := "application/pdf"
+	if format == REPORT_FORMAT_XLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(filename))
+	c.Status(http.StatusOK)
+	if err := r.Render(c.Request.Context(), c.Writer, format, name, src); err != nil {
+		_ = c.Error(err)
+		c.Abort()
+	}
+}
+
+// Save renders the report and puts it to the storage with key Prefix + key.
+func (r *Reporter) Save(ctx context.Context, format, name, key string, src ReportSource) error {
+	if r.storage == nil {
+		return ERR_REPORT_NO_STORAGE
+	}
+	var buf bytes.Buffer
+	if err := r.Render(ctx, &buf, format, name, src); err != nil {
+		return err
+	}
+	return r.storage.Put(ctx, r.params.Prefix+key, buf.Bytes())
+}
@@ -0,0 +1,153 @@
+package giu
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormTxKey struct{}
+
+// WithTx returns a copy of ctx with the transaction, repositories use it when they get the ctx.
+func WithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, gormTxKey{}, tx)
+}
+
+// TxFromContext returns the transaction in ctx, if not found, it returns false.
+func TxFromContext(ctx context.Context) (*gorm.DB, bool) {
+	tx, ok := ctx.Value(gormTxKey{}).(*gorm.DB)
+	return tx, ok && tx != nil
+}
+
+// Transaction runs fn in a transaction of db, the transaction is passed to fn by ctx.
+// If ctx already has a transaction, a nested transaction (savepoint) is used.
+// The transaction is traced as a span, statements of the repositories using ctx are its children.
+func Transaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) (err error) {
+	nested := false
+	if tx, ok := TxFromContext(ctx); ok {
+		db, nested = tx, true
+	}
+	ctx, span := otel.Tracer(OTEL_INSTRUMENTATION).Start(ctx, "gorm.transaction",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Bool("db.transaction.nested", nested)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(WithTx(ctx, tx))
+	})
+}
+
+// Repository is a generic CRUD data layer of model T.
+// Soft delete is handled by gorm when T has a gorm.DeletedAt field, use Unscoped to include or purge deleted rows.
+type Repository[T any] struct {
+	db       *gorm.DB
+	unscoped bool
+}
+
+func NewRepository[T any](db *gorm.DB) *Repository[T] {
+	return &Repository[T]{db: db}
+}
+
+// NewRepositoryFromProvider creates a repository bound to the named connection of the provider.
+func NewRepositoryFromProvider[T any](p GormProvider, name string) (*Repository[T], error) {
+	db, ok := p.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("gorm connection not found: %s", name)
+	}
+	return NewRepository[T](db), nil
+}
+
+// Unscoped returns a repository which includes soft deleted rows, and Delete removes rows permanently.
+func (r *Repository[T]) Unscoped() *Repository[T] {
+	return &Repository[T]{db: r.db, unscoped: true}
+}
+
+// DB returns the db of ctx: the transaction in ctx if any, otherwise the bound connection.
+func (r *Repository[T]) DB(ctx context.Context) *gorm.DB {
+	db := r.db
+	if tx, ok := TxFromContext(ctx); ok {
+		db = tx
+	}
+	db = db.WithContext(ctx)
+	if r.unscoped {
+		db = db.Unscoped()
+	}
+	return db
+}
+
+// Transaction runs fn in a transaction of the bound connection, see Transaction.
+func (r *Repository[T]) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transaction(ctx, r.db, fn)
+}
+
+func (r *Repository[T]) Create(ctx context.Context, v *T) error {
+	return r.DB(ctx).Create(v).Error
+}
+
+func (r *Repository[T]) CreateInBatches(ctx context.Context, vs []T, batchSize int) error {
+	return r.DB(ctx).CreateInBatches(vs, batchSize).Error
+}
+
+// Get returns the item by primary key, gorm.ErrRecordNotFound is returned if it's not found.
+func (r *Repository[T]) Get(ctx context.Context, id interface{}) (*T, error) {
+	var v T
+	if err := r.DB(ctx).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).First(&v).Error; err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// First returns the first item matching the scopes, gorm.ErrRecordNotFound is returned if it's not found.
+func (r *Repository[T]) First(ctx context.Context, scopes ...func(*gorm.DB) *gorm.DB) (*T, error) {
+	var v T
+	if err := r.DB(ctx).Scopes(scopes...).First(&v).Error; err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// List returns the items matching the scopes.
+func (r *Repository[T]) List(ctx context.Context, scopes ...func(*gorm.DB) *gorm.DB) ([]T, error) {
+	vs := make([]T, 0)
+	if err := r.DB(ctx).Scopes(scopes...).Find(&vs).Error; err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// Page returns the paged items matching the scopes, see Paginator.
+func (r *Repository[T]) Page(ctx context.Context, page *Page, scopes ...func(*gorm.DB) *gorm.DB) (PagedResponse[T], error) {
+	return Paginate[T](r.DB(ctx).Scopes(scopes...), page)
+}
+
+func (r *Repository[T]) Count(ctx context.Context, scopes ...func(*gorm.DB) *gorm.DB) (int64, error) {
+	var count int64
+	err := r.DB(ctx).Model(new(T)).Scopes(scopes...).Count(&count).Error
+	return count, err
+}
+
+// Update saves all fields of v.
+func (r *Repository[T]) Update(ctx context.Context, v *T) error {
+	return r.DB(ctx).Save(v).Error
+}
+
+// Updates updates the given fields of the item by primary key, values can be a map or a struct.
+func (r *Repository[T]) Updates(ctx context.Context, id interface{}, values interface{}) error {
+	return r.DB(ctx).Model(new(T)).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Updates(values).Error
+}
+
+// Delete deletes the item by primary key, it's a soft delete if T supports it and the repository is not unscoped.
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) error {
+	return r.DB(ctx).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(new(T)).Error
+}
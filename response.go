@@ -0,0 +1,52 @@
+package giu
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// ErrorResponse is the standard error envelope of giu handlers.
+type ErrorResponse struct {
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	TraceID string       `json:"trace_id,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes a field which failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+}
+
+// NewErrorResponse creates an error envelope, validation errors are expanded to field errors.
+func NewErrorResponse(c *gin.Context, code int, err error) ErrorResponse {
+	resp := ErrorResponse{Code: code, Message: err.Error()}
+	if c != nil {
+		resp.TraceID = c.GetHeader(GIN_TRACE_ID)
+		if resp.TraceID == "" {
+			resp.TraceID = c.Writer.Header().Get(GIN_TRACE_ID)
+		}
+	}
+	var ve validator.ValidationErrors
+	if errors.As(err, &ve) {
+		resp.Message = "validation failed"
+		for _, fe := range ve {
+			resp.Errors = append(resp.Errors, FieldError{
+				Field:   fe.Field(),
+				Tag:     fe.Tag(),
+				Message: fe.Error(),
+			})
+		}
+	}
+	return resp
+}
+
+// AbortWithErrorResponse aborts the request and writes the error envelope with the status code.
+func AbortWithErrorResponse(c *gin.Context, code int, err error) {
+	_ = c.Error(err)
+	c.AbortWithStatusJSON(code, NewErrorResponse(c, code, err))
+}
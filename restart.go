@@ -0,0 +1,88 @@
+//go:build !windows
+
+package giu
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// handleRestart starts a new process with the listener on SIGUSR2 and shuts down the current one.
+func (s *GinServer) handleRestart() func() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				if err := s.restart(); err != nil {
+					s.logger.Error("[server] graceful restart failed", zap.Error(err))
+					continue
+				}
+				if err := s.Shutdown(); err != nil {
+					s.logger.Error("[server] shutdown after restart failed", zap.Error(err))
+				}
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+// restart starts a new process with the listener as fd 3, and the acme http listener as fd 4 if any.
+func (s *GinServer) restart() error {
+	s.lock.Lock()
+	l, al := s.listener, s.acmeListener
+	s.lock.Unlock()
+	f, err := listenerFile(l)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	files := []*os.File{f}
+	env := append(os.Environ(), GIU_ENV_INHERIT_LISTENER+"=1")
+	if al != nil {
+		af, err := listenerFile(al)
+		if err != nil {
+			return err
+		}
+		defer af.Close()
+		files = append(files, af)
+		env = append(env, GIU_ENV_INHERIT_ACME_LISTENER+"=1")
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.logger.Info("[server] new process started", zap.Int("pid", cmd.Process.Pid))
+	return nil
+}
+
+func listenerFile(l net.Listener) (*os.File, error) {
+	fl, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("listener does not support file handoff")
+	}
+	return fl.File()
+}
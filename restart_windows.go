@@ -0,0 +1,9 @@
+//go:build windows
+
+package giu
+
+// handleRestart is a no-op on windows, fd handoff is not supported.
+func (s *GinServer) handleRestart() func() {
+	s.logger.Warn("[server] graceful restart is not supported on windows")
+	return func() {}
+}
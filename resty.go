@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
@@ -19,6 +20,11 @@ type RestyParams struct {
 	// StructLog is the flag to enable/disable simple request&response struct log. It's only work when resty is init with zap logger.
 	// When it's enabled, it will set debug mode to true. Struct log will print in info level.
 	StructLog bool
+	// Propagate sends the request scope, the otel baggage and the remaining time of the request context as headers.
+	// The headers carry user and tenant identities, enable it only for internal services.
+	Propagate bool
+	// PropagateHosts limits Propagate to the hosts, ".example.com" matches the subdomains, empty means all hosts.
+	PropagateHosts []string
 }
 
 var _defaultRestyParams = &RestyParams{
@@ -30,6 +36,9 @@ var _defaultRestyParams = &RestyParams{
 
 func NewResty(options *RestyParams) *resty.Client {
 	client := resty.New()
+	if debugToggled() {
+		client.SetDebug(true)
+	}
 	if options == nil {
 		return client
 	}
@@ -42,6 +51,9 @@ func NewResty(options *RestyParams) *resty.Client {
 	if options.DebugMode {
 		client.SetDebug(true)
 	}
+	if options.Propagate {
+		client.OnBeforeRequest(restyRequestScopeMiddleware(options.PropagateHosts))
+	}
 	return client
 }
 
@@ -74,3 +86,30 @@ func restyLogToZapFields(headers http.Header, body string) []zap.Field {
 	fields = append(fields, zap.String("BODY", body))
 	return fields
 }
+
+type RestyProvider interface {
+	Provider[*resty.Client]
+}
+
+// NewRestyProviderFromParams creates a resty provider from params, the clients log with logger if it's not nil,
+// if items is not empty, the first item will be set as default
+func NewRestyProviderFromParams(params map[string]*RestyParams, logger *zap.Logger) RestyProvider {
+	return NewGiuProviderFromParams(func(p *RestyParams) *resty.Client {
+		if p == nil {
+			p = _defaultRestyParams
+		}
+		if logger == nil {
+			return NewResty(p)
+		}
+		return NewRestyWithLogger(p, logger)
+	}, params)
+}
+
+// NewRestyProviderFromConfig creates a resty provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
+func NewRestyProviderFromConfig(config *viper.Viper, logger *zap.Logger) (RestyProvider, error) {
+	var params map[string]*RestyParams
+	if err := config.UnmarshalKey("resty", &params); err != nil {
+		return nil, err
+	}
+	return NewRestyProviderFromParams(params, logger), nil
+}
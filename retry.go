@@ -0,0 +1,129 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RetryPolicy is the exponential backoff policy of Retry.
+type RetryPolicy struct {
+	// MaxAttempts is the max calls of fn including the first one, default is 3, negative means unlimited.
+	MaxAttempts int
+	// InitialInterval is the interval before the first retry, default is 100ms.
+	InitialInterval time.Duration
+	// MaxInterval caps the interval, default is 10s.
+	MaxInterval time.Duration
+	// Multiplier is the growth of interval, default is 2.
+	Multiplier float64
+	// Jitter randomizes the interval by +/- the fraction, default is 0.2, negative disables it.
+	Jitter float64
+	// MaxElapsed stops retrying when the total time exceeds it, 0 means no limit.
+	MaxElapsed time.Duration
+	// Retryable classifies the errors, nil means all errors except Permanent ones are retryable.
+	Retryable func(err error) bool `mapstructure:"-"`
+	// Logger logs the retries in warn level if it's not nil.
+	Logger *zap.Logger `mapstructure:"-"`
+	// Clock is the time source, default is SystemClock.
+	Clock Clock `mapstructure:"-"`
+}
+
+var _defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     3,
+	InitialInterval: 100 * time.Millisecond,
+	MaxInterval:     10 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+}
+
+// DefaultRetryPolicy returns a policy of 3 attempts with exponential backoff from 100ms.
+func DefaultRetryPolicy() RetryPolicy {
+	return _defaultRetryPolicy
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so Retry returns it without retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Retry calls fn until it succeeds, the error is not retryable, the attempts or elapsed time are exhausted or ctx is done.
+// It returns the last error of fn, or the error of ctx if ctx is done while waiting.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	_, err := RetryValue(ctx, policy, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// RetryValue is Retry of functions returning a value.
+func RetryValue[T any](ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) (T, error)) (T, error) {
+	d := _defaultRetryPolicy
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = d.MaxAttempts
+	}
+	if policy.InitialInterval <= 0 {
+		policy.InitialInterval = d.InitialInterval
+	}
+	if policy.MaxInterval <= 0 {
+		policy.MaxInterval = d.MaxInterval
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = d.Multiplier
+	}
+	if policy.Jitter == 0 {
+		policy.Jitter = d.Jitter
+	}
+	clock := clockOrSystem(policy.Clock)
+	begin := clock.Now()
+	interval := policy.InitialInterval
+	for attempt := 1; ; attempt++ {
+		v, err := fn(ctx)
+		if err == nil {
+			return v, nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return v, perm.err
+		}
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			return v, err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return v, err
+		}
+		wait := interval
+		if policy.Jitter > 0 {
+			wait += time.Duration((rand.Float64()*2 - 1) * policy.Jitter * float64(interval))
+		}
+		if policy.MaxElapsed > 0 && clock.Since(begin)+wait > policy.MaxElapsed {
+			return v, err
+		}
+		if policy.Logger != nil {
+			policy.Logger.Warn("[retry] attempt failed", zap.Int("attempt", attempt), zap.Duration("wait", wait), zap.Error(err))
+		}
+		t := clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return v, ctx.Err()
+		case <-t.C():
+		}
+		interval = time.Duration(float64(interval) * policy.Multiplier)
+		if interval > policy.MaxInterval {
+			interval = policy.MaxInterval
+		}
+	}
+}
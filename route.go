@@ -0,0 +1,138 @@
+package giu
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// RouteParams are the operational settings of the requests matching Path and Method.
+type RouteParams struct {
+	// Path is a path.Match pattern like "/api/*/export", a trailing "**" matches any suffix like "/api/**".
+	Path string
+	// Method is the request method, empty means all.
+	Method string
+	// Timeout is the deadline of request context, 0 means no deadline.
+	Timeout time.Duration
+	// MaxBodySize is the max request body size in bytes, 0 means unlimited.
+	MaxBodySize int64
+	// RateLimit limits the requests of each client ip with a local limiter, nil means unlimited.
+	RateLimit *RateLimiterParams
+	// Auth is the name of auth middleware, empty means no auth.
+	Auth string
+	// Priority is the load shedding priority, empty means classified by the load shedder.
+	Priority string
+}
+
+func (p *RouteParams) match(r *http.Request) bool {
+	if p.Method != "" && !strings.EqualFold(p.Method, r.Method) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(p.Path, "**"); ok {
+		return strings.HasPrefix(r.URL.Path, prefix)
+	}
+	ok, _ := path.Match(p.Path, r.URL.Path)
+	return ok
+}
+
+type route struct {
+	params  RouteParams
+	limiter Limiter
+	auth    gin.HandlerFunc
+}
+
+// NewGinMiddlewareRoutes returns a gin middleware which applies the settings of the first matched route.
+// auths are the auth middlewares referenced by name, they must abort the rejected requests.
+// Priorities take effect only if shedder is not nil, and shed requests are not counted by the rate limit.
+func NewGinMiddlewareRoutes(routes []RouteParams, auths map[string]gin.HandlerFunc, shedder *LoadShedder, zl *zap.Logger) (gin.HandlerFunc, error) {
+	rs := make([]route, 0, len(routes))
+	for _, params := range routes {
+		if _, err := path.Match(strings.TrimSuffix(params.Path, "**"), ""); err != nil {
+			return nil, fmt.Errorf("invalid route path %q: %w", params.Path, err)
+		}
+		r := route{params: params}
+		if params.Auth != "" {
+			auth, ok := auths[params.Auth]
+			if !ok {
+				return nil, fmt.Errorf("auth %q of route %q is not found", params.Auth, params.Path)
+			}
+			r.auth = auth
+		}
+		if params.RateLimit != nil {
+			r.limiter = NewLocalLimiter(*params.RateLimit)
+		}
+		rs = append(rs, r)
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "route"))
+	return func(c *gin.Context) {
+		var r *route
+		for i := range rs {
+			if rs[i].params.match(c.Request) {
+				r = &rs[i]
+				break
+			}
+		}
+		if r == nil {
+			c.Next()
+			return
+		}
+		p := &r.params
+		if p.MaxBodySize > 0 {
+			if c.Request.ContentLength > p.MaxBodySize {
+				c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, p.MaxBodySize)
+		}
+		if p.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), p.Timeout)
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+		}
+		if shedder != nil {
+			priority := p.Priority
+			if priority == "" {
+				priority = shedder.Priority(c.Request)
+			}
+			leave, ok := shedder.enter(c, priority, zl)
+			if !ok {
+				return
+			}
+			defer leave()
+		}
+		if r.limiter != nil {
+			ok, err := r.limiter.Allow(c.Request.Context(), GinClientIP(c))
+			if err == nil && !ok {
+				c.AbortWithStatus(http.StatusTooManyRequests)
+				return
+			}
+		}
+		if r.auth != nil {
+			r.auth(c)
+			if c.IsAborted() {
+				return
+			}
+		}
+		// no-op if auth has already called the next handlers
+		c.Next()
+	}, nil
+}
+
+// NewGinMiddlewareRoutesFromConfig creates the routes middleware from viper config with key "routes".
+func NewGinMiddlewareRoutesFromConfig(config *viper.Viper, auths map[string]gin.HandlerFunc, shedder *LoadShedder, zl *zap.Logger) (gin.HandlerFunc, error) {
+	var routes []RouteParams
+	if err := config.UnmarshalKey("routes", &routes); err != nil {
+		return nil, err
+	}
+	return NewGinMiddlewareRoutes(routes, auths, shedder, zl)
+}
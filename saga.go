@@ -0,0 +1,210 @@
+package giu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	SAGA_STATUS_RUNNING      = "running"
+	SAGA_STATUS_COMPLETED    = "completed"
+	SAGA_STATUS_COMPENSATING = "compensating"
+	SAGA_STATUS_COMPENSATED  = "compensated"
+	SAGA_STATUS_FAILED       = "failed"
+)
+
+var (
+	// ERR_SAGA_COMPENSATED wraps the step error when the saga is rolled back by compensations.
+	ERR_SAGA_COMPENSATED = errors.New("saga is compensated")
+	// ERR_SAGA_COMPENSATION_FAILED means the saga is stuck in a partial state and needs manual intervention.
+	ERR_SAGA_COMPENSATION_FAILED = errors.New("saga compensation failed")
+)
+
+// SagaRecord is the persisted state of a saga, migrate it with db.AutoMigrate(&SagaRecord{}).
+type SagaRecord struct {
+	ID   string `gorm:"primaryKey;size:64"`
+	Name string `gorm:"size:64;index:idx_giu_saga_status,priority:1"`
+	// Status is one of SAGA_STATUS_*.
+	Status string `gorm:"size:16;index:idx_giu_saga_status,priority:2"`
+	// Step is the number of completed and not compensated steps.
+	Step      int
+	Data      string
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (SagaRecord) TableName() string {
+	return "giu_sagas"
+}
+
+// SagaStep is a step of a saga. Action runs in a transaction together with the saga progress, so the gorm
+// writes of the step are committed with it, publish messages with OutboxPublish(ctx, ...) to join it too.
+// Compensate undoes a completed step in the same way, it's optional for steps with nothing to undo.
+type SagaStep[T any] struct {
+	Name       string
+	Action     func(ctx context.Context, data *T) error
+	Compensate func(ctx context.Context, data *T) error
+	// Retry is the retry policy of Action and Compensate, default is DefaultRetryPolicy.
+	Retry *RetryPolicy
+}
+
+// Saga runs steps in order and compensates the completed steps in reverse order when a step fails.
+// The data T is persisted as json after every step, so an interrupted saga can be resumed.
+type Saga[T any] struct {
+	name   string
+	db     *gorm.DB
+	steps  []SagaStep[T]
+	logger *zap.Logger
+}
+
+func NewSaga[T any](name string, db *gorm.DB, steps []SagaStep[T], zl *zap.Logger) *Saga[T] {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &Saga[T]{name: name, db: db, steps: steps, logger: zl.With(zap.String("module", "saga"), zap.String("saga", name))}
+}
+
+// Run starts a saga with data and runs it to the end, it returns the id of the saga record.
+func (s *Saga[T]) Run(ctx context.Context, data T) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	rec := &SagaRecord{ID: uuid.NewString(), Name: s.name, Status: SAGA_STATUS_RUNNING, Data: string(raw)}
+	if err := s.db.WithContext(ctx).Create(rec).Error; err != nil {
+		return "", err
+	}
+	return rec.ID, s.execute(ctx, rec)
+}
+
+// Resume continues the running or compensating saga of id, e.g. after a restart.
+func (s *Saga[T]) Resume(ctx context.Context, id string) error {
+	var rec SagaRecord
+	if err := s.db.WithContext(ctx).Where("id = ? AND name = ?", id, s.name).First(&rec).Error; err != nil {
+		return err
+	}
+	return s.execute(ctx, &rec)
+}
+
+// ResumeAll continues the unfinished sagas updated before olderThan ago, so sagas in progress are not taken.
+func (s *Saga[T]) ResumeAll(ctx context.Context, olderThan time.Duration) error {
+	var recs []SagaRecord
+	err := s.db.WithContext(ctx).
+		Where("name = ? AND status IN ? AND updated_at < ?", s.name,
+			[]string{SAGA_STATUS_RUNNING, SAGA_STATUS_COMPENSATING}, time.Now().Add(-olderThan)).
+		Find(&recs).Error
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := range recs {
+		if err := s.execute(ctx, &recs[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Saga[T]) policy(step SagaStep[T]) RetryPolicy {
+	if step.Retry != nil {
+		return *step.Retry
+	}
+	return DefaultRetryPolicy()
+}
+
+// apply runs fn of a step in a transaction and saves rec with the new data, the data is decoded from rec
+// for every attempt, so a failed attempt doesn't leak its changes.
+func (s *Saga[T]) apply(ctx context.Context, rec *SagaRecord, policy RetryPolicy, fn func(ctx context.Context, data *T) error, update func(rec *SagaRecord)) error {
+	return Retry(ctx, policy, func(ctx context.Context) error {
+		return Transaction(ctx, s.db, func(ctx context.Context) error {
+			var data T
+			if err := json.Unmarshal([]byte(rec.Data), &data); err != nil {
+				return Permanent(err)
+			}
+			if err := fn(ctx, &data); err != nil {
+				return err
+			}
+			raw, err := json.Marshal(data)
+			if err != nil {
+				return Permanent(err)
+			}
+			next := *rec
+			next.Data = string(raw)
+			update(&next)
+			tx, _ := TxFromContext(ctx)
+			if err := tx.Save(&next).Error; err != nil {
+				return err
+			}
+			*rec = next
+			return nil
+		})
+	})
+}
+
+func (s *Saga[T]) save(ctx context.Context, rec *SagaRecord) error {
+	return s.db.WithContext(context.WithoutCancel(ctx)).Save(rec).Error
+}
+
+func (s *Saga[T]) execute(ctx context.Context, rec *SagaRecord) error {
+	logger := LoggerWithScope(ctx, s.logger).With(zap.String("saga_id", rec.ID))
+	var cause error
+	if rec.Status == SAGA_STATUS_RUNNING {
+		for rec.Step < len(s.steps) {
+			step := s.steps[rec.Step]
+			err := s.apply(ctx, rec, s.policy(step), step.Action, func(r *SagaRecord) {
+				r.Step++
+				if r.Step == len(s.steps) {
+					r.Status = SAGA_STATUS_COMPLETED
+				}
+			})
+			if err != nil {
+				cause = fmt.Errorf("saga step %s: %w", step.Name, err)
+				logger.Warn("[saga] step failed, compensating", zap.String("step", step.Name), zap.Error(err))
+				rec.Status, rec.Error = SAGA_STATUS_COMPENSATING, cause.Error()
+				if err := s.save(ctx, rec); err != nil {
+					return errors.Join(cause, err)
+				}
+				break
+			}
+		}
+		if rec.Status == SAGA_STATUS_COMPLETED {
+			logger.Info("[saga] completed")
+			return nil
+		}
+	}
+	if rec.Status != SAGA_STATUS_COMPENSATING {
+		return nil
+	}
+	if cause == nil {
+		cause = errors.New(rec.Error)
+	}
+	for rec.Step > 0 {
+		step := s.steps[rec.Step-1]
+		if step.Compensate == nil {
+			rec.Step--
+			continue
+		}
+		err := s.apply(ctx, rec, s.policy(step), step.Compensate, func(r *SagaRecord) {
+			r.Step--
+		})
+		if err != nil {
+			logger.Error("[saga] compensation failed", zap.String("step", step.Name), zap.Error(err))
+			rec.Status, rec.Error = SAGA_STATUS_FAILED, fmt.Sprintf("%s; compensation of %s: %s", rec.Error, step.Name, err)
+			return errors.Join(fmt.Errorf("%w: %s: %w", ERR_SAGA_COMPENSATION_FAILED, step.Name, err), cause, s.save(ctx, rec))
+		}
+	}
+	rec.Status = SAGA_STATUS_COMPENSATED
+	if err := s.save(ctx, rec); err != nil {
+		return errors.Join(cause, err)
+	}
+	logger.Info("[saga] compensated", zap.String("error", rec.Error))
+	return fmt.Errorf("%w: %w", ERR_SAGA_COMPENSATED, cause)
+}
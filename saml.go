@@ -0,0 +1,157 @@
+package giu
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type SamlParams struct {
+	// RootURL is the external url of the service, metadata and acs are served under "<RootURL>/saml/".
+	RootURL string
+	// EntityID is the entity id of the service provider, default is the metadata url.
+	EntityID string
+	// CertFile and KeyFile are the rsa key pair signing requests and decrypting assertions.
+	CertFile string
+	KeyFile  string
+	// IDPMetadataURL is fetched at startup, IDPMetadataFile is read if it's empty.
+	IDPMetadataURL  string
+	IDPMetadataFile string
+	// UserAttribute and TenantAttribute are the attributes mapped to user and tenant of request scope,
+	// the user falls back to the subject name id if the attribute is empty.
+	UserAttribute   string
+	TenantAttribute string
+	// AllowIDPInitiated accepts logins started by the identity provider.
+	AllowIDPInitiated bool
+	// CookieName is the cookie name of session token, default is "token".
+	CookieName string
+}
+
+var ERR_SAML_KEY_NOT_RSA = errors.New("saml key is not a rsa private key")
+
+// GIN_SAML_ATTRIBUTES is the gin context key of the samlsp.Attributes set by the saml middleware.
+var GIN_SAML_ATTRIBUTES = "giu_saml_attributes"
+
+// SamlServiceProvider serves saml metadata and acs, and protects routes with saml sessions.
+type SamlServiceProvider struct {
+	params SamlParams
+	sp     *samlsp.Middleware
+	logger *zap.Logger
+}
+
+// NewSamlServiceProvider loads the key pair and the identity provider metadata.
+func NewSamlServiceProvider(params SamlParams, zl *zap.Logger) (*SamlServiceProvider, error) {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	root, err := url.Parse(params.RootURL)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.LoadX509KeyPair(params.CertFile, params.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ERR_SAML_KEY_NOT_RSA
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	var idp *saml.EntityDescriptor
+	if params.IDPMetadataURL != "" {
+		u, err := url.Parse(params.IDPMetadataURL)
+		if err != nil {
+			return nil, err
+		}
+		if idp, err = samlsp.FetchMetadata(context.Background(), http.DefaultClient, *u); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := os.ReadFile(params.IDPMetadataFile)
+		if err != nil {
+			return nil, err
+		}
+		if idp, err = samlsp.ParseMetadata(data); err != nil {
+			return nil, err
+		}
+	}
+	sp, err := samlsp.New(samlsp.Options{
+		EntityID:          params.EntityID,
+		URL:               *root,
+		Key:               key,
+		Certificate:       cert,
+		IDPMetadata:       idp,
+		AllowIDPInitiated: params.AllowIDPInitiated,
+		CookieName:        params.CookieName,
+		CookieSameSite:    http.SameSiteLaxMode,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SamlServiceProvider{params: params, sp: sp, logger: zl.With(zap.String("module", "saml"))}, nil
+}
+
+// NewSamlServiceProviderFromConfig creates the service provider from viper config with key "saml".
+func NewSamlServiceProviderFromConfig(config *viper.Viper, zl *zap.Logger) (*SamlServiceProvider, error) {
+	var params SamlParams
+	if err := config.UnmarshalKey("saml", &params); err != nil {
+		return nil, err
+	}
+	return NewSamlServiceProvider(params, zl)
+}
+
+// Mount adds "GET /saml/metadata" and "POST /saml/acs" to r, RootURL must point to the router root.
+func (s *SamlServiceProvider) Mount(r gin.IRouter) {
+	r.GET(s.sp.ServiceProvider.MetadataURL.Path, gin.WrapF(s.sp.ServeMetadata))
+	r.POST(s.sp.ServiceProvider.AcsURL.Path, gin.WrapF(s.sp.ServeACS))
+}
+
+// Middleware returns a gin middleware which starts the saml login without a session. The user and tenant attributes
+// are set to the request scope and all attributes are set to gin context with key GIN_SAML_ATTRIBUTES.
+func (s *SamlServiceProvider) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, err := s.sp.Session.GetSession(c.Request)
+		if errors.Is(err, samlsp.ErrNoSession) {
+			s.sp.HandleStartAuthFlow(c.Writer, c.Request)
+			c.Abort()
+			return
+		}
+		if err != nil {
+			s.logger.Warn("[saml] invalid session", zap.Error(err))
+			AbortWithErrorResponse(c, http.StatusUnauthorized, err)
+			return
+		}
+		c.Request = c.Request.WithContext(samlsp.ContextWithSession(c.Request.Context(), session))
+		scope := GinRequestScope(c)
+		if sa, ok := session.(samlsp.JWTSessionClaims); ok {
+			scope.User = sa.Subject
+		}
+		if sa, ok := session.(samlsp.SessionWithAttributes); ok {
+			attrs := sa.GetAttributes()
+			if s.params.UserAttribute != "" {
+				if user := attrs.Get(s.params.UserAttribute); user != "" {
+					scope.User = user
+				}
+			}
+			if s.params.TenantAttribute != "" {
+				scope.Tenant = attrs.Get(s.params.TenantAttribute)
+			}
+			c.Set(GIN_SAML_ATTRIBUTES, attrs)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,195 @@
+package giu
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// RequestScope holds the typed values of a request, it's stored in the request context.
+// It's a pointer in context, so values set by later middlewares (e.g. user after auth) are visible to all.
+type RequestScope struct {
+	TraceID  string
+	User     string
+	Tenant   string
+	Locale   string
+	Deadline time.Time
+}
+
+var (
+	SCOPE_HEADER_USER   = "X-User-Id"
+	SCOPE_HEADER_TENANT = "X-Tenant-Id"
+	SCOPE_HEADER_LOCALE = "Accept-Language"
+)
+
+type requestScopeKey struct{}
+
+// WithRequestScope returns a copy of ctx with the scope.
+func WithRequestScope(ctx context.Context, scope *RequestScope) context.Context {
+	return context.WithValue(ctx, requestScopeKey{}, scope)
+}
+
+// RequestScopeFromContext returns the scope in ctx, if not found, it returns false.
+func RequestScopeFromContext(ctx context.Context) (*RequestScope, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	scope, ok := ctx.Value(requestScopeKey{}).(*RequestScope)
+	return scope, ok && scope != nil
+}
+
+// GinRequestScope returns the scope of the gin request, if not found, an empty scope is created and stored.
+func GinRequestScope(c *gin.Context) *RequestScope {
+	if scope, ok := RequestScopeFromContext(c.Request.Context()); ok {
+		return scope
+	}
+	scope := &RequestScope{}
+	c.Request = c.Request.WithContext(WithRequestScope(c.Request.Context(), scope))
+	return scope
+}
+
+// ZapFields returns the non-empty values of the scope as zap fields.
+func (s *RequestScope) ZapFields() []zap.Field {
+	var fields []zap.Field
+	if s == nil {
+		return fields
+	}
+	if s.TraceID != "" {
+		fields = append(fields, zap.String("trace_id", s.TraceID))
+	}
+	if s.User != "" {
+		fields = append(fields, zap.String("user", s.User))
+	}
+	if s.Tenant != "" {
+		fields = append(fields, zap.String("tenant", s.Tenant))
+	}
+	if s.Locale != "" {
+		fields = append(fields, zap.String("locale", s.Locale))
+	}
+	return fields
+}
+
+// LoggerWithScope returns a child logger with the fields of the scope and the otel span in ctx.
+func LoggerWithScope(ctx context.Context, zl *zap.Logger) *zap.Logger {
+	var fields []zap.Field
+	if scope, ok := RequestScopeFromContext(ctx); ok {
+		fields = scope.ZapFields()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.String("otel_trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String()))
+	}
+	if len(fields) == 0 {
+		return zl
+	}
+	return zl.With(fields...)
+}
+
+type RequestScopeParams struct {
+	// TrustedProxies are the ips or cidrs of the callers whose user and tenant headers and baggage are accepted,
+	// e.g. the gateway which authenticates users. The identities of other callers are ignored.
+	TrustedProxies []string
+}
+
+// NewGinMiddlewareRequestScope returns a gin middleware which creates the request scope from headers, the user
+// and tenant are not taken from headers, see NewGinMiddlewareRequestScopeWithParams.
+func NewGinMiddlewareRequestScope() gin.HandlerFunc {
+	h, _ := NewGinMiddlewareRequestScopeWithParams(RequestScopeParams{})
+	return h
+}
+
+// NewGinMiddlewareRequestScopeWithParams returns a gin middleware which creates the request scope from headers.
+// User and tenant headers are propagated by giu resty clients with Propagate, they're accepted only from the
+// trusted proxies. If they're absent, user and tenant are taken from the baggage header, see BAGGAGE_KEY_USER.
+func NewGinMiddlewareRequestScopeWithParams(params RequestScopeParams) (gin.HandlerFunc, error) {
+	trusted, err := newIPMatcher(params.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return func(c *gin.Context) {
+		scope := GinRequestScope(c)
+		scope.TraceID = c.GetHeader(GIN_TRACE_ID)
+		if scope.TraceID == "" {
+			// the trace id may be generated by trace middleware
+			scope.TraceID = c.Writer.Header().Get(GIN_TRACE_ID)
+		}
+		ip := net.ParseIP(c.RemoteIP())
+		fromProxy := ip != nil && trusted.contains(ip)
+		// fall back to the otel baggage, which is propagated through services not using giu
+		c.Request = c.Request.WithContext(contextWithHeaderBaggage(c.Request.Context(), c.Request.Header, fromProxy))
+		if fromProxy {
+			scope.User = c.GetHeader(SCOPE_HEADER_USER)
+			scope.Tenant = c.GetHeader(SCOPE_HEADER_TENANT)
+			user, tenant := BaggageIdentity(c.Request.Context())
+			if scope.User == "" {
+				scope.User = user
+			}
+			if scope.Tenant == "" {
+				scope.Tenant = tenant
+			}
+		}
+		scope.Locale = c.GetHeader(SCOPE_HEADER_LOCALE)
+		if deadline, ok := c.Request.Context().Deadline(); ok {
+			scope.Deadline = deadline
+		}
+		c.Next()
+	}, nil
+}
+
+// restyRequestScopeMiddleware propagates the request scope, the otel baggage and the remaining time of the request
+// context to headers, if hosts is not empty, only the requests to the hosts are changed.
+func restyRequestScopeMiddleware(hosts []string) resty.RequestMiddleware {
+	return func(c *resty.Client, r *resty.Request) error {
+		if len(hosts) > 0 && !matchPropagateHost(restyRequestHost(c, r), hosts) {
+			return nil
+		}
+		return propagateRequestScope(r)
+	}
+}
+
+// restyRequestHost returns the host of the request url, or of the base url of the client if the url is relative.
+func restyRequestHost(c *resty.Client, r *resty.Request) string {
+	if u, err := url.Parse(r.URL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	if u, err := url.Parse(c.BaseURL); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+func matchPropagateHost(host string, hosts []string) bool {
+	host = strings.ToLower(host)
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if host == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return true
+		}
+	}
+	return false
+}
+
+func propagateRequestScope(r *resty.Request) error {
+	scope, ok := RequestScopeFromContext(r.Context())
+	setIfAbsent := func(key, value string) {
+		if value != "" && r.Header.Get(key) == "" {
+			r.SetHeader(key, value)
+		}
+	}
+	setIfAbsent(BAGGAGE_HEADER, headerBaggage(r.Context(), scope))
+	setIfAbsent(SCOPE_HEADER_TIMEOUT, requestTimeoutHeader(r.Context()))
+	if !ok {
+		return nil
+	}
+	setIfAbsent(GIN_TRACE_ID, scope.TraceID)
+	setIfAbsent(SCOPE_HEADER_USER, scope.User)
+	setIfAbsent(SCOPE_HEADER_TENANT, scope.Tenant)
+	setIfAbsent(SCOPE_HEADER_LOCALE, scope.Locale)
+	return nil
+}
@@ -0,0 +1,302 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// GeoIndex is a typed wrapper of a redis geo set.
+type GeoIndex struct {
+	rdb redis.UniversalClient
+	key string
+}
+
+func NewGeoIndex(rdb redis.UniversalClient, key string) *GeoIndex {
+	return &GeoIndex{rdb: rdb, key: key}
+}
+
+// Add adds or updates the locations of members.
+func (g *GeoIndex) Add(ctx context.Context, locations ...*redis.GeoLocation) error {
+	return g.rdb.GeoAdd(ctx, g.key, locations...).Err()
+}
+
+func (g *GeoIndex) Remove(ctx context.Context, members ...string) error {
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return g.rdb.ZRem(ctx, g.key, args...).Err()
+}
+
+// Position returns the location of member, nil if it doesn't exist.
+func (g *GeoIndex) Position(ctx context.Context, member string) (*redis.GeoPos, error) {
+	pos, err := g.rdb.GeoPos(ctx, g.key, member).Result()
+	if err != nil || len(pos) == 0 {
+		return nil, err
+	}
+	return pos[0], nil
+}
+
+// GeoQuery searches members within Radius of Member or Longitude/Latitude.
+type GeoQuery struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+	Radius    float64
+	// Unit is one of "m", "km", "ft" and "mi", default is "m".
+	Unit string
+	// Count limits the results, 0 means no limit.
+	Count int
+}
+
+// Search returns the members with coordinates and distances sorted by distance.
+func (g *GeoIndex) Search(ctx context.Context, q GeoQuery) ([]redis.GeoLocation, error) {
+	if q.Unit == "" {
+		q.Unit = "m"
+	}
+	return g.rdb.GeoSearchLocation(ctx, g.key, &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Member:     q.Member,
+			Longitude:  q.Longitude,
+			Latitude:   q.Latitude,
+			Radius:     q.Radius,
+			RadiusUnit: q.Unit,
+			Sort:       "ASC",
+			Count:      q.Count,
+		},
+		WithCoord: true,
+		WithDist:  true,
+	}).Result()
+}
+
+// searchField is a field declared with tag `search:"name,type[,sortable][,weight=n]"`,
+// type is one of "text", "tag", "numeric" and "geo".
+type searchField struct {
+	index    int
+	name     string
+	typ      string
+	sortable bool
+	weight   string
+}
+
+// SearchIndex manages a RediSearch index of hashes, the schema is declared by the search tags of T.
+type SearchIndex[T any] struct {
+	rdb    redis.UniversalClient
+	name   string
+	prefix string
+	fields []searchField
+}
+
+// SearchOptions are the options of SearchIndex.Search.
+type SearchOptions struct {
+	Offset int
+	// Limit is the max results, default is 10.
+	Limit  int
+	SortBy string
+	Desc   bool
+}
+
+// SearchResult is a document of SearchIndex.Search.
+type SearchResult[T any] struct {
+	ID  string
+	Doc T
+}
+
+func NewSearchIndex[T any](rdb redis.UniversalClient, name, prefix string) (*SearchIndex[T], error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("search index document must be a struct, got %s", t)
+	}
+	si := &SearchIndex[T]{rdb: rdb, name: name, prefix: prefix}
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("search")
+		if !ok || tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		f := searchField{index: i, name: parts[0], typ: "text"}
+		if f.name == "" {
+			f.name = t.Field(i).Name
+		}
+		for _, p := range parts[1:] {
+			switch {
+			case p == "sortable":
+				f.sortable = true
+			case strings.HasPrefix(p, "weight="):
+				f.weight = strings.TrimPrefix(p, "weight=")
+			case p == "text" || p == "tag" || p == "numeric" || p == "geo":
+				f.typ = p
+			default:
+				return nil, fmt.Errorf("invalid search tag %q of field %s", p, t.Field(i).Name)
+			}
+		}
+		si.fields = append(si.fields, f)
+	}
+	if len(si.fields) == 0 {
+		return nil, fmt.Errorf("no search field in %s", t)
+	}
+	return si, nil
+}
+
+// Create creates the index if it doesn't exist.
+func (si *SearchIndex[T]) Create(ctx context.Context) error {
+	args := []interface{}{"FT.CREATE", si.name, "ON", "HASH", "PREFIX", 1, si.prefix, "SCHEMA"}
+	for _, f := range si.fields {
+		args = append(args, f.name, strings.ToUpper(f.typ))
+		if f.weight != "" && f.typ == "text" {
+			args = append(args, "WEIGHT", f.weight)
+		}
+		if f.sortable {
+			args = append(args, "SORTABLE")
+		}
+	}
+	err := si.rdb.Do(ctx, args...).Err()
+	if err != nil && strings.Contains(err.Error(), "already exists") {
+		return nil
+	}
+	return err
+}
+
+// Drop drops the index, the documents are deleted too if deleteDocs is true.
+func (si *SearchIndex[T]) Drop(ctx context.Context, deleteDocs bool) error {
+	args := []interface{}{"FT.DROPINDEX", si.name}
+	if deleteDocs {
+		args = append(args, "DD")
+	}
+	return si.rdb.Do(ctx, args...).Err()
+}
+
+// Put saves doc as hash with key prefix+id.
+func (si *SearchIndex[T]) Put(ctx context.Context, id string, doc T) error {
+	rv := reflect.ValueOf(doc)
+	values := make([]interface{}, 0, len(si.fields)*2)
+	for _, f := range si.fields {
+		values = append(values, f.name, fmt.Sprint(rv.Field(f.index).Interface()))
+	}
+	return si.rdb.HSet(ctx, si.prefix+id, values...).Err()
+}
+
+func (si *SearchIndex[T]) Delete(ctx context.Context, ids ...string) error {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = si.prefix + id
+	}
+	return si.rdb.Del(ctx, keys...).Err()
+}
+
+// Search runs the RediSearch query and returns the total count and the documents.
+func (si *SearchIndex[T]) Search(ctx context.Context, query string, opts SearchOptions) (int64, []SearchResult[T], error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 10
+	}
+	args := []interface{}{"FT.SEARCH", si.name, query}
+	if opts.SortBy != "" {
+		order := "ASC"
+		if opts.Desc {
+			order = "DESC"
+		}
+		args = append(args, "SORTBY", opts.SortBy, order)
+	}
+	args = append(args, "LIMIT", opts.Offset, opts.Limit)
+	res, err := si.rdb.Do(ctx, args...).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	switch v := res.(type) {
+	case []interface{}:
+		return si.parseResp2(v)
+	case map[interface{}]interface{}:
+		return si.parseResp3(v)
+	}
+	return 0, nil, fmt.Errorf("unexpected search result %T", res)
+}
+
+// parseResp2 parses [total, id, [field, value, ...], id, ...].
+func (si *SearchIndex[T]) parseResp2(v []interface{}) (int64, []SearchResult[T], error) {
+	if len(v) == 0 {
+		return 0, nil, errors.New("empty search result")
+	}
+	total, _ := v[0].(int64)
+	var results []SearchResult[T]
+	for i := 1; i+1 < len(v); i += 2 {
+		id, _ := v[i].(string)
+		attrs, _ := v[i+1].([]interface{})
+		values := make(map[string]string, len(attrs)/2)
+		for j := 0; j+1 < len(attrs); j += 2 {
+			values[fmt.Sprint(attrs[j])] = fmt.Sprint(attrs[j+1])
+		}
+		doc, err := si.decode(values)
+		if err != nil {
+			return 0, nil, err
+		}
+		results = append(results, SearchResult[T]{ID: strings.TrimPrefix(id, si.prefix), Doc: doc})
+	}
+	return total, results, nil
+}
+
+// parseResp3 parses {total_results: n, results: [{id: id, extra_attributes: {field: value}}]}.
+func (si *SearchIndex[T]) parseResp3(v map[interface{}]interface{}) (int64, []SearchResult[T], error) {
+	total, _ := v["total_results"].(int64)
+	items, _ := v["results"].([]interface{})
+	var results []SearchResult[T]
+	for _, item := range items {
+		m, _ := item.(map[interface{}]interface{})
+		id, _ := m["id"].(string)
+		attrs, _ := m["extra_attributes"].(map[interface{}]interface{})
+		values := make(map[string]string, len(attrs))
+		for k, a := range attrs {
+			values[fmt.Sprint(k)] = fmt.Sprint(a)
+		}
+		doc, err := si.decode(values)
+		if err != nil {
+			return 0, nil, err
+		}
+		results = append(results, SearchResult[T]{ID: strings.TrimPrefix(id, si.prefix), Doc: doc})
+	}
+	return total, results, nil
+}
+
+func (si *SearchIndex[T]) decode(values map[string]string) (T, error) {
+	var doc T
+	rv := reflect.ValueOf(&doc).Elem()
+	for _, f := range si.fields {
+		s, ok := values[f.name]
+		if !ok {
+			continue
+		}
+		fv := rv.Field(f.index)
+		var err error
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(s)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var n int64
+			n, err = strconv.ParseInt(s, 10, 64)
+			fv.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var n uint64
+			n, err = strconv.ParseUint(s, 10, 64)
+			fv.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			var n float64
+			n, err = strconv.ParseFloat(s, 64)
+			fv.SetFloat(n)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(s)
+			fv.SetBool(b)
+		default:
+			err = fmt.Errorf("unsupported search field type %s", fv.Type())
+		}
+		if err != nil {
+			return doc, fmt.Errorf("decode search field %s: %w", f.name, err)
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,146 @@
+package giu
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SeederParams struct {
+	// Enabled runs the seeds of SeedHook in Bootstrap.
+	Enabled bool
+	// Connection is the gorm connection of SeedHook, default is the default connection.
+	Connection string
+	// Env is the current environment, only seeds registered for it (or for all) are applied.
+	Env string
+	// Table is the table of applied seeds, default is "giu_seeds".
+	Table string
+	// DryRun only reports the pending seeds without applying them.
+	DryRun bool
+}
+
+var _defaultSeederParams = SeederParams{
+	Table: "giu_seeds",
+}
+
+type SeedFunc func(ctx context.Context, db *gorm.DB) error
+
+type seed struct {
+	name string
+	envs map[string]bool
+	fn   SeedFunc
+}
+
+type seedRecord struct {
+	Name      string `gorm:"primaryKey;size:191"`
+	Env       string `gorm:"size:64"`
+	AppliedAt time.Time
+}
+
+// Seeder applies registered seed funcs once, in registration order, and records them in a table.
+type Seeder struct {
+	db     *gorm.DB
+	params SeederParams
+	logger *zap.Logger
+	seeds  []seed
+}
+
+func NewSeeder(db *gorm.DB, params SeederParams, zl *zap.Logger) *Seeder {
+	if params.Table == "" {
+		params.Table = _defaultSeederParams.Table
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &Seeder{db: db, params: params, logger: zl.With(zap.String("module", "seeder"))}
+}
+
+// SeedHook returns a Bootstrap hook which runs the seeds registered by register on the gorm connection of the
+// seed config, e.g. seed.enabled: true with seed.env: staging. It does nothing if the seeds are not enabled.
+func SeedHook(register func(s *Seeder)) BootstrapHook {
+	return BootstrapHook{Name: "seed", Run: func(app *App) error {
+		params := app.Config.Seed
+		if params == nil || !params.Enabled {
+			return nil
+		}
+		db := app.Gorm.Default()
+		if params.Connection != "" {
+			var err error
+			if db, err = app.Gorm.GetE(params.Connection); err != nil {
+				return err
+			}
+		}
+		if db == nil {
+			return fmt.Errorf("%w: default gorm connection", ERR_PROVIDER_ITEM_NOT_FOUND)
+		}
+		s := NewSeeder(db, *params, app.Logger.Default())
+		register(s)
+		_, err := s.Run(context.Background())
+		return err
+	}}
+}
+
+// Register registers a seed func, if envs is empty, it's applied in all environments.
+// The name is the identity of the seed, don't rename it after it's applied.
+func (s *Seeder) Register(name string, fn SeedFunc, envs ...string) {
+	sd := seed{name: name, fn: fn}
+	if len(envs) > 0 {
+		sd.envs = make(map[string]bool)
+		for _, env := range envs {
+			sd.envs[env] = true
+		}
+	}
+	s.seeds = append(s.seeds, sd)
+}
+
+// Run applies the pending seeds of current environment, each seed and its record are in a transaction.
+// It returns the names of applied seeds, or pending seeds in dry run mode, which doesn't create the record table.
+func (s *Seeder) Run(ctx context.Context) ([]string, error) {
+	db := s.db.WithContext(ctx)
+	var records []seedRecord
+	if !s.params.DryRun {
+		if err := db.Table(s.params.Table).AutoMigrate(&seedRecord{}); err != nil {
+			return nil, err
+		}
+	}
+	// dry run changes nothing, without the record table every seed is pending
+	if !s.params.DryRun || db.Migrator().HasTable(s.params.Table) {
+		if err := db.Table(s.params.Table).Find(&records).Error; err != nil {
+			return nil, err
+		}
+	}
+	applied := make(map[string]bool, len(records))
+	for _, r := range records {
+		applied[r.Name] = true
+	}
+
+	var names []string
+	for _, sd := range s.seeds {
+		if applied[sd.name] || (sd.envs != nil && !sd.envs[s.params.Env]) {
+			continue
+		}
+		if s.params.DryRun {
+			s.logger.Info("[seeder] pending seed", zap.String("name", sd.name), zap.String("env", s.params.Env))
+			names = append(names, sd.name)
+			continue
+		}
+		begin := time.Now()
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := sd.fn(ctx, tx); err != nil {
+				return err
+			}
+			return tx.Table(s.params.Table).Create(&seedRecord{Name: sd.name, Env: s.params.Env, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			s.logger.Error("[seeder] seed failed", zap.String("name", sd.name), zap.Error(err))
+			return names, fmt.Errorf("seed %s failed: %w", sd.name, err)
+		}
+		s.logger.Info("[seeder] seed applied", zap.String("name", sd.name), zap.Duration("elapsed", time.Since(begin)))
+		names = append(names, sd.name)
+	}
+	s.logger.Info("[seeder] done", zap.Int("count", len(names)), zap.Bool("dry_run", s.params.DryRun))
+	return names, nil
+}
@@ -0,0 +1,184 @@
+package giu
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type GinServerParams struct {
+	// Addr is the listen address, default is ":8080".
+	Addr         string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout is the max time to wait for in-flight requests when shutting down, default is 10s.
+	ShutdownTimeout time.Duration
+	// GracefulRestart enables zero-downtime restart on SIGUSR2: the listening socket is passed to a new process
+	// and the current process shuts down gracefully. Not supported on windows.
+	GracefulRestart bool
+	// Acme enables automatic certificates from Let's Encrypt (or another ACME directory).
+	Acme *AcmeParams
+}
+
+var _defaultGinServerParams = GinServerParams{
+	Addr:            ":8080",
+	ShutdownTimeout: 10 * time.Second,
+}
+
+// GIU_ENV_INHERIT_LISTENER is set for the new process when restarting, the listener is passed as fd 3.
+const GIU_ENV_INHERIT_LISTENER = "GIU_INHERIT_LISTENER"
+
+// GIU_ENV_INHERIT_ACME_LISTENER is set for the new process when restarting with the acme http listener, it's
+// passed as fd 4.
+const GIU_ENV_INHERIT_ACME_LISTENER = "GIU_INHERIT_ACME_LISTENER"
+
+type GinServer struct {
+	params   GinServerParams
+	server   *http.Server
+	logger   *zap.Logger
+	lock     sync.Mutex
+	listener net.Listener
+	autocert *autocert.Manager
+	acmeHTTP *http.Server
+	// acmeListener is the listener of acmeHTTP, it's passed to the new process on graceful restart too.
+	acmeListener net.Listener
+}
+
+// NewGinServer creates a server runner for the gin engine, logger can be nil.
+func NewGinServer(params GinServerParams, e *gin.Engine, zl *zap.Logger) *GinServer {
+	if params.Addr == "" {
+		params.Addr = _defaultGinServerParams.Addr
+	}
+	if params.ShutdownTimeout == 0 {
+		params.ShutdownTimeout = _defaultGinServerParams.ShutdownTimeout
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	s := &GinServer{
+		params: params,
+		logger: zl.With(zap.String("module", "server")),
+		server: &http.Server{
+			Addr:         params.Addr,
+			Handler:      e,
+			ReadTimeout:  params.ReadTimeout,
+			WriteTimeout: params.WriteTimeout,
+			IdleTimeout:  params.IdleTimeout,
+		},
+	}
+	if params.Acme != nil {
+		s.autocert = NewAutocertManager(*params.Acme)
+		s.server.TLSConfig = s.autocert.TLSConfig()
+		httpAddr := params.Acme.HTTPAddr
+		if httpAddr == "" {
+			httpAddr = _defaultAcmeParams.HTTPAddr
+		}
+		if httpAddr != "-" {
+			s.acmeHTTP = &http.Server{Addr: httpAddr, Handler: s.autocert.HTTPHandler(nil)}
+		}
+	}
+	return s
+}
+
+func DefaultGinServer(e *gin.Engine, zl *zap.Logger) *GinServer {
+	return NewGinServer(_defaultGinServerParams, e, zl)
+}
+
+// Server returns the underlying http server.
+func (s *GinServer) Server() *http.Server {
+	return s.server
+}
+
+// SetTLSConfig enables https with the tls config, e.g. CertProvider.ServerTLSConfig. It must be called before Run.
+func (s *GinServer) SetTLSConfig(config *tls.Config) {
+	s.server.TLSConfig = config
+}
+
+// Autocert returns the autocert manager, it's nil if acme is not enabled.
+func (s *GinServer) Autocert() *autocert.Manager {
+	return s.autocert
+}
+
+// Run listens and serves, it blocks until the server is shut down.
+// If the process is started by a graceful restart, the inherited listener is used.
+func (s *GinServer) Run() error {
+	l, err := s.listen()
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.listener = l
+	s.lock.Unlock()
+	if s.params.GracefulRestart {
+		stop := s.handleRestart()
+		defer stop()
+	}
+	if s.acmeHTTP != nil {
+		if al, err := s.listenAcme(); err != nil {
+			s.logger.Error("[server] acme http server failed", zap.Error(err))
+		} else {
+			s.lock.Lock()
+			s.acmeListener = al
+			s.lock.Unlock()
+			go func() {
+				if err := s.acmeHTTP.Serve(al); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					s.logger.Error("[server] acme http server failed", zap.Error(err))
+				}
+			}()
+		}
+	}
+	if s.server.TLSConfig != nil {
+		l = tls.NewListener(l, s.server.TLSConfig)
+	}
+	s.logger.Info("[server] listening", zap.String("addr", l.Addr().String()), zap.Bool("tls", s.server.TLSConfig != nil))
+	err = s.server.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the server, waiting in-flight requests at most ShutdownTimeout.
+func (s *GinServer) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.params.ShutdownTimeout)
+	defer cancel()
+	if s.acmeHTTP != nil {
+		if err := s.acmeHTTP.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return s.server.Shutdown(ctx)
+}
+
+func (s *GinServer) listen() (net.Listener, error) {
+	if os.Getenv(GIU_ENV_INHERIT_LISTENER) != "" {
+		// do not pass it to our own children
+		os.Unsetenv(GIU_ENV_INHERIT_LISTENER)
+		f := os.NewFile(3, "giu-listener")
+		defer f.Close()
+		s.logger.Info("[server] inherit listener from parent process")
+		return net.FileListener(f)
+	}
+	return net.Listen("tcp", s.params.Addr)
+}
+
+func (s *GinServer) listenAcme() (net.Listener, error) {
+	if os.Getenv(GIU_ENV_INHERIT_ACME_LISTENER) != "" {
+		os.Unsetenv(GIU_ENV_INHERIT_ACME_LISTENER)
+		f := os.NewFile(4, "giu-acme-listener")
+		defer f.Close()
+		s.logger.Info("[server] inherit acme listener from parent process")
+		return net.FileListener(f)
+	}
+	return net.Listen("tcp", s.acmeHTTP.Addr)
+}
@@ -0,0 +1,320 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type SftpParams struct {
+	Host string
+	Port int
+	User string
+	// Password and PrivateKey are the auth methods, both can be set. PrivateKey is the PEM content, e.g. from
+	// a secret, PrivateKeyFile is read if PrivateKey is empty.
+	Password       string
+	PrivateKey     string
+	PrivateKeyFile string
+	Passphrase     string
+	// KnownHostsFile verifies the host key with an OpenSSH known_hosts file.
+	KnownHostsFile string
+	// HostKey verifies the host key with a single authorized_keys line, e.g. "ssh-ed25519 AAAA...".
+	HostKey string
+	// InsecureIgnoreHostKey skips host key verification, never use it in production.
+	InsecureIgnoreHostKey bool
+	// Timeout is the dial timeout, default is 10s.
+	Timeout time.Duration
+	// PoolSize is the max number of connections, default is 4.
+	PoolSize int
+}
+
+var _defaultSftpParams = SftpParams{
+	Port:     22,
+	Timeout:  10 * time.Second,
+	PoolSize: 4,
+}
+
+var (
+	ERR_SFTP_HOST_KEY_REQUIRED = errors.New("sftp host key verification is not configured")
+	ERR_SFTP_CLOSED            = errors.New("sftp client is closed")
+)
+
+// SftpClient is a pool of sftp connections with a simple transfer api.
+type SftpClient struct {
+	params SftpParams
+	config *ssh.ClientConfig
+	idle   chan *sftpConn
+	slots  chan struct{}
+	// lock guards closed, so a connection is either put back before Close drains the idle ones or closed
+	lock   sync.Mutex
+	closed bool
+}
+
+type sftpConn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+func (c *sftpConn) Close() error {
+	return errors.Join(c.sftp.Close(), c.ssh.Close())
+}
+
+// NewSftpClient creates the pool, connections are dialed on demand. Host key verification is required,
+// unless InsecureIgnoreHostKey is set.
+func NewSftpClient(params SftpParams) (*SftpClient, error) {
+	if params.Port == 0 {
+		params.Port = _defaultSftpParams.Port
+	}
+	if params.Timeout <= 0 {
+		params.Timeout = _defaultSftpParams.Timeout
+	}
+	if params.PoolSize <= 0 {
+		params.PoolSize = _defaultSftpParams.PoolSize
+	}
+	config := &ssh.ClientConfig{User: params.User, Timeout: params.Timeout}
+	switch {
+	case params.HostKey != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(params.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp host key: %w", err)
+		}
+		config.HostKeyCallback = ssh.FixedHostKey(key)
+	case params.KnownHostsFile != "":
+		callback, err := knownhosts.New(params.KnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+		config.HostKeyCallback = callback
+	case params.InsecureIgnoreHostKey:
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, ERR_SFTP_HOST_KEY_REQUIRED
+	}
+	if params.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(params.Password))
+	}
+	key := []byte(params.PrivateKey)
+	if len(key) == 0 && params.PrivateKeyFile != "" {
+		data, err := os.ReadFile(params.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = data
+	}
+	if len(key) > 0 {
+		var signer ssh.Signer
+		var err error
+		if params.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(params.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp private key: %w", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	return &SftpClient{
+		params: params,
+		config: config,
+		idle:   make(chan *sftpConn, params.PoolSize),
+		slots:  make(chan struct{}, params.PoolSize),
+	}, nil
+}
+
+func (c *SftpClient) dial() (*sftpConn, error) {
+	addr := net.JoinHostPort(c.params.Host, strconv.Itoa(c.params.Port))
+	sshClient, err := ssh.Dial("tcp", addr, c.config)
+	if err != nil {
+		return nil, err
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, err
+	}
+	return &sftpConn{ssh: sshClient, sftp: sftpClient}, nil
+}
+
+// Do runs fn with a pooled connection, it waits for a free connection until ctx is done. An idle connection is
+// checked before it's reused and redialed if it's dead, and it's dropped if fn fails because the connection is lost.
+// It returns ERR_SFTP_CLOSED after Close.
+func (c *SftpClient) Do(ctx context.Context, fn func(client *sftp.Client) error) error {
+	if c.isClosed() {
+		return ERR_SFTP_CLOSED
+	}
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.slots }()
+	conn, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	err = fn(conn.sftp)
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, sftp.ErrSSHFxNoConnection) || errors.Is(err, io.ErrUnexpectedEOF) {
+		_ = conn.Close()
+		return err
+	}
+	c.release(conn)
+	return err
+}
+
+// acquire returns a live idle connection or dials a new one.
+func (c *SftpClient) acquire() (*sftpConn, error) {
+	for {
+		select {
+		case conn := <-c.idle:
+			// the server may have dropped the idle connection
+			if _, err := conn.sftp.Getwd(); err != nil {
+				_ = conn.Close()
+				continue
+			}
+			return conn, nil
+		default:
+			return c.dial()
+		}
+	}
+}
+
+// release puts conn back to the pool, or closes it if the client is closed.
+func (c *SftpClient) release(conn *sftpConn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		_ = conn.Close()
+		return
+	}
+	c.idle <- conn
+}
+
+func (c *SftpClient) isClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
+
+// Upload writes r to the remote file, missing directories are created.
+func (c *SftpClient) Upload(ctx context.Context, remote string, r io.Reader) error {
+	return c.Do(ctx, func(client *sftp.Client) error {
+		if err := client.MkdirAll(path.Dir(remote)); err != nil {
+			return err
+		}
+		f, err := client.Create(remote)
+		if err != nil {
+			return err
+		}
+		if _, err := f.ReadFrom(r); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// Download writes the remote file to w.
+func (c *SftpClient) Download(ctx context.Context, remote string, w io.Writer) error {
+	return c.Do(ctx, func(client *sftp.Client) error {
+		f, err := client.Open(remote)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.WriteTo(w)
+		return err
+	})
+}
+
+// List returns the entries of the remote dir.
+func (c *SftpClient) List(ctx context.Context, dir string) ([]os.FileInfo, error) {
+	var entries []os.FileInfo
+	err := c.Do(ctx, func(client *sftp.Client) error {
+		var err error
+		entries, err = client.ReadDir(dir)
+		return err
+	})
+	return entries, err
+}
+
+// Rename moves the remote file, e.g. to an archive dir after it's processed.
+func (c *SftpClient) Rename(ctx context.Context, from, to string) error {
+	return c.Do(ctx, func(client *sftp.Client) error {
+		return client.PosixRename(from, to)
+	})
+}
+
+func (c *SftpClient) Remove(ctx context.Context, remote string) error {
+	return c.Do(ctx, func(client *sftp.Client) error {
+		return client.Remove(remote)
+	})
+}
+
+// Close closes the idle connections, connections in use are closed when they're returned. Do fails with
+// ERR_SFTP_CLOSED after Close.
+func (c *SftpClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	var errs []error
+	for {
+		select {
+		case conn := <-c.idle:
+			errs = append(errs, conn.Close())
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
+
+type sftpProvider struct {
+	*GiuProvider[*SftpClient]
+}
+
+func (sp *sftpProvider) Shutdown() error {
+	return sp.ShutdownContext(context.Background())
+}
+
+func (sp *sftpProvider) ShutdownContext(ctx context.Context) error {
+	return sp.shutdownItems(ctx, (*SftpClient).Close)
+}
+
+// NewSftpProvider creates a sftp provider from existing clients, if items is not empty, the first item will be set as default
+func NewSftpProvider(clients ...map[string]*SftpClient) Provider[*SftpClient] {
+	return &sftpProvider{
+		GiuProvider: NewGiuProvider[*SftpClient](clients...),
+	}
+}
+
+// NewSftpProviderFromParams creates a sftp provider from params, if items is not empty, the first item will be set as default
+func NewSftpProviderFromParams(params map[string]*SftpParams) (Provider[*SftpClient], error) {
+	giu, err := NewGiuProviderFromParamsError[*SftpClient, *SftpParams](func(p *SftpParams) (*SftpClient, error) {
+		return NewSftpClient(*p)
+	}, params)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpProvider{GiuProvider: giu}, nil
+}
+
+// NewSftpProviderFromConfig creates a sftp provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
+func NewSftpProviderFromConfig(config *viper.Viper) (Provider[*SftpClient], error) {
+	var params map[string]*SftpParams
+	if err := config.UnmarshalKey("sftp", &params); err != nil {
+		return nil, err
+	}
+	return NewSftpProviderFromParams(params)
+}
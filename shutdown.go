@@ -0,0 +1,140 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// ShutdownFunc closes a resource, it should return when ctx is done.
+type ShutdownFunc func(ctx context.Context) error
+
+type ShutdownParams struct {
+	// Timeout is the max time of the whole shutdown after a signal, default is 30s.
+	Timeout time.Duration
+}
+
+var _defaultShutdownParams = ShutdownParams{
+	Timeout: 30 * time.Second,
+}
+
+type shutdownCloser struct {
+	name string
+	fn   ShutdownFunc
+}
+
+// ShutdownManager shuts down the registered providers and closers in reverse registration order, so the ones
+// registered first, e.g. loggers, are closed last.
+type ShutdownManager struct {
+	params  ShutdownParams
+	logger  *zap.Logger
+	lock    sync.Mutex
+	closers []shutdownCloser
+	once    sync.Once
+	err     error
+	done    chan struct{}
+}
+
+func NewShutdownManager(params ShutdownParams, zl *zap.Logger) *ShutdownManager {
+	if params.Timeout <= 0 {
+		params.Timeout = _defaultShutdownParams.Timeout
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &ShutdownManager{params: params, logger: zl.With(zap.String("module", "shutdown")), done: make(chan struct{})}
+}
+
+// NewShutdownManagerFromConfig creates a shutdown manager from viper config with key "shutdown".
+func NewShutdownManagerFromConfig(config *viper.Viper, zl *zap.Logger) (*ShutdownManager, error) {
+	var params ShutdownParams
+	if err := config.UnmarshalKey("shutdown", &params); err != nil {
+		return nil, err
+	}
+	return NewShutdownManager(params, zl), nil
+}
+
+// Register registers a closer.
+func (m *ShutdownManager) Register(name string, fn ShutdownFunc) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closers = append(m.closers, shutdownCloser{name: name, fn: fn})
+}
+
+// RegisterProvider registers anything with a Shutdown method, e.g. a Provider or an App. ShutdownContext is
+// called instead if it's implemented.
+func (m *ShutdownManager) RegisterProvider(name string, p interface{ Shutdown() error }) {
+	if cp, ok := p.(interface {
+		ShutdownContext(ctx context.Context) error
+	}); ok {
+		m.Register(name, cp.ShutdownContext)
+		return
+	}
+	m.Register(name, func(context.Context) error {
+		return p.Shutdown()
+	})
+}
+
+// Shutdown runs the closers in reverse registration order, all closers run even if some fail and the errors are
+// joined. When ctx is done, the running closer is abandoned and the rest are skipped. It runs only once, the
+// later calls return the same error.
+func (m *ShutdownManager) Shutdown(ctx context.Context) error {
+	m.once.Do(func() {
+		defer close(m.done)
+		m.lock.Lock()
+		closers := append([]shutdownCloser(nil), m.closers...)
+		m.lock.Unlock()
+		var errs []error
+		for i := len(closers) - 1; i >= 0; i-- {
+			c := closers[i]
+			if err := m.close(ctx, c); err != nil {
+				m.logger.Error("[shutdown] close failed", zap.String("name", c.name), zap.Error(err))
+				errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+				continue
+			}
+			m.logger.Debug("[shutdown] closed", zap.String("name", c.name))
+		}
+		m.err = errors.Join(errs...)
+	})
+	return m.err
+}
+
+func (m *ShutdownManager) close(ctx context.Context, c shutdownCloser) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- c.fn(ctx)
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed when Shutdown is finished.
+func (m *ShutdownManager) Done() <-chan struct{} {
+	return m.done
+}
+
+// Wait blocks until SIGINT or SIGTERM is received, or ctx is done, then shuts down within the timeout.
+func (m *ShutdownManager) Wait(ctx context.Context) error {
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-sigCtx.Done()
+	m.logger.Info("[shutdown] shutting down", zap.Duration("timeout", m.params.Timeout))
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.params.Timeout)
+	defer cancel()
+	return m.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,220 @@
+package giu
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+type SignatureParams struct {
+	// Algorithm is the signature algorithm: hmac or rsa.
+	Algorithm string
+	// Secret is the shared secret of hmac.
+	Secret string
+	// PublicKey is the PEM encoded public key of rsa.
+	PublicKey string
+	// Tolerance is the max difference between the request timestamp and now, default is 5 minutes.
+	Tolerance time.Duration
+	// NoncePrefix is the redis key prefix of used nonces, default is "giu:nonce:".
+	NoncePrefix string
+	// MaxBody is the max bytes of verified bodies, default is 1MB.
+	MaxBody int64
+}
+
+const (
+	SIGNATURE_ALGORITHM_HMAC = "hmac"
+	SIGNATURE_ALGORITHM_RSA  = "rsa"
+)
+
+const (
+	SIGNATURE_HEADER_TIMESTAMP = "X-Giu-Timestamp"
+	SIGNATURE_HEADER_SIGNATURE = "X-Giu-Signature"
+	// SIGNATURE_HEADER_NONCE is the nonce header, it's signed and used as the replay key.
+	SIGNATURE_HEADER_NONCE = "X-Giu-Nonce"
+)
+
+var (
+	ERR_SIGNATURE_MISSING   = errors.New("signature, timestamp or nonce is missing")
+	ERR_SIGNATURE_EXPIRED   = errors.New("signature timestamp is out of tolerance")
+	ERR_SIGNATURE_INVALID   = errors.New("signature is invalid")
+	ERR_SIGNATURE_REPLAYED  = errors.New("signature nonce is already used")
+	ERR_SIGNATURE_TOO_LARGE = errors.New("signed body is too large")
+)
+
+// SignRequest returns hex encoded HMAC-SHA256 of the canonical request "method\nuri\ntimestamp\nnonce\nbody" with
+// secret, uri is the path with the query, e.g. "/orders?id=1". The nonce must be unique per request.
+func SignRequest(secret, method, uri, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(signatureCanonical(method, uri, timestamp, nonce, body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequestRSA returns base64 encoded RSA-SHA256 signature of the canonical request, it's the rsa variant of
+// SignRequest.
+func SignRequestRSA(key *rsa.PrivateKey, method, uri, timestamp, nonce string, body []byte) (string, error) {
+	digest := sha256.Sum256(signatureCanonical(method, uri, timestamp, nonce, body))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func signatureCanonical(method, uri, timestamp, nonce string, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(method) + len(uri) + len(timestamp) + len(nonce) + len(body) + 4)
+	for _, part := range []string{method, uri, timestamp, nonce} {
+		buf.WriteString(part)
+		buf.WriteByte('\n')
+	}
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// SignatureVerifier verifies the signatures made by SignRequest or SignRequestRSA.
+type SignatureVerifier struct {
+	params    SignatureParams
+	publicKey *rsa.PublicKey
+	rdb       redis.UniversalClient
+	clock     Clock
+}
+
+// NewSignatureVerifier creates a verifier, if rdb is nil, nonces are not checked.
+func NewSignatureVerifier(params SignatureParams, rdb redis.UniversalClient) (*SignatureVerifier, error) {
+	if params.Tolerance <= 0 {
+		params.Tolerance = 5 * time.Minute
+	}
+	if params.NoncePrefix == "" {
+		params.NoncePrefix = "giu:nonce:"
+	}
+	if params.MaxBody <= 0 {
+		params.MaxBody = 1 << 20
+	}
+	v := &SignatureVerifier{params: params, rdb: rdb, clock: SystemClock}
+	switch params.Algorithm {
+	case SIGNATURE_ALGORITHM_HMAC:
+		if params.Secret == "" {
+			return nil, errors.New("hmac secret is empty")
+		}
+	case SIGNATURE_ALGORITHM_RSA:
+		block, _ := pem.Decode([]byte(params.PublicKey))
+		if block == nil {
+			return nil, errors.New("invalid rsa public key")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("public key is not rsa")
+		}
+		v.publicKey = pub
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm: %s", params.Algorithm)
+	}
+	return v, nil
+}
+
+// SetClock sets the time source of timestamp checking, nil means SystemClock.
+func (v *SignatureVerifier) SetClock(c Clock) {
+	v.clock = clockOrSystem(c)
+}
+
+// Verify verifies the method, path, query, timestamp, nonce and body of the request, the body is read and restored.
+func (v *SignatureVerifier) Verify(req *http.Request) error {
+	timestamp := req.Header.Get(SIGNATURE_HEADER_TIMESTAMP)
+	signature := req.Header.Get(SIGNATURE_HEADER_SIGNATURE)
+	nonce := req.Header.Get(SIGNATURE_HEADER_NONCE)
+	if timestamp == "" || signature == "" || nonce == "" {
+		return ERR_SIGNATURE_MISSING
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ERR_SIGNATURE_INVALID
+	}
+	if d := v.clock.Since(time.Unix(ts, 0)); d > v.params.Tolerance || d < -v.params.Tolerance {
+		return ERR_SIGNATURE_EXPIRED
+	}
+	var body []byte
+	if req.Body != nil {
+		if body, err = io.ReadAll(io.LimitReader(req.Body, v.params.MaxBody+1)); err != nil {
+			return err
+		}
+		if int64(len(body)) > v.params.MaxBody {
+			return ERR_SIGNATURE_TOO_LARGE
+		}
+		req.Body = io.NopCloser(bytes.NewBuffer(body))
+	}
+	uri := req.URL.RequestURI()
+	switch v.params.Algorithm {
+	case SIGNATURE_ALGORITHM_HMAC:
+		expected, _ := hex.DecodeString(SignRequest(v.params.Secret, req.Method, uri, timestamp, nonce, body))
+		actual, err := hex.DecodeString(signature)
+		if err != nil || !hmac.Equal(expected, actual) {
+			return ERR_SIGNATURE_INVALID
+		}
+	case SIGNATURE_ALGORITHM_RSA:
+		sig, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			return ERR_SIGNATURE_INVALID
+		}
+		digest := sha256.Sum256(signatureCanonical(req.Method, uri, timestamp, nonce, body))
+		if err := rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, digest[:], sig); err != nil {
+			return ERR_SIGNATURE_INVALID
+		}
+	}
+	if v.rdb != nil {
+		// the nonce is signed, so it can't be changed to replay the request; keep the nonce a little longer than the tolerance window on both sides
+		ok, err := v.rdb.SetNX(req.Context(), v.params.NoncePrefix+nonce, timestamp, 2*v.params.Tolerance).Result()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ERR_SIGNATURE_REPLAYED
+		}
+	}
+	return nil
+}
+
+// NewGinMiddlewareSignature returns a gin middleware which rejects requests without a valid signature.
+// Replay protection is enabled when rdb is not nil.
+func NewGinMiddlewareSignature(params SignatureParams, rdb redis.UniversalClient, zl *zap.Logger) (gin.HandlerFunc, error) {
+	v, err := NewSignatureVerifier(params, rdb)
+	if err != nil {
+		return nil, err
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	zl = zl.With(zap.String("module", "gin"), zap.String("type", "signature"))
+	return func(c *gin.Context) {
+		if err := v.Verify(c.Request); err != nil {
+			zl.Warn("[gin signature] verify failed",
+				zap.Error(err),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String(GIN_TRACE_ID, c.GetHeader(GIN_TRACE_ID)))
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}, nil
+}
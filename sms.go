@@ -0,0 +1,322 @@
+package giu
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// SmsMessage is a templated message, Template is the name of a template in SmsParams.Templates.
+type SmsMessage struct {
+	To       string
+	Template string
+	Vars     map[string]string
+}
+
+// SmsReceipt is the gateway response of an accepted message.
+type SmsReceipt struct {
+	ID     string
+	Status string
+}
+
+// SmsSender sends a message with a sms gateway.
+type SmsSender interface {
+	Send(ctx context.Context, msg SmsMessage) (SmsReceipt, error)
+}
+
+var ERR_SMS_TEMPLATE_NOT_FOUND = errors.New("sms template not found")
+
+type SmsParams struct {
+	// Type is the sms gateway: twilio, aliyun.
+	Type string
+	// AccessKey and AccessSecret are the account sid and auth token of twilio, or the access key of aliyun.
+	AccessKey    string
+	AccessSecret string
+	// From is the sender number of twilio, or the sign name of aliyun.
+	From string
+	// Templates maps template names to message bodies in text/template syntax for twilio,
+	// or to template codes for aliyun, whose templates are managed in the console.
+	Templates map[string]string
+	// StatusCallback is the url twilio posts delivery status updates to.
+	StatusCallback string
+	// Region is the aliyun region, default is "cn-hangzhou".
+	Region string
+	// Timeout is the http request timeout, default is 10s.
+	Timeout time.Duration
+	// RateLimit limits the messages per recipient with a local limiter, use SetLimiter to share a redis limiter.
+	RateLimit *RateLimiterParams
+}
+
+var _defaultSmsParams = SmsParams{
+	Region:  "cn-hangzhou",
+	Timeout: 10 * time.Second,
+}
+
+const (
+	SMS_TYPE_TWILIO = "twilio"
+	SMS_TYPE_ALIYUN = "aliyun"
+)
+
+// NewSmsSender creates a sms sender by params type.
+func NewSmsSender(params *SmsParams) (SmsSender, error) {
+	if params.Timeout <= 0 {
+		params.Timeout = _defaultSmsParams.Timeout
+	}
+	switch params.Type {
+	case SMS_TYPE_TWILIO:
+		return NewTwilioSmsSender(params)
+	case SMS_TYPE_ALIYUN:
+		return NewAliyunSmsSender(params), nil
+	default:
+		return nil, fmt.Errorf("unsupported sms type: %s", params.Type)
+	}
+}
+
+type TwilioSmsSender struct {
+	client         *resty.Client
+	url            string
+	from           string
+	statusCallback string
+	templates      map[string]*template.Template
+}
+
+func NewTwilioSmsSender(params *SmsParams) (*TwilioSmsSender, error) {
+	templates := make(map[string]*template.Template, len(params.Templates))
+	for name, body := range params.Templates {
+		t, err := template.New(name).Option("missingkey=error").Parse(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sms template %s: %w", name, err)
+		}
+		templates[name] = t
+	}
+	return &TwilioSmsSender{
+		client:         NewResty(&RestyParams{Timeout: params.Timeout}).SetBasicAuth(params.AccessKey, params.AccessSecret),
+		url:            "https://api.twilio.com/2010-04-01/Accounts/" + params.AccessKey + "/Messages.json",
+		from:           params.From,
+		statusCallback: params.StatusCallback,
+		templates:      templates,
+	}, nil
+}
+
+func (t *TwilioSmsSender) Send(ctx context.Context, msg SmsMessage) (SmsReceipt, error) {
+	tpl, ok := t.templates[msg.Template]
+	if !ok {
+		return SmsReceipt{}, fmt.Errorf("%w: %s", ERR_SMS_TEMPLATE_NOT_FOUND, msg.Template)
+	}
+	var body strings.Builder
+	if err := tpl.Execute(&body, msg.Vars); err != nil {
+		return SmsReceipt{}, err
+	}
+	form := map[string]string{"From": t.from, "To": msg.To, "Body": body.String()}
+	if t.statusCallback != "" {
+		form["StatusCallback"] = t.statusCallback
+	}
+	var accepted struct {
+		Sid    string `json:"sid"`
+		Status string `json:"status"`
+	}
+	var fail struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	resp, err := t.client.R().SetContext(ctx).SetFormData(form).SetResult(&accepted).SetError(&fail).Post(t.url)
+	if err != nil {
+		return SmsReceipt{}, err
+	}
+	if resp.IsError() {
+		return SmsReceipt{}, fmt.Errorf("twilio request failed, status: %d, code: %d, message: %s", resp.StatusCode(), fail.Code, fail.Message)
+	}
+	return SmsReceipt{ID: accepted.Sid, Status: accepted.Status}, nil
+}
+
+type AliyunSmsSender struct {
+	client    *resty.Client
+	key       string
+	secret    string
+	signName  string
+	region    string
+	templates map[string]string
+}
+
+func NewAliyunSmsSender(params *SmsParams) *AliyunSmsSender {
+	region := params.Region
+	if region == "" {
+		region = _defaultSmsParams.Region
+	}
+	return &AliyunSmsSender{
+		client:    NewResty(&RestyParams{Timeout: params.Timeout}),
+		key:       params.AccessKey,
+		secret:    params.AccessSecret,
+		signName:  params.From,
+		region:    region,
+		templates: params.Templates,
+	}
+}
+
+// aliyunEscape is the percent encoding of aliyun rpc signatures.
+func aliyunEscape(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.ReplaceAll(s, "+", "%20")
+	s = strings.ReplaceAll(s, "*", "%2A")
+	return strings.ReplaceAll(s, "%7E", "~")
+}
+
+func (a *AliyunSmsSender) Send(ctx context.Context, msg SmsMessage) (SmsReceipt, error) {
+	code, ok := a.templates[msg.Template]
+	if !ok {
+		return SmsReceipt{}, fmt.Errorf("%w: %s", ERR_SMS_TEMPLATE_NOT_FOUND, msg.Template)
+	}
+	vars, err := json.Marshal(msg.Vars)
+	if err != nil {
+		return SmsReceipt{}, err
+	}
+	query := map[string]string{
+		"AccessKeyId":      a.key,
+		"Action":           "SendSms",
+		"Format":           "JSON",
+		"PhoneNumbers":     msg.To,
+		"RegionId":         a.region,
+		"SignName":         a.signName,
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   uuid.NewString(),
+		"SignatureVersion": "1.0",
+		"TemplateCode":     code,
+		"TemplateParam":    string(vars),
+		"Timestamp":        time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		"Version":          "2017-05-25",
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, aliyunEscape(k)+"="+aliyunEscape(query[k]))
+	}
+	canonical := strings.Join(pairs, "&")
+	mac := hmac.New(sha1.New, []byte(a.secret+"&"))
+	mac.Write([]byte("GET&%2F&" + aliyunEscape(canonical)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	var result struct {
+		Code    string `json:"Code"`
+		Message string `json:"Message"`
+		BizId   string `json:"BizId"`
+	}
+	resp, err := a.client.R().SetContext(ctx).SetResult(&result).SetError(&result).
+		Get("https://dysmsapi.aliyuncs.com/?Signature=" + aliyunEscape(signature) + "&" + canonical)
+	if err != nil {
+		return SmsReceipt{}, err
+	}
+	if resp.IsError() || result.Code != "OK" {
+		return SmsReceipt{}, fmt.Errorf("aliyun sms request failed, status: %d, code: %s, message: %s", resp.StatusCode(), result.Code, result.Message)
+	}
+	return SmsReceipt{ID: result.BizId, Status: result.Code}, nil
+}
+
+// SmsClient sends messages with a sender, rate limited per recipient, and logs the delivery status.
+type SmsClient struct {
+	sender  SmsSender
+	limiter Limiter
+	logger  *zap.Logger
+}
+
+// NewSmsClient creates the sender of params type.
+func NewSmsClient(params *SmsParams, zl *zap.Logger) (*SmsClient, error) {
+	sender, err := NewSmsSender(params)
+	if err != nil {
+		return nil, err
+	}
+	client := NewSmsClientWithSender(sender, zl)
+	client.logger = client.logger.With(zap.String("type", params.Type))
+	if params.RateLimit != nil {
+		client.limiter = NewLocalLimiter(*params.RateLimit)
+	}
+	return client, nil
+}
+
+// NewSmsClientWithSender creates a sms client with a custom sender.
+func NewSmsClientWithSender(sender SmsSender, zl *zap.Logger) *SmsClient {
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &SmsClient{sender: sender, logger: zl.With(zap.String("module", "sms"))}
+}
+
+// SetLimiter limits the messages per recipient with l, e.g. a RedisLimiter shared by instances.
+func (s *SmsClient) SetLimiter(l Limiter) {
+	s.limiter = l
+}
+
+// Send sends msg, it fails with ERR_RATE_LIMITED if the recipient is over the limit.
+func (s *SmsClient) Send(ctx context.Context, msg SmsMessage) (SmsReceipt, error) {
+	if s.limiter != nil {
+		ok, err := s.limiter.Allow(ctx, "sms:"+msg.To)
+		if err != nil {
+			return SmsReceipt{}, err
+		}
+		if !ok {
+			s.logger.Warn("[sms] rate limited", zap.String("to", msg.To), zap.String("template", msg.Template))
+			return SmsReceipt{}, fmt.Errorf("%w: sms to %s", ERR_RATE_LIMITED, msg.To)
+		}
+	}
+	receipt, err := s.sender.Send(ctx, msg)
+	if err != nil {
+		s.logger.Warn("[sms] send failed", zap.Error(err), zap.String("to", msg.To), zap.String("template", msg.Template))
+		return receipt, err
+	}
+	s.logger.Info("[sms] sent",
+		zap.String("to", msg.To),
+		zap.String("template", msg.Template),
+		zap.String("id", receipt.ID),
+		zap.String("status", receipt.Status))
+	return receipt, nil
+}
+
+type SmsProvider interface {
+	Provider[*SmsClient]
+}
+
+type smsProvider struct {
+	*GiuProvider[*SmsClient]
+}
+
+// NewSmsProvider creates a sms provider from existing clients, if items is not empty, the first item will be set as default
+func NewSmsProvider(clients ...map[string]*SmsClient) SmsProvider {
+	return &smsProvider{
+		GiuProvider: NewGiuProvider[*SmsClient](clients...),
+	}
+}
+
+// NewSmsProviderFromParams creates a sms provider from params, if items is not empty, the first item will be set as default
+func NewSmsProviderFromParams(params map[string]*SmsParams, zl *zap.Logger) (SmsProvider, error) {
+	giu, err := NewGiuProviderWithLoggerFromParamsError[*SmsClient, *SmsParams](NewSmsClient, params, zl)
+	if err != nil {
+		return nil, err
+	}
+	return &smsProvider{GiuProvider: giu}, nil
+}
+
+// NewSmsProviderFromConfig creates a sms provider from viper config and GiuConfig struct, if items is not empty, the first item will be set as default
+func NewSmsProviderFromConfig(config *viper.Viper, zl *zap.Logger) (SmsProvider, error) {
+	var params map[string]*SmsParams
+	if err := config.UnmarshalKey("sms", &params); err != nil {
+		return nil, err
+	}
+	return NewSmsProviderFromParams(params, zl)
+}
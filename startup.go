@@ -0,0 +1,130 @@
+package giu
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ERR_STARTUP_BUDGET_EXCEEDED = errors.New("startup time budget exceeded")
+
+type StartupParams struct {
+	// Budget is the max time of Bootstrap including its hooks, Bootstrap fails if it's exceeded, 0 means no budget.
+	Budget time.Duration
+}
+
+// StartupPhase is the timing of a startup phase.
+type StartupPhase struct {
+	Name    string
+	Begin   time.Time
+	Elapsed time.Duration
+	Err     error
+}
+
+// StartupTimer times the startup phases, e.g. config loading, each provider and hooks, and reports them.
+// Phases may run concurrently, they are reported in the order they begin.
+type StartupTimer struct {
+	budget time.Duration
+	clock  Clock
+	begin  time.Time
+	lock   sync.Mutex
+	phases []StartupPhase
+}
+
+// NewStartupTimer creates a timer, if budget is positive, Check fails once the total time exceeds it.
+func NewStartupTimer(budget time.Duration) *StartupTimer {
+	return &StartupTimer{budget: budget, clock: SystemClock, begin: time.Now()}
+}
+
+// setBudget sets the budget once it's read from config, the timer is not restarted.
+func (t *StartupTimer) setBudget(budget time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.budget = budget
+}
+
+// SetClock sets the time source of the timer and restarts it, nil means SystemClock.
+func (t *StartupTimer) SetClock(c Clock) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.clock = clockOrSystem(c)
+	t.begin = t.clock.Now()
+}
+
+// Phase runs fn as the named phase and records its timing, the error of fn is returned as is.
+func (t *StartupTimer) Phase(name string, fn func() error) error {
+	t.lock.Lock()
+	i := len(t.phases)
+	begin := t.clock.Now()
+	t.phases = append(t.phases, StartupPhase{Name: name, Begin: begin})
+	t.lock.Unlock()
+
+	err := fn()
+
+	t.lock.Lock()
+	t.phases[i].Elapsed = t.clock.Since(begin)
+	t.phases[i].Err = err
+	t.lock.Unlock()
+	return err
+}
+
+// Elapsed returns the total time since the timer is created.
+func (t *StartupTimer) Elapsed() time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.clock.Since(t.begin)
+}
+
+// Phases returns the recorded phases.
+func (t *StartupTimer) Phases() []StartupPhase {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]StartupPhase(nil), t.phases...)
+}
+
+// Check returns ERR_STARTUP_BUDGET_EXCEEDED if the total time exceeds the budget.
+func (t *StartupTimer) Check() error {
+	budget := t.getBudget()
+	if budget <= 0 {
+		return nil
+	}
+	if elapsed := t.Elapsed(); elapsed > budget {
+		return fmt.Errorf("%w: %s > %s", ERR_STARTUP_BUDGET_EXCEEDED, elapsed, budget)
+	}
+	return nil
+}
+
+func (t *StartupTimer) getBudget() time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.budget
+}
+
+// Report logs the startup report with a field of each phase, in warn level if the budget is exceeded.
+func (t *StartupTimer) Report(zl *zap.Logger) {
+	if zl == nil {
+		return
+	}
+	phases := t.Phases()
+	fields := make([]zap.Field, 0, len(phases)+2)
+	fields = append(fields, zap.Duration("total", t.Elapsed()))
+	if budget := t.getBudget(); budget > 0 {
+		fields = append(fields, zap.Duration("budget", budget))
+	}
+	for _, p := range phases {
+		if p.Err != nil {
+			fields = append(fields, zap.String(p.Name, fmt.Sprintf("%s (%s)", p.Elapsed, p.Err)))
+			continue
+		}
+		fields = append(fields, zap.Duration(p.Name, p.Elapsed))
+	}
+	zl = zl.With(zap.String("module", "startup"))
+	if t.Check() != nil {
+		zl.Warn("[startup] startup report, budget exceeded", fields...)
+		return
+	}
+	zl.Info("[startup] startup report", fields...)
+}
@@ -0,0 +1,235 @@
+package giu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type StreamConsumerParams struct {
+	Stream string
+	Group  string
+	// Consumer is the consumer name in group, default is "hostname-pid".
+	Consumer string
+	// BatchSize is the max messages of each read, default is 10.
+	BatchSize int64
+	// Block is the max time of each blocking read, default is 5s.
+	Block time.Duration
+	// MaxRetries is the max deliveries of a message before it's moved to the dead letter stream, default is 3.
+	MaxRetries int64
+	// ClaimMinIdle is the idle time after which pending messages are claimed and retried, default is 1 minute.
+	ClaimMinIdle time.Duration
+	// ClaimInterval is the interval of checking pending messages, default is 30s.
+	ClaimInterval time.Duration
+	// DeadLetterStream is the stream of failed messages, default is Stream + ":dlq".
+	DeadLetterStream string
+}
+
+var _defaultStreamConsumerParams = StreamConsumerParams{
+	BatchSize:     10,
+	Block:         5 * time.Second,
+	MaxRetries:    3,
+	ClaimMinIdle:  time.Minute,
+	ClaimInterval: 30 * time.Second,
+}
+
+// StreamHandler handles a message, the message is acked if it returns nil, otherwise it's retried later.
+type StreamHandler func(ctx context.Context, msg redis.XMessage) error
+
+// StreamConsumer is a managed consumer of a redis stream consumer group.
+type StreamConsumer struct {
+	rdb     redis.UniversalClient
+	params  StreamConsumerParams
+	handler StreamHandler
+	logger  *zap.Logger
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewStreamConsumer(rdb redis.UniversalClient, params StreamConsumerParams, handler StreamHandler, zl *zap.Logger) *StreamConsumer {
+	d := _defaultStreamConsumerParams
+	if params.Consumer == "" {
+		host, _ := os.Hostname()
+		params.Consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if params.BatchSize <= 0 {
+		params.BatchSize = d.BatchSize
+	}
+	if params.Block <= 0 {
+		params.Block = d.Block
+	}
+	if params.MaxRetries <= 0 {
+		params.MaxRetries = d.MaxRetries
+	}
+	if params.ClaimMinIdle <= 0 {
+		params.ClaimMinIdle = d.ClaimMinIdle
+	}
+	if params.ClaimInterval <= 0 {
+		params.ClaimInterval = d.ClaimInterval
+	}
+	if params.DeadLetterStream == "" {
+		params.DeadLetterStream = params.Stream + ":dlq"
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &StreamConsumer{
+		rdb:     rdb,
+		params:  params,
+		handler: handler,
+		logger:  zl.With(zap.String("module", "stream"), zap.String("stream", params.Stream), zap.String("group", params.Group)),
+	}
+}
+
+// NewStreamConsumerFromConfig creates a stream consumer from viper config with key "stream.<name>".
+func NewStreamConsumerFromConfig(config *viper.Viper, name string, rdb redis.UniversalClient, handler StreamHandler, zl *zap.Logger) (*StreamConsumer, error) {
+	var params StreamConsumerParams
+	if err := config.UnmarshalKey("stream."+name, &params); err != nil {
+		return nil, err
+	}
+	return NewStreamConsumer(rdb, params, handler, zl), nil
+}
+
+// Start creates the group if it doesn't exist and starts consuming in new goroutines.
+func (sc *StreamConsumer) Start(ctx context.Context) error {
+	err := sc.rdb.XGroupCreateMkStream(ctx, sc.params.Stream, sc.params.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	sc.cancel = cancel
+	sc.wg.Add(2)
+	go sc.consume(runCtx)
+	go sc.claim(runCtx)
+	return nil
+}
+
+// Shutdown stops consuming and waits for the handling messages.
+func (sc *StreamConsumer) Shutdown() error {
+	if sc.cancel != nil {
+		sc.cancel()
+	}
+	sc.wg.Wait()
+	return nil
+}
+
+func (sc *StreamConsumer) consume(ctx context.Context) {
+	defer sc.wg.Done()
+	for ctx.Err() == nil {
+		streams, err := sc.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    sc.params.Group,
+			Consumer: sc.params.Consumer,
+			Streams:  []string{sc.params.Stream, ">"},
+			Count:    sc.params.BatchSize,
+			Block:    sc.params.Block,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			sc.logger.Error("[stream] read failed", zap.Error(err))
+			sleepContext(ctx, time.Second)
+			continue
+		}
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				sc.handle(ctx, msg)
+			}
+		}
+	}
+}
+
+// claim retries the pending messages idle too long, messages exceeding max retries are moved to dead letter stream.
+func (sc *StreamConsumer) claim(ctx context.Context) {
+	defer sc.wg.Done()
+	ticker := time.NewTicker(sc.params.ClaimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pending, err := sc.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: sc.params.Stream,
+			Group:  sc.params.Group,
+			Idle:   sc.params.ClaimMinIdle,
+			Start:  "-",
+			End:    "+",
+			Count:  sc.params.BatchSize,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				sc.logger.Error("[stream] pending failed", zap.Error(err))
+			}
+			continue
+		}
+		for _, p := range pending {
+			msgs, err := sc.rdb.XClaim(ctx, &redis.XClaimArgs{
+				Stream:   sc.params.Stream,
+				Group:    sc.params.Group,
+				Consumer: sc.params.Consumer,
+				MinIdle:  sc.params.ClaimMinIdle,
+				Messages: []string{p.ID},
+			}).Result()
+			if err != nil || len(msgs) == 0 {
+				continue
+			}
+			if p.RetryCount >= sc.params.MaxRetries {
+				sc.deadLetter(ctx, msgs[0], p.RetryCount)
+				continue
+			}
+			sc.handle(ctx, msgs[0])
+		}
+	}
+}
+
+func (sc *StreamConsumer) handle(ctx context.Context, msg redis.XMessage) {
+	// let the handling message finish when shutting down
+	ctx = context.WithoutCancel(ctx)
+	if err := sc.handler(ctx, msg); err != nil {
+		sc.logger.Warn("[stream] handle failed", zap.String("id", msg.ID), zap.Error(err))
+		return
+	}
+	if err := sc.rdb.XAck(ctx, sc.params.Stream, sc.params.Group, msg.ID).Err(); err != nil {
+		sc.logger.Error("[stream] ack failed", zap.String("id", msg.ID), zap.Error(err))
+	}
+}
+
+func (sc *StreamConsumer) deadLetter(ctx context.Context, msg redis.XMessage, deliveries int64) {
+	values := make(map[string]interface{}, len(msg.Values)+3)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values["_giu_origin_id"] = msg.ID
+	values["_giu_origin_stream"] = sc.params.Stream
+	values["_giu_deliveries"] = deliveries
+	// the streams may be in different slots, so it's not a transaction, the message is acked after it's added
+	if err := sc.rdb.XAdd(ctx, &redis.XAddArgs{Stream: sc.params.DeadLetterStream, Values: values}).Err(); err != nil {
+		sc.logger.Error("[stream] dead letter failed", zap.String("id", msg.ID), zap.Error(err))
+		return
+	}
+	if err := sc.rdb.XAck(ctx, sc.params.Stream, sc.params.Group, msg.ID).Err(); err != nil {
+		sc.logger.Error("[stream] ack failed", zap.String("id", msg.ID), zap.Error(err))
+		return
+	}
+	sc.logger.Warn("[stream] message moved to dead letter stream", zap.String("id", msg.ID), zap.Int64("deliveries", deliveries))
+}
+
+// sleepContext sleeps d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,336 @@
+package giu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	METRICS_WRITER_INFLUX    = "influx"
+	METRICS_WRITER_TIMESCALE = "timescale"
+)
+
+var ERR_METRICS_WRITER_CLOSED = errors.New("metrics writer is closed")
+
+type MetricsWriterParams struct {
+	// Type is "influx" or "timescale".
+	Type string
+	// URL, Token, Org and Bucket are the influxdb v2 write api params.
+	URL    string
+	Token  string
+	Org    string
+	Bucket string
+	// Connection is the gorm connection name of timescale, default is the default connection.
+	Connection string
+	// Table is the timescale table, default is "giu_metrics".
+	Table string
+	// BatchSize is the max points of each write, default is 1000.
+	BatchSize int
+	// BufferSize is the max buffered points, points are dropped when it's full, default is 10 * BatchSize.
+	BufferSize int
+	// FlushInterval is the max interval of writes, default is 1s.
+	FlushInterval time.Duration
+	// MaxRetries is the max retries of a failed write, default is 3.
+	MaxRetries int
+	// RetryInterval is the interval of retries, it's doubled each retry, default is 1s.
+	RetryInterval time.Duration
+}
+
+// MetricPoint is a point of time series.
+type MetricPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// MetricsSink writes a batch of points to the time series database.
+type MetricsSink func(ctx context.Context, points []MetricPoint) error
+
+// MetricsWriter buffers the points and writes them in batches asynchronously.
+type MetricsWriter struct {
+	params MetricsWriterParams
+	sink   MetricsSink
+	logger *zap.Logger
+	lock   sync.Mutex
+	buffer []MetricPoint
+	notify chan struct{}
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewMetricsWriter creates a writer with params type, db is required for timescale only.
+func NewMetricsWriter(params MetricsWriterParams, db *gorm.DB, zl *zap.Logger) (*MetricsWriter, error) {
+	var sink MetricsSink
+	switch params.Type {
+	case METRICS_WRITER_INFLUX:
+		sink = NewInfluxSink(params)
+	case METRICS_WRITER_TIMESCALE:
+		if db == nil {
+			return nil, errors.New("timescale metrics writer needs a gorm connection")
+		}
+		sink = NewTimescaleSink(db, params.Table)
+	default:
+		return nil, fmt.Errorf("unsupported metrics writer type: %s", params.Type)
+	}
+	return NewMetricsWriterWithSink(params, sink, zl), nil
+}
+
+// NewMetricsWriterFromProvider creates a writer, the timescale connection is got from gp with params connection.
+func NewMetricsWriterFromProvider(params MetricsWriterParams, gp GormProvider, zl *zap.Logger) (*MetricsWriter, error) {
+	var db *gorm.DB
+	if params.Type == METRICS_WRITER_TIMESCALE {
+		if params.Connection == "" {
+			db = gp.Default()
+		} else if conn, ok := gp.Get(params.Connection); ok {
+			db = conn
+		} else {
+			return nil, fmt.Errorf("gorm connection %s not found", params.Connection)
+		}
+	}
+	return NewMetricsWriter(params, db, zl)
+}
+
+// NewMetricsWriterWithSink creates a writer of a custom sink and starts the flushing goroutine.
+func NewMetricsWriterWithSink(params MetricsWriterParams, sink MetricsSink, zl *zap.Logger) *MetricsWriter {
+	if params.BatchSize <= 0 {
+		params.BatchSize = 1000
+	}
+	if params.BufferSize <= 0 {
+		params.BufferSize = 10 * params.BatchSize
+	}
+	if params.FlushInterval <= 0 {
+		params.FlushInterval = time.Second
+	}
+	if params.MaxRetries <= 0 {
+		params.MaxRetries = 3
+	}
+	if params.RetryInterval <= 0 {
+		params.RetryInterval = time.Second
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	w := &MetricsWriter{
+		params: params,
+		sink:   sink,
+		logger: zl.With(zap.String("module", "metrics_writer")),
+		notify: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write buffers the points, points without time are set to now.
+func (w *MetricsWriter) Write(points ...MetricPoint) error {
+	select {
+	case <-w.closed:
+		return ERR_METRICS_WRITER_CLOSED
+	default:
+	}
+	now := time.Now()
+	w.lock.Lock()
+	dropped := 0
+	for _, p := range points {
+		if len(w.buffer) >= w.params.BufferSize {
+			dropped++
+			continue
+		}
+		if p.Time.IsZero() {
+			p.Time = now
+		}
+		w.buffer = append(w.buffer, p)
+	}
+	full := len(w.buffer) >= w.params.BatchSize
+	w.lock.Unlock()
+	if dropped > 0 {
+		w.logger.Warn("[metrics_writer] buffer is full, points dropped", zap.Int("dropped", dropped))
+	}
+	if full {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (w *MetricsWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.params.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closed:
+			w.flush(context.Background())
+			return
+		case <-ticker.C:
+		case <-w.notify:
+		}
+		w.flush(context.Background())
+	}
+}
+
+// flush writes all buffered points in batches.
+func (w *MetricsWriter) flush(ctx context.Context) {
+	for {
+		w.lock.Lock()
+		n := len(w.buffer)
+		if n > w.params.BatchSize {
+			n = w.params.BatchSize
+		}
+		batch := w.buffer[:n:n]
+		w.buffer = w.buffer[n:]
+		w.lock.Unlock()
+		if n == 0 {
+			return
+		}
+		w.write(ctx, batch)
+	}
+}
+
+func (w *MetricsWriter) write(ctx context.Context, batch []MetricPoint) {
+	policy := RetryPolicy{
+		MaxAttempts:     w.params.MaxRetries + 1,
+		InitialInterval: w.params.RetryInterval,
+		Logger:          w.logger,
+	}
+	err := Retry(ctx, policy, func(ctx context.Context) error {
+		return w.sink(ctx, batch)
+	})
+	if err != nil {
+		w.logger.Error("[metrics_writer] write failed, points dropped", zap.Int("points", len(batch)), zap.Error(err))
+	}
+}
+
+// Shutdown stops accepting points and flushes the buffered points.
+func (w *MetricsWriter) Shutdown() error {
+	w.once.Do(func() {
+		close(w.closed)
+	})
+	<-w.done
+	return nil
+}
+
+// NewInfluxSink writes points to the influxdb v2 write api in line protocol.
+func NewInfluxSink(params MetricsWriterParams) MetricsSink {
+	client := resty.New().SetBaseURL(strings.TrimSuffix(params.URL, "/")).SetTimeout(10 * time.Second)
+	if params.Token != "" {
+		client.SetHeader("Authorization", "Token "+params.Token)
+	}
+	return func(ctx context.Context, points []MetricPoint) error {
+		var sb strings.Builder
+		for _, p := range points {
+			writeLineProtocol(&sb, p)
+		}
+		resp, err := client.R().SetContext(ctx).
+			SetQueryParams(map[string]string{"org": params.Org, "bucket": params.Bucket, "precision": "ns"}).
+			SetHeader("Content-Type", "text/plain; charset=utf-8").
+			SetBody(sb.String()).
+			Post("/api/v2/write")
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			return fmt.Errorf("influx write failed: %s %s", resp.Status(), resp.String())
+		}
+		return nil
+	}
+}
+
+var (
+	lineProtocolMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	lineProtocolKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	lineProtocolStringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+func writeLineProtocol(sb *strings.Builder, p MetricPoint) {
+	sb.WriteString(lineProtocolMeasurementEscaper.Replace(p.Measurement))
+	for _, k := range sortedKeys(p.Tags) {
+		sb.WriteByte(',')
+		sb.WriteString(lineProtocolKeyEscaper.Replace(k))
+		sb.WriteByte('=')
+		sb.WriteString(lineProtocolKeyEscaper.Replace(p.Tags[k]))
+	}
+	for i, k := range sortedKeys(p.Fields) {
+		if i == 0 {
+			sb.WriteByte(' ')
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(lineProtocolKeyEscaper.Replace(k))
+		sb.WriteByte('=')
+		switch v := p.Fields[k].(type) {
+		case int, int8, int16, int32, int64:
+			sb.WriteString(fmt.Sprintf("%di", v))
+		case uint, uint8, uint16, uint32, uint64:
+			sb.WriteString(fmt.Sprintf("%du", v))
+		case float32:
+			sb.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+		case float64:
+			sb.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		case bool:
+			sb.WriteString(strconv.FormatBool(v))
+		default:
+			sb.WriteByte('"')
+			sb.WriteString(lineProtocolStringEscaper.Replace(fmt.Sprint(v)))
+			sb.WriteByte('"')
+		}
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(p.Time.UnixNano(), 10))
+	sb.WriteByte('\n')
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TimescaleMetric is a row of the timescale metrics table, the table should be a hypertable partitioned by time.
+type TimescaleMetric struct {
+	Time        time.Time `gorm:"index;not null"`
+	Measurement string    `gorm:"size:128;index"`
+	Tags        string    `gorm:"type:jsonb"`
+	Fields      string    `gorm:"type:jsonb"`
+}
+
+// NewTimescaleSink writes points to table with gorm, default table is "giu_metrics".
+func NewTimescaleSink(db *gorm.DB, table string) MetricsSink {
+	if table == "" {
+		table = "giu_metrics"
+	}
+	return func(ctx context.Context, points []MetricPoint) error {
+		rows := make([]TimescaleMetric, len(points))
+		for i, p := range points {
+			tags, err := json.Marshal(p.Tags)
+			if err != nil {
+				return err
+			}
+			fields, err := json.Marshal(p.Fields)
+			if err != nil {
+				return err
+			}
+			rows[i] = TimescaleMetric{Time: p.Time, Measurement: p.Measurement, Tags: string(tags), Fields: string(fields)}
+		}
+		return db.WithContext(ctx).Table(table).Create(&rows).Error
+	}
+}
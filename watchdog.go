@@ -0,0 +1,257 @@
+package giu
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type WatchdogParams struct {
+	// Interval is the sampling interval, default is 30s.
+	Interval time.Duration
+	// MaxGoroutines is the goroutine count threshold, 0 means disabled.
+	MaxGoroutines int
+	// MaxOpenFDs is the open file descriptor threshold, 0 means disabled. Only works on linux.
+	MaxOpenFDs int
+	// MaxHeapMB is the heap in use threshold in megabytes, 0 means disabled.
+	MaxHeapMB uint64
+	// MaxDBPoolUsage is the ratio of in use connections to max open connections, 0 means disabled.
+	MaxDBPoolUsage float64
+	// MaxCPU is the ratio of process cpu time to GOMAXPROCS between two samples, 0 means disabled. Only works on unix.
+	MaxCPU float64
+}
+
+var _defaultWatchdogParams = WatchdogParams{
+	Interval: 30 * time.Second,
+}
+
+const (
+	WATCHDOG_METRIC_GOROUTINES   = "goroutines"
+	WATCHDOG_METRIC_OPEN_FDS     = "open_fds"
+	WATCHDOG_METRIC_HEAP_MB      = "heap_mb"
+	WATCHDOG_METRIC_DB_POOL_USED = "db_pool_usage"
+	WATCHDOG_METRIC_CPU          = "cpu"
+)
+
+// WatchdogStats is a single sample of the process resources.
+type WatchdogStats struct {
+	Goroutines  int
+	OpenFDs     int // -1 if it's not available on current platform
+	HeapMB      uint64
+	DBPoolUsage map[string]float64
+	CPU         float64 // -1 if it's not available on current platform, 0 on the first sample
+}
+
+// WatchdogAlert is fired when a sampled value crosses its threshold.
+type WatchdogAlert struct {
+	Metric    string
+	Name      string // db connection name, only set for db pool usage
+	Value     float64
+	Threshold float64
+}
+
+type WatchdogHook func(alert WatchdogAlert)
+
+type Watchdog struct {
+	params WatchdogParams
+	logger *zap.Logger
+	lock   sync.RWMutex
+	dbs    map[string]*gorm.DB
+	hooks  []WatchdogHook
+	stop   chan struct{}
+	once   sync.Once
+
+	cpuLock   sync.Mutex
+	lastCPU   time.Duration
+	lastCPUAt time.Time
+}
+
+// NewWatchdog creates a watchdog, call Start to begin sampling.
+func NewWatchdog(params WatchdogParams, zl *zap.Logger) *Watchdog {
+	if params.Interval <= 0 {
+		params.Interval = _defaultWatchdogParams.Interval
+	}
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	return &Watchdog{
+		params: params,
+		logger: zl.With(zap.String("module", "watchdog")),
+		dbs:    make(map[string]*gorm.DB),
+		stop:   make(chan struct{}),
+	}
+}
+
+func DefaultWatchdog(zl *zap.Logger) *Watchdog {
+	return NewWatchdog(_defaultWatchdogParams, zl)
+}
+
+// NewWatchdogFromConfig creates a watchdog from viper config with key "watchdog".
+func NewWatchdogFromConfig(config *viper.Viper, zl *zap.Logger) (*Watchdog, error) {
+	var params WatchdogParams
+	if err := config.UnmarshalKey("watchdog", &params); err != nil {
+		return nil, err
+	}
+	return NewWatchdog(params, zl), nil
+}
+
+// WatchGorm adds a gorm connection whose pool usage will be sampled.
+func (w *Watchdog) WatchGorm(name string, db *gorm.DB) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.dbs[name] = db
+}
+
+// AddHook adds a hook which will be called when a threshold is crossed.
+func (w *Watchdog) AddHook(hook WatchdogHook) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.hooks = append(w.hooks, hook)
+}
+
+// Start starts sampling in a new goroutine.
+func (w *Watchdog) Start() {
+	go func() {
+		ticker := time.NewTicker(w.params.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.Check()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops sampling.
+func (w *Watchdog) Shutdown() error {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	return nil
+}
+
+// Sample returns the current resource usage of the process.
+func (w *Watchdog) Sample() WatchdogStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := WatchdogStats{
+		Goroutines:  runtime.NumGoroutine(),
+		OpenFDs:     countOpenFDs(),
+		HeapMB:      mem.HeapInuse / 1024 / 1024,
+		DBPoolUsage: make(map[string]float64),
+		CPU:         w.sampleCPU(),
+	}
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	for name, db := range w.dbs {
+		sqlDB, err := db.DB()
+		if err != nil {
+			continue
+		}
+		s := sqlDB.Stats()
+		if s.MaxOpenConnections > 0 {
+			stats.DBPoolUsage[name] = float64(s.InUse) / float64(s.MaxOpenConnections)
+		}
+	}
+	return stats
+}
+
+// sampleCPU returns the cpu usage since the previous call.
+func (w *Watchdog) sampleCPU() float64 {
+	used, ok := processCPUTime()
+	if !ok {
+		return -1
+	}
+	now := time.Now()
+	w.cpuLock.Lock()
+	defer w.cpuLock.Unlock()
+	last, lastAt := w.lastCPU, w.lastCPUAt
+	w.lastCPU, w.lastCPUAt = used, now
+	if lastAt.IsZero() {
+		return 0
+	}
+	wall := now.Sub(lastAt)
+	if wall <= 0 {
+		return 0
+	}
+	return float64(used-last) / float64(wall) / float64(runtime.GOMAXPROCS(0))
+}
+
+// Check samples once, logs warnings and fires hooks for every crossed threshold.
+func (w *Watchdog) Check() WatchdogStats {
+	stats := w.Sample()
+	p := w.params
+	if p.MaxGoroutines > 0 && stats.Goroutines > p.MaxGoroutines {
+		w.alert(WatchdogAlert{Metric: WATCHDOG_METRIC_GOROUTINES, Value: float64(stats.Goroutines), Threshold: float64(p.MaxGoroutines)})
+	}
+	if p.MaxOpenFDs > 0 && stats.OpenFDs > p.MaxOpenFDs {
+		w.alert(WatchdogAlert{Metric: WATCHDOG_METRIC_OPEN_FDS, Value: float64(stats.OpenFDs), Threshold: float64(p.MaxOpenFDs)})
+	}
+	if p.MaxHeapMB > 0 && stats.HeapMB > p.MaxHeapMB {
+		w.alert(WatchdogAlert{Metric: WATCHDOG_METRIC_HEAP_MB, Value: float64(stats.HeapMB), Threshold: float64(p.MaxHeapMB)})
+	}
+	if p.MaxCPU > 0 && stats.CPU > p.MaxCPU {
+		w.alert(WatchdogAlert{Metric: WATCHDOG_METRIC_CPU, Value: stats.CPU, Threshold: p.MaxCPU})
+	}
+	if p.MaxDBPoolUsage > 0 {
+		for name, usage := range stats.DBPoolUsage {
+			if usage > p.MaxDBPoolUsage {
+				w.alert(WatchdogAlert{Metric: WATCHDOG_METRIC_DB_POOL_USED, Name: name, Value: usage, Threshold: p.MaxDBPoolUsage})
+			}
+		}
+	}
+	return stats
+}
+
+func (w *Watchdog) alert(a WatchdogAlert) {
+	w.logger.Warn("[watchdog] threshold exceeded",
+		zap.String("metric", a.Metric),
+		zap.String("name", a.Name),
+		zap.Float64("value", a.Value),
+		zap.Float64("threshold", a.Threshold))
+	w.lock.RLock()
+	hooks := w.hooks
+	w.lock.RUnlock()
+	for _, hook := range hooks {
+		hook(a)
+	}
+}
+
+// countOpenFDs returns the number of open file descriptors, -1 if it's not available.
+func countOpenFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// NewWatchdogAlerterHook returns a watchdog hook which sends the alert with alerter.
+func NewWatchdogAlerterHook(alerter Alerter) WatchdogHook {
+	return func(a WatchdogAlert) {
+		fields := map[string]string{
+			"metric":    a.Metric,
+			"value":     strconv.FormatFloat(a.Value, 'f', -1, 64),
+			"threshold": strconv.FormatFloat(a.Threshold, 'f', -1, 64),
+		}
+		if a.Name != "" {
+			fields["name"] = a.Name
+		}
+		_ = alerter.Alert(context.Background(), Alert{
+			Level:  LOG_LEVEL_WARN,
+			Title:  "[watchdog] threshold exceeded: " + a.Metric,
+			Fields: fields,
+			Time:   time.Now(),
+		})
+	}
+}